@@ -17,7 +17,7 @@ export default function Login({ onLogin }) {
         const API_BASE = import.meta.env.VITE_API_URL || (isLocal ? 'http://localhost:8080' : 'https://nexus-search-1.onrender.com');
 
         console.log("Current API_BASE:", API_BASE);
-        const endpoint = isRegister ? `${API_BASE}/api/register` : `${API_BASE}/api/login`;
+        const endpoint = isRegister ? `${API_BASE}/api/v1/register` : `${API_BASE}/api/v1/login`;
 
         try {
             const res = await fetch(endpoint, {
@@ -27,8 +27,8 @@ export default function Login({ onLogin }) {
             });
 
             if (!res.ok) {
-                const text = await res.text();
-                throw new Error(text || 'Action failed');
+                const body = await res.json().catch(() => null);
+                throw new Error(body?.error?.message || 'Action failed');
             }
 
             if (!isRegister) {
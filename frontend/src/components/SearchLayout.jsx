@@ -28,7 +28,7 @@ export default function SearchLayout({ token }) {
                 pkb: isPKB
             });
 
-            const res = await fetch(`${API_BASE}/api/search?${params.toString()}`, {
+            const res = await fetch(`${API_BASE}/api/v1/search?${params.toString()}`, {
                 headers: { 'Authorization': `Bearer ${token}` }
             });
 
@@ -54,7 +54,7 @@ export default function SearchLayout({ token }) {
         formData.append('file', file);
 
         try {
-            const res = await fetch(`${API_BASE}/api/upload`, {
+            const res = await fetch(`${API_BASE}/api/v1/upload`, {
                 method: 'POST',
                 headers: {
                     'Authorization': `Bearer ${token}`
@@ -64,8 +64,8 @@ export default function SearchLayout({ token }) {
             });
 
             if (!res.ok) {
-                const text = await res.text();
-                throw new Error(text || 'Upload failed');
+                const body = await res.json().catch(() => null);
+                throw new Error(body?.error?.message || 'Upload failed');
             }
 
             alert(`File ${file.name} uploaded successfully!`);
@@ -9,7 +9,7 @@ export default function StorageBar({ token, triggerUpdate }) {
         const API_BASE = import.meta.env.VITE_API_URL || (isLocal ? 'http://localhost:8080' : 'https://nexus-search-1.onrender.com');
 
         try {
-            const res = await fetch(`${API_BASE}/api/user`, {
+            const res = await fetch(`${API_BASE}/api/v1/user`, {
                 headers: { 'Authorization': `Bearer ${token}` }
             });
             if (res.ok) {
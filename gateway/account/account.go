@@ -0,0 +1,254 @@
+package account
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/audit"
+	"nexus-gateway/auth"
+	"nexus-gateway/store"
+)
+
+// DocumentInfo is the metadata of one uploaded file
+type DocumentInfo struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	Chunks    int       `json:"chunks"`
+	SizeBytes int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Chunk is the exported form of a stored chunk; embeddings are left out as
+// they can be regenerated from the text. DocumentID is empty for chunks
+// uploaded before documents had IDs.
+type Chunk struct {
+	DocumentID string `json:"document_id,omitempty"`
+	Filename   string `json:"filename"`
+	ChunkIndex int    `json:"chunk_index"`
+	Content    string `json:"content"`
+}
+
+// documentInfo lists one entry per document ID, so files uploaded under the
+// same name stay apart
+func documentInfo(docs []store.Document) []DocumentInfo {
+	info := make([]DocumentInfo, 0, len(docs))
+	for _, d := range docs {
+		info = append(info, DocumentInfo{
+			ID:        d.ID.Hex(),
+			Filename:  d.Filename,
+			Chunks:    d.Chunks,
+			SizeBytes: d.SizeBytes,
+			CreatedAt: d.CreatedAt,
+		})
+	}
+	return info
+}
+
+// ExportHandler streams a zip archive with everything stored about the
+// caller: profile.json, documents.json and chunks.jsonl. Search queries are
+// not persisted, so there is no search history to include.
+func ExportHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+		username := r.Context().Value("user").(string)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+		defer cancel()
+
+		user, err := db.UserByName(ctx, username)
+		if err != nil {
+			apierror.NotFound(w, r, "User not found")
+			return
+		}
+		user.Password = ""
+		user.Normalize()
+
+		docs, err := db.DocumentsByUser(ctx, user.ID)
+		if err != nil {
+			apierror.Internal(w, r, "Export failed", err)
+			return
+		}
+		chunks, err := db.ChunksByUser(ctx, user.ID)
+		if err != nil {
+			apierror.Internal(w, r, "Export failed", err)
+			return
+		}
+
+		filename := fmt.Sprintf("nexus-export-%s-%s.zip", username, time.Now().UTC().Format("20060102"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		// Headers are sent from here on, so errors can only be logged
+		zw := zip.NewWriter(w)
+		if err := writeJSON(zw, "profile.json", user); err != nil {
+			slog.ErrorContext(ctx, "account export failed", "error", err)
+			return
+		}
+		if err := writeJSON(zw, "documents.json", documentInfo(docs)); err != nil {
+			slog.ErrorContext(ctx, "account export failed", "error", err)
+			return
+		}
+
+		f, err := zw.Create("chunks.jsonl")
+		if err != nil {
+			slog.ErrorContext(ctx, "account export failed", "error", err)
+			return
+		}
+		enc := json.NewEncoder(f)
+		for _, c := range chunks {
+			out := Chunk{Filename: c.Filename, ChunkIndex: c.ChunkIndex, Content: c.Content}
+			if c.DocumentID != nil {
+				out.DocumentID = c.DocumentID.Hex()
+			}
+			if err := enc.Encode(out); err != nil {
+				slog.ErrorContext(ctx, "account export failed", "error", err)
+				return
+			}
+		}
+
+		if err := zw.Close(); err != nil {
+			slog.ErrorContext(ctx, "account export failed", "error", err)
+		}
+	}
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// DeletionResponse tells the user when their account will be deleted
+type DeletionResponse struct {
+	Status       string    `json:"status"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+}
+
+// DeleteHandler schedules the caller's account for deletion after the grace
+// period, during which logging in restores it, and ends all of their
+// sessions. The data itself is removed by RunPurgeJob.
+func DeleteHandler(db store.Store, gracePeriod time.Duration, cookies auth.CookieOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+		username := r.Context().Value("user").(string)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		scheduledFor := time.Now().UTC().Add(gracePeriod)
+		update := store.UserUpdate{DeletionScheduledFor: &scheduledFor, EndSessions: true}
+		_, err := db.UpdateUser(ctx, username, update)
+		if err == store.ErrNotFound {
+			apierror.NotFound(w, r, "User not found")
+			return
+		}
+		if err != nil {
+			apierror.Internal(w, r, "Failed to delete account", err)
+			return
+		}
+
+		audit.RecordRequest(r, db, audit.Event{
+			Actor:   username,
+			Action:  "account.delete.request",
+			Target:  username,
+			Details: map[string]interface{}{"scheduled_for": scheduledFor},
+		})
+
+		// Clear the auth cookie for browser sessions
+		auth.ClearSessionCookies(w, cookies)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(DeletionResponse{Status: "scheduled", ScheduledFor: scheduledFor})
+	}
+}
+
+// RunPurgeJob deletes accounts whose grace period has passed, every interval,
+// until ctx is cancelled.
+func RunPurgeJob(ctx context.Context, db store.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Let a purge that has started finish when ctx is cancelled
+		if n, err := PurgeExpired(context.WithoutCancel(ctx), db); err != nil {
+			slog.Error("account purge failed", "error", err)
+		} else if n > 0 {
+			slog.Info("purged deleted accounts", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeExpired removes every account past its deletion date together with
+// all of its chunks, and returns how many accounts were removed.
+func PurgeExpired(ctx context.Context, db store.Store) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	expired, err := db.UsersDueForDeletion(ctx, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, u := range expired {
+		// Disable the account first so a concurrent login can no longer
+		// restore it while its documents are being removed.
+		claimed, err := db.ClaimForDeletion(ctx, u.ID, time.Now().UTC())
+		if err != nil {
+			return purged, err
+		}
+		if !claimed {
+			continue
+		}
+
+		// A failure here leaves the disabled user record behind to retry from
+		chunks, err := db.DeleteChunksByUser(ctx, u.ID)
+		if err != nil {
+			return purged, err
+		}
+		if err := db.DeleteDocumentsByUser(ctx, u.ID); err != nil {
+			return purged, err
+		}
+		if err := db.DeleteSharesByOwner(ctx, u.ID); err != nil {
+			return purged, err
+		}
+		if err := db.RemoveMemberships(ctx, u.ID); err != nil {
+			return purged, err
+		}
+		if err := db.DeleteUser(ctx, u.ID); err != nil {
+			return purged, err
+		}
+		purged++
+
+		audit.Record(ctx, db, audit.Event{
+			Actor:   "system",
+			Action:  "account.delete.purge",
+			Target:  u.Username,
+			Details: map[string]interface{}{"chunks_deleted": chunks},
+		})
+	}
+	return purged, nil
+}
@@ -0,0 +1,321 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/audit"
+	"nexus-gateway/auth"
+	"nexus-gateway/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserUsage is a user's profile together with what they are storing
+type UserUsage struct {
+	auth.User
+	Documents int64 `json:"documents"`
+	Chunks    int64 `json:"chunks"`
+}
+
+// QuotaRequest changes a user's quota and/or plan. Username is only read
+// on the deprecated routes; /api/v1 takes it from the path, as do the other
+// requests.
+type QuotaRequest struct {
+	Username   string `json:"username,omitempty"`
+	QuotaBytes *int64 `json:"quota_bytes,omitempty"`
+	Plan       string `json:"plan,omitempty"`
+}
+
+type RoleRequest struct {
+	Username string `json:"username,omitempty"`
+	Role     string `json:"role"`
+}
+
+// DisableRequest is the body of the deprecated /api/admin/users/disable,
+// which disables the user, or re-enables them when Disabled is false.
+// /api/v1 has separate disable and enable routes.
+type DisableRequest struct {
+	Username string `json:"username,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+type logoutRequest struct {
+	Username string `json:"username"`
+}
+
+// decodeUserRequest reads the JSON body into req. On /api/v1 routes the
+// username comes from the {username} path parameter and the body may be
+// empty. It reports whether the request is usable.
+func decodeUserRequest(r *http.Request, req interface{}, username *string) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+		return false
+	}
+	if name := r.PathValue("username"); name != "" {
+		*username = name
+	}
+	return *username != ""
+}
+
+func actor(r *http.Request) string {
+	username, _ := r.Context().Value("user").(string)
+	return username
+}
+
+// ListUsersHandler returns users page by page (?limit=&skip=), without passwords
+func ListUsersHandler(users store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+
+		limit, _ := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64)
+		if limit <= 0 || limit > 500 {
+			limit = 100
+		}
+		skip, _ := strconv.ParseInt(r.URL.Query().Get("skip"), 10, 64)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		result, err := users.ListUsers(ctx, skip, limit)
+		if err != nil {
+			apierror.Internal(w, r, "Failed to list users", err)
+			return
+		}
+		for i := range result {
+			result[i].Password = ""
+			result[i].Normalize()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// UsageHandler reports storage and document counts for every user, or for a
+// single one with ?username=
+func UsageHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		var list []auth.User
+		if username := r.URL.Query().Get("username"); username != "" {
+			user, err := db.UserByName(ctx, username)
+			if err != nil && err != store.ErrNotFound {
+				apierror.Internal(w, r, "Failed to load users", err)
+				return
+			}
+			if user != nil {
+				list = append(list, *user)
+			}
+		} else {
+			var err error
+			if list, err = db.ListUsers(ctx, 0, 0); err != nil {
+				apierror.Internal(w, r, "Failed to load users", err)
+				return
+			}
+		}
+
+		ids := make([]primitive.ObjectID, 0, len(list))
+		for _, u := range list {
+			ids = append(ids, u.ID)
+		}
+
+		stats, err := db.ChunkStats(ctx, ids)
+		if err != nil {
+			apierror.Internal(w, r, "Failed to compute usage", err)
+			return
+		}
+
+		result := make([]UserUsage, 0, len(list))
+		for _, u := range list {
+			u.Password = ""
+			u.Normalize()
+			usage := UserUsage{User: u}
+			if st, ok := stats[u.ID]; ok {
+				usage.Chunks = st.Chunks
+				usage.Documents = st.Documents
+			}
+			result = append(result, usage)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// UpdateQuotaHandler changes a user's storage quota and/or plan, which must
+// be one knownPlan accepts. The plan is in the token and picks the rate
+// limits, so changing it ends the user's sessions.
+func UpdateQuotaHandler(db store.Store, knownPlan func(plan string) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+
+		var req QuotaRequest
+		if !decodeUserRequest(r, &req, &req.Username) {
+			apierror.BadRequest(w, r, "Invalid request")
+			return
+		}
+
+		var update store.UserUpdate
+		if req.QuotaBytes != nil {
+			if *req.QuotaBytes <= 0 {
+				apierror.BadRequest(w, r, "quota_bytes must be positive")
+				return
+			}
+			update.QuotaBytes = req.QuotaBytes
+		}
+		if req.Plan != "" {
+			if !knownPlan(req.Plan) {
+				apierror.BadRequest(w, r, "Unknown plan")
+				return
+			}
+			update.Plan = &req.Plan
+			update.EndSessions = true
+		}
+		if update.QuotaBytes == nil && update.Plan == nil {
+			apierror.BadRequest(w, r, "Nothing to update")
+			return
+		}
+
+		updateUser(w, r, db, req.Username, update, "admin.quota.update", map[string]interface{}{
+			"quota_bytes": req.QuotaBytes,
+			"plan":        req.Plan,
+		})
+	}
+}
+
+// UpdateRoleHandler grants or revokes a role. Existing sessions are ended so
+// the new role takes effect on the next login.
+func UpdateRoleHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+
+		var req RoleRequest
+		if !decodeUserRequest(r, &req, &req.Username) {
+			apierror.BadRequest(w, r, "Invalid request")
+			return
+		}
+		if !auth.ValidRole(req.Role) {
+			apierror.BadRequest(w, r, "Unknown role")
+			return
+		}
+		if req.Username == actor(r) && req.Role != auth.RoleAdmin {
+			apierror.BadRequest(w, r, "Cannot remove your own admin role")
+			return
+		}
+
+		update := store.UserUpdate{Role: &req.Role, EndSessions: true}
+		updateUser(w, r, db, req.Username, update, "admin.role.update", map[string]interface{}{
+			"role": req.Role,
+		})
+	}
+}
+
+// DisableHandler disables the account in the path, or re-enables it if
+// disabled is false. Disabling also ends all of the user's sessions.
+func DisableHandler(db store.Store, disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setDisabled(w, r, db, r.PathValue("username"), disabled)
+	}
+}
+
+// LegacyDisableHandler serves the deprecated route, which names the user
+// and the new state in a DisableRequest
+func LegacyDisableHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+
+		var req DisableRequest
+		if !decodeUserRequest(r, &req, &req.Username) {
+			apierror.BadRequest(w, r, "Invalid request")
+			return
+		}
+		setDisabled(w, r, db, req.Username, req.Disabled)
+	}
+}
+
+func setDisabled(w http.ResponseWriter, r *http.Request, db store.Store, username string, disabled bool) {
+	if username == actor(r) && disabled {
+		apierror.BadRequest(w, r, "Cannot disable your own account")
+		return
+	}
+
+	update := store.UserUpdate{Disabled: &disabled}
+	action := "admin.user.enable"
+	if disabled {
+		update.EndSessions = true
+		action = "admin.user.disable"
+	}
+	updateUser(w, r, db, username, update, action, nil)
+}
+
+// ForceLogoutHandler invalidates every token issued to a user
+func ForceLogoutHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+
+		var req logoutRequest
+		if !decodeUserRequest(r, &req, &req.Username) {
+			apierror.BadRequest(w, r, "Invalid request")
+			return
+		}
+
+		update := store.UserUpdate{EndSessions: true}
+		updateUser(w, r, db, req.Username, update, "admin.user.logout", nil)
+	}
+}
+
+// updateUser applies update to username, records the audit event and writes
+// the updated user back to the client.
+func updateUser(w http.ResponseWriter, r *http.Request, db store.Store, username string, update store.UserUpdate, action string, details map[string]interface{}) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := db.UpdateUser(ctx, username, update)
+	if err == store.ErrNotFound {
+		apierror.NotFound(w, r, "User not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(w, r, "Failed to update user", err)
+		return
+	}
+
+	audit.RecordRequest(r, db, audit.Event{
+		Actor:   actor(r),
+		Action:  action,
+		Target:  username,
+		Details: details,
+	})
+
+	user.Password = ""
+	user.Normalize()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
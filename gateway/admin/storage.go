@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"context"
+
+	"nexus-gateway/store"
+)
+
+// StoredBytes is what chunks add up to against a quota. Every chunk carries
+// the size of the whole upload it came from, and each upload has exactly one
+// chunk 0, so only those are counted.
+func StoredBytes(chunks []store.Chunk) int64 {
+	var total int64
+	for _, c := range chunks {
+		if c.ChunkIndex == 0 {
+			total += c.SizeBytes
+		}
+	}
+	return total
+}
+
+// RecalculateStorage resets username's total_storage_bytes to what their
+// chunks actually take up, e.g. after a failed upload was counted
+func RecalculateStorage(ctx context.Context, db store.Store, username string) (*store.User, error) {
+	user, err := db.UserByName(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := db.ChunksByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	total := StoredBytes(chunks)
+	return db.UpdateUser(ctx, username, store.UserUpdate{TotalStorageBytes: &total})
+}
+
+// PurgeDocuments deletes everything username has uploaded, along with the
+// share links to it, and frees their quota. It returns the number of chunks
+// deleted.
+func PurgeDocuments(ctx context.Context, db store.Store, username string) (int64, error) {
+	user, err := db.UserByName(ctx, username)
+	if err != nil {
+		return 0, err
+	}
+	chunks, err := db.DeleteChunksByUser(ctx, user.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.DeleteDocumentsByUser(ctx, user.ID); err != nil {
+		return chunks, err
+	}
+	if err := db.DeleteSharesByOwner(ctx, user.ID); err != nil {
+		return chunks, err
+	}
+	var zero int64
+	_, err = db.UpdateUser(ctx, username, store.UserUpdate{TotalStorageBytes: &zero})
+	return chunks, err
+}
@@ -0,0 +1,149 @@
+// Package apierror writes every error the gateway returns in one JSON shape:
+//
+//	{"error": {"code": "not_found", "message": "Document not found", "request_id": "...", "details": {...}}}
+//
+// Clients should switch on code; message is for people and may change.
+package apierror
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Codes are part of the API: never change what an existing one means
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeForbidden          = "forbidden"
+	CodeAccountDisabled    = "account_disabled"
+	CodeInvalidCSRFToken   = "invalid_csrf_token"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeAlreadyExists      = "already_exists"
+	CodeGone               = "gone"
+	CodePayloadTooLarge    = "payload_too_large"
+	CodeQuotaExceeded      = "quota_exceeded"
+	CodeRateLimited        = "rate_limited"
+	CodeInternal           = "internal_error"
+	CodeUpstream           = "upstream_error"
+	CodeUnavailable        = "unavailable"
+)
+
+// Error is an error that is safe to show to clients as is
+type Error struct {
+	Status    int                    `json:"-"`
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// WithDetails returns a copy of e carrying details
+func (e *Error) WithDetails(details map[string]interface{}) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// Envelope is the body of every error response
+type Envelope struct {
+	Error *Error `json:"error"`
+}
+
+// Write sends err to the client. Anything but an *Error is an internal
+// failure: it is logged and the client only learns that something went wrong.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		slog.ErrorContext(r.Context(), "request failed", "error", err)
+		e = New(http.StatusInternalServerError, CodeInternal, "Internal server error")
+	}
+	c := *e
+	// Set by the RequestID middleware before any handler runs
+	c.RequestID = w.Header().Get("X-Request-ID")
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(c.Status)
+	json.NewEncoder(w).Encode(Envelope{Error: &c})
+}
+
+// Respond writes a new error with the given status, code and message
+func Respond(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	Write(w, r, New(status, code, message))
+}
+
+func BadRequest(w http.ResponseWriter, r *http.Request, message string) {
+	Respond(w, r, http.StatusBadRequest, CodeInvalidRequest, message)
+}
+
+func Unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	Respond(w, r, http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(w http.ResponseWriter, r *http.Request, message string) {
+	Respond(w, r, http.StatusForbidden, CodeForbidden, message)
+}
+
+func NotFound(w http.ResponseWriter, r *http.Request, message string) {
+	Respond(w, r, http.StatusNotFound, CodeNotFound, message)
+}
+
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	Respond(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+}
+
+// Internal logs err, if any, and sends a 500 with message, which must not
+// contain internal details
+func Internal(w http.ResponseWriter, r *http.Request, message string, err error) {
+	if err != nil {
+		slog.ErrorContext(r.Context(), message, "error", err)
+	}
+	Respond(w, r, http.StatusInternalServerError, CodeInternal, message)
+}
+
+// Mux serves mux, answering requests that match no route with an error
+// envelope rather than the mux's plain text 404 and 405 responses
+func Mux(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		// Let the mux work out the status and Allow header, then drop its body
+		rec := &headerRecorder{header: http.Header{}, status: http.StatusNotFound}
+		h.ServeHTTP(rec, r)
+		switch rec.status {
+		case http.StatusMethodNotAllowed:
+			allow := rec.header.Get("Allow")
+			w.Header().Set("Allow", allow)
+			Write(w, r, New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed").
+				WithDetails(map[string]interface{}{"allowed": strings.Split(allow, ", ")}))
+		case http.StatusNotFound:
+			NotFound(w, r, "Not found")
+		default:
+			mux.ServeHTTP(w, r)
+		}
+	})
+}
+
+type headerRecorder struct {
+	header http.Header
+	status int
+}
+
+func (rec *headerRecorder) Header() http.Header         { return rec.header }
+func (rec *headerRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (rec *headerRecorder) WriteHeader(status int)      { rec.status = status }
@@ -0,0 +1,184 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/clientip"
+	"nexus-gateway/store"
+)
+
+// Outcomes of an audited action
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	// OutcomeDenied is used when the caller was not allowed to do the action
+	OutcomeDenied = "denied"
+)
+
+// Event and Filter are defined in the store package, which keeps the log
+type (
+	Event  = store.Event
+	Filter = store.EventFilter
+)
+
+// Record appends an event to the audit log. Failures are logged rather than
+// returned so that auditing never breaks the calling request.
+func Record(ctx context.Context, log store.AuditStore, e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.Outcome == "" {
+		e.Outcome = OutcomeSuccess
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := log.AppendEvent(ctx, e); err != nil {
+		slog.ErrorContext(ctx, "failed to write audit event", "action", e.Action, "error", err)
+	}
+}
+
+// Requester returns an event with the authenticated actor, client IP and
+// user agent of r, for code that records events outside a handler
+func Requester(r *http.Request) Event {
+	actor, _ := r.Context().Value("user").(string)
+	return Event{Actor: actor, IP: clientip.FromRequest(r), UserAgent: r.UserAgent()}
+}
+
+// RecordRequest records e with the client IP, user agent and (unless set)
+// the authenticated actor taken from r
+func RecordRequest(r *http.Request, log store.AuditStore, e Event) {
+	who := Requester(r)
+	if e.Actor == "" {
+		e.Actor = who.Actor
+	}
+	if e.IP == "" {
+		e.IP = who.IP
+	}
+	if e.UserAgent == "" {
+		e.UserAgent = who.UserAgent
+	}
+	Record(r.Context(), log, e)
+}
+
+// Query returns matching events, newest first
+func Query(ctx context.Context, log store.AuditStore, f Filter) ([]Event, error) {
+	f.OldestFirst = false
+	events := []Event{}
+	err := log.EachEvent(ctx, f, func(e Event) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// parseFilter reads a Filter from the query string: actor, action, target,
+// outcome, ip, since and until (RFC 3339) and limit
+func parseFilter(r *http.Request, defaultLimit int64) (Filter, error) {
+	q := r.URL.Query()
+	f := Filter{
+		Actor:   q.Get("actor"),
+		Action:  q.Get("action"),
+		Target:  q.Get("target"),
+		Outcome: q.Get("outcome"),
+		IP:      q.Get("ip"),
+		Limit:   defaultLimit,
+	}
+	var err error
+	if v := q.Get("since"); v != "" {
+		if f.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if f.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.ParseInt(v, 10, 64); err != nil || f.Limit < 0 {
+			return f, strconv.ErrSyntax
+		}
+	}
+	return f, nil
+}
+
+// QueryHandler lets admins search the audit log (GET /api/v1/admin/audit)
+func QueryHandler(log store.AuditStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+
+		f, err := parseFilter(r, 100)
+		if err != nil {
+			apierror.BadRequest(w, r, "Invalid filter")
+			return
+		}
+		if f.Limit == 0 || f.Limit > 1000 {
+			f.Limit = 1000
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		events, err := Query(ctx, log, f)
+		if err != nil {
+			apierror.Internal(w, r, "Failed to query audit log", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+	}
+}
+
+// ExportHandler streams matching events as JSON Lines
+// (GET /api/v1/admin/audit/export). Without a limit every match is exported.
+func ExportHandler(log store.AuditStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+
+		f, err := parseFilter(r, 0)
+		if err != nil {
+			apierror.BadRequest(w, r, "Invalid filter")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+		defer cancel()
+
+		// The server's write timeout is shorter than a large export takes,
+		// and would cut the stream off without an error
+		deadline, _ := ctx.Deadline()
+		if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil {
+			slog.WarnContext(ctx, "audit export keeps the server write timeout", "error", err)
+		}
+
+		f.OldestFirst = true
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+
+		// Headers are sent once the first event is written, so errors can
+		// only be logged
+		enc := json.NewEncoder(w)
+		if err := log.EachEvent(ctx, f, func(e Event) error { return enc.Encode(e) }); err != nil {
+			slog.ErrorContext(ctx, "audit export failed", "error", err)
+		}
+	}
+}
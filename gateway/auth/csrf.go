@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// TokenCookie holds the session JWT (HttpOnly)
+	TokenCookie = "token"
+	// CSRFCookie holds the CSRF token so browser code can echo it back in
+	// CSRFHeader. It is deliberately readable from JavaScript.
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// CSRFToken derives the CSRF token bound to a session JWT. Because it is an
+// HMAC of the session token, it changes with every login and cannot be
+// forged without the server secret.
+func CSRFToken(secret, sessionToken string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("csrf:" + sessionToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidCSRFToken reports whether token is the CSRF token for sessionToken
+func ValidCSRFToken(secret, sessionToken, token string) bool {
+	if token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(CSRFToken(secret, sessionToken)))
+}
+
+// CookieOptions are the attributes of the session cookies. A cross-site
+// frontend that relies on cookies needs SameSite=None, which is always Secure.
+type CookieOptions struct {
+	SameSite http.SameSite
+	Secure   bool
+}
+
+// NewCookieOptions builds CookieOptions from a SameSite name (lax, strict or
+// none; default lax)
+func NewCookieOptions(sameSite string, secure bool) CookieOptions {
+	opts := CookieOptions{SameSite: http.SameSiteLaxMode, Secure: secure}
+	switch strings.ToLower(strings.TrimSpace(sameSite)) {
+	case "strict":
+		opts.SameSite = http.SameSiteStrictMode
+	case "none":
+		// Browsers reject SameSite=None cookies that are not Secure
+		opts.SameSite = http.SameSiteNoneMode
+		opts.Secure = true
+	}
+	return opts
+}
+
+// SetSessionCookies sets the session and CSRF cookies after a login
+func SetSessionCookies(w http.ResponseWriter, opts CookieOptions, sessionToken, csrfToken string, expires time.Time) {
+	sameSite, secure := opts.SameSite, opts.Secure
+	http.SetCookie(w, &http.Cookie{
+		Name:     TokenCookie,
+		Value:    sessionToken,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   secure,
+		SameSite: sameSite,
+	})
+}
+
+// ClearSessionCookies removes the session and CSRF cookies
+func ClearSessionCookies(w http.ResponseWriter, opts CookieOptions) {
+	sameSite, secure := opts.SameSite, opts.Secure
+	for _, name := range []string{TokenCookie, CSRFCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name == TokenCookie,
+			Secure:   secure,
+			SameSite: sameSite,
+		})
+	}
+}
@@ -3,20 +3,33 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"os"
+	"slices"
 	"time"
 
+	"nexus-gateway/apierror"
+	"nexus-gateway/audit"
+	"nexus-gateway/store"
+
 	"github.com/golang-jwt/jwt/v5"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
-type User struct {
-	Username          string `bson:"username" json:"username"`
-	Password          string `bson:"password,omitempty" json:"password"`
-	TotalStorageBytes int64  `bson:"total_storage_bytes" json:"total_storage_bytes"`
+// The user model lives in the store package; these keep the auth names
+// used throughout the gateway.
+const (
+	RoleUser  = store.RoleUser
+	RoleAdmin = store.RoleAdmin
+
+	PlanFree          = store.PlanFree
+	DefaultQuotaBytes = store.DefaultQuotaBytes
+)
+
+type User = store.User
+
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleAdmin
 }
 
 type Credentials struct {
@@ -24,131 +37,233 @@ type Credentials struct {
 	Password string `json:"password"`
 }
 
+// LoginResponse carries the session token for clients that don't use the
+// cookies. Cookie-based clients must send CSRFToken back in the
+// X-CSRF-Token header.
+type LoginResponse struct {
+	Token     string `json:"token"`
+	CSRFToken string `json:"csrf_token"`
+}
+
+// Claims are the contents of a session JWT. Role and Plan are a snapshot
+// taken at login, so changing either ends the user's sessions.
 type Claims struct {
-	Username string `json:"username"`
+	Username       string `json:"username"`
+	Role           string `json:"role,omitempty"`
+	Plan           string `json:"plan,omitempty"`
+	SessionVersion int    `json:"sv,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func RegisterHandler(client *mongo.Client) http.HandlerFunc {
+var (
+	ErrAccountDisabled = errors.New("account disabled")
+	ErrSessionRevoked  = errors.New("session revoked")
+)
+
+// recordAuth writes a login or registration attempt to the audit log, with
+// the claimed username as both actor and target
+func recordAuth(r *http.Request, events store.AuditStore, action, username, outcome, reason string) {
+	e := audit.Event{
+		Actor:   username,
+		Action:  action,
+		Target:  username,
+		Outcome: outcome,
+	}
+	if reason != "" {
+		e.Details = map[string]interface{}{"reason": reason}
+	}
+	audit.RecordRequest(r, events, e)
+}
+
+// HashPassword returns the bcrypt hash stored in User.Password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// NewUser returns a free-plan user with the default quota, ready to be
+// stored
+func NewUser(username, password string) (*User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return &User{
+		Username:   username,
+		Password:   hash,
+		Role:       RoleUser,
+		Plan:       PlanFree,
+		QuotaBytes: DefaultQuotaBytes,
+	}, nil
+}
+
+// RegisterHandler creates accounts. Usernames listed in adminUsers get the
+// admin role, which lets the first administrator be created without
+// touching the database.
+func RegisterHandler(db store.Store, adminUsers []string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var creds Credentials
 		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
+			apierror.BadRequest(w, r, "Invalid request")
 			return
 		}
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
+		newUser, err := NewUser(creds.Username, creds.Password)
 		if err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
+			apierror.Internal(w, r, "Server error", err)
 			return
 		}
+		if slices.Contains(adminUsers, creds.Username) {
+			newUser.Role = RoleAdmin
+		}
 
-		collection := client.Database("nexus_search").Collection("users")
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		// Check if user exists
-		var existing User
-		err = collection.FindOne(ctx, bson.M{"username": creds.Username}).Decode(&existing)
-		if err == nil {
-			http.Error(w, "User already exists", http.StatusConflict)
+		err = db.CreateUser(ctx, newUser)
+		if err == store.ErrDuplicate {
+			recordAuth(r, db, "auth.register", creds.Username, audit.OutcomeFailure, "user already exists")
+			apierror.Respond(w, r, http.StatusConflict, apierror.CodeAlreadyExists, "User already exists")
 			return
 		}
-
-		newUser := User{
-			Username:          creds.Username,
-			Password:          string(hashedPassword),
-			TotalStorageBytes: 0,
-		}
-
-		_, err = collection.InsertOne(ctx, newUser)
 		if err != nil {
-			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			apierror.Internal(w, r, "Failed to create user", err)
 			return
 		}
 
+		recordAuth(r, db, "auth.register", creds.Username, audit.OutcomeSuccess, "")
 		w.WriteHeader(http.StatusCreated)
 	}
 }
 
-func LoginHandler(client *mongo.Client) http.HandlerFunc {
+func LoginHandler(db store.Store, jwtSecret string, cookies CookieOptions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var creds Credentials
 		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
+			apierror.BadRequest(w, r, "Invalid request")
 			return
 		}
 
-		collection := client.Database("nexus_search").Collection("users")
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		var user User
-		err := collection.FindOne(ctx, bson.M{"username": creds.Username}).Decode(&user)
+		user, err := db.UserByName(ctx, creds.Username)
 		if err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			recordAuth(r, db, "auth.login", creds.Username, audit.OutcomeFailure, "unknown user")
+			apierror.Respond(w, r, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid credentials")
 			return
 		}
 
 		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password)); err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			recordAuth(r, db, "auth.login", creds.Username, audit.OutcomeFailure, "wrong password")
+			apierror.Respond(w, r, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid credentials")
+			return
+		}
+
+		if user.Disabled {
+			recordAuth(r, db, "auth.login", creds.Username, audit.OutcomeDenied, "account disabled")
+			apierror.Respond(w, r, http.StatusForbidden, apierror.CodeAccountDisabled, "Account disabled")
 			return
 		}
+		if user.DeletionScheduledFor != nil {
+			// Still within the grace period, so coming back restores the account
+			if _, err := db.UpdateUser(ctx, user.Username, store.UserUpdate{CancelDeletion: true}); err != nil {
+				apierror.Internal(w, r, "Server error", err)
+				return
+			}
+		}
+		user.Normalize()
 
 		// Generate JWT
 		expirationTime := time.Now().Add(24 * time.Hour)
 		claims := &Claims{
-			Username: creds.Username,
+			Username:       creds.Username,
+			Role:           user.Role,
+			Plan:           user.Plan,
+			SessionVersion: user.SessionVersion,
 			RegisteredClaims: jwt.RegisteredClaims{
 				ExpiresAt: jwt.NewNumericDate(expirationTime),
 			},
 		}
 
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		secret := []byte(os.Getenv("JWT_SECRET"))
-		tokenString, err := token.SignedString(secret)
+		tokenString, err := token.SignedString([]byte(jwtSecret))
 		if err != nil {
-			http.Error(w, "Server error", http.StatusInternalServerError)
+			apierror.Internal(w, r, "Server error", err)
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     "token",
-			Value:    tokenString,
-			Expires:  expirationTime,
-			HttpOnly: true, // Secure
-		})
+		csrfToken := CSRFToken(jwtSecret, tokenString)
+		SetSessionCookies(w, cookies, tokenString, csrfToken, expirationTime)
+
+		recordAuth(r, db, "auth.login", creds.Username, audit.OutcomeSuccess, "")
 
 		// Also return it in JSON for convenience
-		json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LoginResponse{Token: tokenString, CSRFToken: csrfToken})
 	}
 }
 
-func GetProfileHandler(client *mongo.Client) http.HandlerFunc {
+func GetProfileHandler(users store.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Extract user from context (set by middleware)
 		userVal := r.Context().Value("user")
 		if userVal == nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			apierror.Unauthorized(w, r, "Unauthorized")
 			return
 		}
 		username := userVal.(string)
 
-		collection := client.Database("nexus_search").Collection("users")
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		var user User
-		err := collection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
+		user, err := users.UserByName(ctx, username)
 		if err != nil {
-			http.Error(w, "User not found", http.StatusNotFound)
+			apierror.NotFound(w, r, "User not found")
 			return
 		}
 
 		// Hide password
 		user.Password = ""
+		user.Normalize()
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(user)
 	}
 }
+
+// ParseToken verifies a session JWT and returns its claims
+func ParseToken(tokenString, jwtSecret string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(jwtSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.Username == "" {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// ValidateSession returns a check for middleware.Auth that rejects tokens of
+// disabled users and tokens issued before the user's last forced logout.
+func ValidateSession(users store.UserStore) func(context.Context, *Claims) error {
+	return func(ctx context.Context, claims *Claims) error {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		user, err := users.UserByName(ctx, claims.Username)
+		if err != nil {
+			return err
+		}
+		if user.Disabled {
+			return ErrAccountDisabled
+		}
+		if user.SessionVersion != claims.SessionVersion {
+			return ErrSessionRevoked
+		}
+		return nil
+	}
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/url"
+
+	"nexus-gateway/auth"
+)
+
+// Register creates an account. It does not log in.
+func (c *Client) Register(ctx context.Context, username, password string) error {
+	req, err := jsonRequest("POST", "/register", auth.Credentials{Username: username, Password: password})
+	if err != nil {
+		return err
+	}
+	req.public = true
+	return c.do(ctx, req, nil)
+}
+
+// Login starts a session. The credentials are kept so the client can log
+// in again when the session ends.
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	if err := c.login(ctx, username, password); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.username, c.password = username, password
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) login(ctx context.Context, username, password string) error {
+	req, err := jsonRequest("POST", "/login", auth.Credentials{Username: username, Password: password})
+	if err != nil {
+		return err
+	}
+	req.public = true
+	var resp auth.LoginResponse
+	if err := c.do(ctx, req, &resp); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.token = resp.Token
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) canLogin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.username != ""
+}
+
+func (c *Client) relogin(ctx context.Context) error {
+	c.mu.Lock()
+	username, password := c.username, c.password
+	c.mu.Unlock()
+	return c.login(ctx, username, password)
+}
+
+// Profile returns the logged in user
+func (c *Client) Profile(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.do(ctx, request{method: "GET", path: "/user"}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Quota is the caller's storage use
+type Quota struct {
+	Plan       string
+	UsedBytes  int64
+	LimitBytes int64
+}
+
+// Remaining is how many more bytes can be uploaded
+func (q Quota) Remaining() int64 {
+	return max(q.LimitBytes-q.UsedBytes, 0)
+}
+
+// Quota returns the caller's storage use and limit
+func (c *Client) Quota(ctx context.Context) (*Quota, error) {
+	user, err := c.Profile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Quota{Plan: user.Plan, UsedBytes: user.TotalStorageBytes, LimitBytes: user.QuotaBytes}, nil
+}
+
+// SearchOptions picks the sources to search. With none set the gateway
+// searches no source and returns no results.
+type SearchOptions struct {
+	Web  bool // Google, through SerpApi
+	Wiki bool
+	DDG  bool
+	PKB  bool // the caller's uploaded documents
+	// Workspace limits PKB results to one workspace's documents
+	Workspace string
+}
+
+func (o SearchOptions) values() url.Values {
+	v := url.Values{}
+	for name, on := range map[string]bool{"web": o.Web, "wiki": o.Wiki, "ddg": o.DDG, "pkb": o.PKB} {
+		if on {
+			v.Set(name, "true")
+		}
+	}
+	if o.Workspace != "" {
+		v.Set("workspace", o.Workspace)
+	}
+	return v
+}
+
+// Search runs a query against the sources in opts
+func (c *Client) Search(ctx context.Context, query string, opts SearchOptions) (*SearchResponse, error) {
+	q := opts.values()
+	q.Set("q", query)
+	var resp SearchResponse
+	if err := c.do(ctx, request{method: "GET", path: "/search", query: q}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UploadOptions are the optional fields of an upload
+type UploadOptions struct {
+	// Workspace stores the document in a workspace instead of the
+	// caller's own knowledge base
+	Workspace string
+}
+
+// Upload reads r to the end and adds it to the knowledge base as filename.
+// The file is held in memory so the upload can be retried.
+func (c *Client) Upload(ctx context.Context, filename string, r io.Reader, opts UploadOptions) (*UploadResponse, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if opts.Workspace != "" {
+		if err := mw.WriteField("workspace", opts.Workspace); err != nil {
+			return nil, err
+		}
+	}
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	req := request{method: "POST", path: "/upload", body: body.Bytes(), contentType: mw.FormDataContentType()}
+	var resp UploadResponse
+	if err := c.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Documents lists the caller's documents with their active share links
+func (c *Client) Documents(ctx context.Context) ([]Listing, error) {
+	var docs []Listing
+	if err := c.do(ctx, request{method: "GET", path: "/documents"}, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// DeleteDocument deletes one of the caller's documents and its chunks
+func (c *Client) DeleteDocument(ctx context.Context, id string) error {
+	return c.do(ctx, request{method: "DELETE", path: "/documents/" + url.PathEscape(id)}, nil)
+}
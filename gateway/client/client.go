@@ -0,0 +1,263 @@
+// Package client calls the gateway's /api/v1 API from Go:
+//
+//	c := client.New("https://nexus.example.com")
+//	if err := c.Login(ctx, "alice", password); err != nil { ... }
+//	resp, err := c.Search(ctx, "vector databases", client.SearchOptions{Web: true, PKB: true})
+//
+// Requests carry the session token as a bearer token. When the session ends
+// the client logs in again with the credentials given to Login, and
+// requests refused with 429 or 503 are retried after the server's
+// Retry-After. Failures reported by the gateway are returned as *Error.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/auth"
+	"nexus-gateway/documents"
+	"nexus-gateway/search"
+)
+
+// The API's types, shared with the handlers that produce them
+type (
+	User           = auth.User
+	SearchResponse = search.SearchResponse
+	SearchResult   = search.SearchResult
+	UploadResponse = search.UploadResponse
+	// Listing is a document with its active share links
+	Listing = documents.Listing
+)
+
+const (
+	apiPrefix = "/api/v1"
+
+	defaultRetries = 3
+	defaultMaxWait = 30 * time.Second
+	// firstBackoff is the wait before the first retry when the server
+	// doesn't say; it doubles with every attempt
+	firstBackoff = 500 * time.Millisecond
+)
+
+type Client struct {
+	baseURL   string
+	http      *http.Client
+	retries   int
+	maxWait   time.Duration
+	userAgent string
+
+	mu    sync.Mutex
+	token string
+	// Kept to log in again when the session expires or is ended
+	username, password string
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc instead of http.DefaultClient
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithToken starts with an existing session token, e.g. one saved by an
+// earlier Login
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithRetries sets how often a request refused with 429 or 503 is retried
+// (3 by default) and the longest the client waits before a retry (30s).
+// If the server asks for a longer wait the error is returned instead.
+func WithRetries(retries int, maxWait time.Duration) Option {
+	return func(c *Client) { c.retries, c.maxWait = retries, maxWait }
+}
+
+// WithUserAgent sets the User-Agent header
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// New returns a client for the gateway at baseURL, e.g.
+// "http://localhost:8080"
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		http:      http.DefaultClient,
+		retries:   defaultRetries,
+		maxWait:   defaultMaxWait,
+		userAgent: "nexus-client",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Token returns the current session token, empty before Login
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// Error is a failure reported by the gateway. Code is one of the
+// apierror.Code constants.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+	Details    map[string]interface{}
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("nexus: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// IsCode reports whether err is or wraps an *Error with the given code
+func IsCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// request is an API call. The body is kept in memory so it can be sent
+// again on retries.
+type request struct {
+	method, path string
+	query        url.Values
+	body         []byte
+	contentType  string
+	// public requests are sent without the session token
+	public bool
+}
+
+// jsonRequest returns a request with v encoded as its JSON body
+func jsonRequest(method, path string, v interface{}) (request, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return request{}, err
+	}
+	return request{method: method, path: path, body: body, contentType: "application/json"}, nil
+}
+
+// do sends req, retrying as the package documentation describes, and
+// decodes a successful JSON response into out unless it is nil
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	loggedIn := false
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && !req.public && !loggedIn && c.canLogin() {
+			resp.Body.Close()
+			loggedIn = true
+			if err := c.relogin(ctx); err != nil {
+				return err
+			}
+			attempt--
+			continue
+		}
+
+		if (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) && attempt < c.retries {
+			wait := retryAfter(resp.Header.Get("Retry-After"), attempt)
+			if wait <= c.maxWait {
+				resp.Body.Close()
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+				continue
+			}
+		}
+
+		defer resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return decodeError(resp)
+		}
+		if out == nil {
+			return nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("nexus: decoding %s %s response: %w", req.method, req.path, err)
+		}
+		return nil
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	u := c.baseURL + apiPrefix + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	hr, err := http.NewRequestWithContext(ctx, req.method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if req.contentType != "" {
+		hr.Header.Set("Content-Type", req.contentType)
+	}
+	hr.Header.Set("Accept", "application/json")
+	hr.Header.Set("User-Agent", c.userAgent)
+	if token := c.Token(); token != "" && !req.public {
+		hr.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.http.Do(hr)
+}
+
+// retryAfter reads a Retry-After header, in seconds or as a date, falling
+// back to exponential backoff
+func retryAfter(header string, attempt int) time.Duration {
+	if header != "" {
+		if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(header); err == nil {
+			return max(time.Until(t), 0)
+		}
+	}
+	return firstBackoff << attempt
+}
+
+// decodeError turns an error response into an *Error. Responses that are
+// not an error envelope, e.g. from a proxy in front of the gateway, keep
+// their status and body text.
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var env apierror.Envelope
+	if err := json.Unmarshal(body, &env); err == nil && env.Error != nil && env.Error.Code != "" {
+		return &Error{
+			StatusCode: resp.StatusCode,
+			Code:       env.Error.Code,
+			Message:    env.Error.Message,
+			RequestID:  env.Error.RequestID,
+			Details:    env.Error.Details,
+		}
+	}
+	msg := strings.TrimSpace(string(body))
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: msg, RequestID: resp.Header.Get("X-Request-ID")}
+}
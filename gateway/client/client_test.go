@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/e2e"
+	"nexus-gateway/store"
+)
+
+const notes = `Gophers dig long burrows under meadows and gardens.
+
+Sourdough bread needs a starter, flour, water and salt.`
+
+// login returns a client for a new account on a test gateway
+func login(t *testing.T, h *e2e.Harness, username string) *Client {
+	t.Helper()
+	c := New(h.URL, WithHTTPClient(h.HTTPClient()))
+	ctx := context.Background()
+	if err := c.Register(ctx, username, e2e.Password); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login(ctx, username, e2e.Password); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	h := e2e.Start(t)
+	c := login(t, h, "alice")
+	ctx := context.Background()
+
+	up, err := c.Upload(ctx, "notes.txt", strings.NewReader(notes), UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.Chunks != 2 || up.DocumentID == "" {
+		t.Fatalf("upload = %+v", up)
+	}
+
+	resp, err := c.Search(ctx, "sourdough bread starter", SearchOptions{PKB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) == 0 || resp.Results[0].Source != "PKB (notes.txt)" {
+		t.Errorf("search = %+v, want notes.txt first", resp.Results)
+	}
+
+	docs, err := c.Documents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].ID.Hex() != up.DocumentID {
+		t.Fatalf("documents = %+v", docs)
+	}
+
+	q, err := c.Quota(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.UsedBytes != int64(len(notes)) || q.Remaining() != q.LimitBytes-q.UsedBytes {
+		t.Errorf("quota = %+v", q)
+	}
+
+	if err := c.DeleteDocument(ctx, up.DocumentID); err != nil {
+		t.Fatal(err)
+	}
+	err = c.DeleteDocument(ctx, up.DocumentID)
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusNotFound || e.Code != apierror.CodeNotFound || e.RequestID == "" {
+		t.Errorf("deleting twice: err = %#v, want a not_found *Error", err)
+	}
+	if q, err := c.Quota(ctx); err != nil || q.UsedBytes != 0 {
+		t.Errorf("quota after delete = %+v, %v", q, err)
+	}
+}
+
+func TestLoginAgain(t *testing.T) {
+	h := e2e.Start(t)
+	c := login(t, h, "alice")
+	ctx := context.Background()
+
+	// Ending the sessions revokes the token; the client logs in again
+	old := c.Token()
+	if _, err := h.Store.UpdateUser(ctx, "alice", store.UserUpdate{EndSessions: true}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := c.Profile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "alice" || c.Token() == old {
+		t.Errorf("profile = %+v, token changed = %v", user, c.Token() != old)
+	}
+
+	// Without credentials the 401 is returned
+	anon := New(h.URL, WithToken("not-a-token"))
+	if _, err := anon.Profile(ctx); !IsCode(err, apierror.CodeUnauthorized) {
+		t.Errorf("err = %v, want unauthorized", err)
+	}
+	if err := anon.Login(ctx, "alice", "wrong"); !IsCode(err, apierror.CodeInvalidCredentials) {
+		t.Errorf("wrong password: err = %v", err)
+	}
+}
+
+// refusing starts a server that answers the first n requests with 429 and
+// the given Retry-After, and counts requests
+func refusing(t *testing.T, n int32, wait string) (*httptest.Server, *atomic.Int32) {
+	calls := &atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= n {
+			w.Header().Set("Retry-After", wait)
+			apierror.Write(w, r, apierror.New(http.StatusTooManyRequests, apierror.CodeRateLimited, "Too many requests"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results": [], "time_taken_ms": 1}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+
+	srv, calls := refusing(t, 2, "0")
+	if _, err := New(srv.URL).Search(ctx, "gophers", SearchOptions{Wiki: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d calls, want 2 refused and 1 answered", n)
+	}
+
+	// Out of retries
+	srv, _ = refusing(t, 2, "0")
+	c := New(srv.URL, WithRetries(1, time.Second))
+	if _, err := c.Search(ctx, "gophers", SearchOptions{}); !IsCode(err, apierror.CodeRateLimited) {
+		t.Errorf("err = %v, want rate_limited", err)
+	}
+
+	// Asked to wait longer than allowed
+	srv, calls = refusing(t, 1, "120")
+	c = New(srv.URL, WithRetries(1, time.Second))
+	if _, err := c.Search(ctx, "gophers", SearchOptions{}); !IsCode(err, apierror.CodeRateLimited) {
+		t.Errorf("err = %v, want rate_limited without waiting", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d calls, want 1", n)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if d := retryAfter("3", 0); d != 3*time.Second {
+		t.Errorf("seconds: %v", d)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if d := retryAfter(date, 0); d < 58*time.Second || d > time.Minute {
+		t.Errorf("date: %v", d)
+	}
+	if d := retryAfter("", 2); d != 4*firstBackoff {
+		t.Errorf("backoff: %v", d)
+	}
+}
@@ -0,0 +1,151 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type contextKey struct{}
+
+// Resolver determines the real client IP of a request. The forwarding header
+// is only believed when it was added by a trusted proxy, so clients cannot
+// spoof their address by sending X-Forwarded-For themselves.
+type Resolver struct {
+	trusted []*net.IPNet
+	header  string
+}
+
+// NewResolver trusts the given proxies, written as CIDRs ("10.0.0.0/8") or
+// single addresses ("192.0.2.7"). header is the one forwarding header those
+// proxies write: "Forwarded" (RFC 7239), or a comma-separated list such as
+// X-Forwarded-For, which is used when header is empty. Any other forwarding
+// header is ignored, since the proxy passes on whatever the client sent.
+func NewResolver(trustedProxies []string, header string) (*Resolver, error) {
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	r := &Resolver{header: http.CanonicalHeaderKey(header)}
+	for _, entry := range trustedProxies {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			entry = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client IP of req. Starting from the direct peer, it
+// walks the forwarding header's chain from right to left and returns the
+// first address that is not a trusted proxy.
+func (r *Resolver) Resolve(req *http.Request) string {
+	peer := parseIP(req.RemoteAddr)
+	if peer == nil {
+		return req.RemoteAddr
+	}
+	if !r.isTrusted(peer) {
+		return peer.String()
+	}
+
+	var chain []string
+	if r.header == "Forwarded" {
+		chain = forwardedFor(req.Header.Values(r.header))
+	} else {
+		chain = xForwardedFor(req.Header.Values(r.header))
+	}
+
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseIP(chain[i])
+		if ip == nil {
+			// Garbage (or an obfuscated identifier); the last hop we could
+			// parse is the best we know
+			break
+		}
+		client = ip
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// parseIP accepts "ip", "ip:port", "[v6]" and "[v6]:port"
+func parseIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	return net.ParseIP(s)
+}
+
+func xForwardedFor(values []string) []string {
+	var chain []string
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			chain = append(chain, strings.TrimSpace(hop))
+		}
+	}
+	return chain
+}
+
+// forwardedFor extracts the for= parameters of RFC 7239 Forwarded headers
+func forwardedFor(values []string) []string {
+	var chain []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					chain = append(chain, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return chain
+}
+
+// WithIP stores the resolved client IP in ctx
+func WithIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, contextKey{}, ip)
+}
+
+// FromRequest returns the IP resolved for r, falling back to the host part
+// of RemoteAddr when no resolver ran
+func FromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(contextKey{}).(string); ok && ip != "" {
+		return ip
+	}
+	if ip := parseIP(r.RemoteAddr); ip != nil {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,69 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	r, err := NewResolver([]string{"10.0.0.0/8", "192.0.2.7"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"direct client", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"untrusted peer cannot spoof", "203.0.113.5:4000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.5"},
+		{"trusted proxy", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "198.51.100.9"}, "198.51.100.9"},
+		{"spoofed entry left of real client", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.9"}, "198.51.100.9"},
+		{"chain of trusted proxies", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "198.51.100.9, 192.0.2.7, 10.9.9.9"}, "198.51.100.9"},
+		{"trusted proxy without header", "10.1.2.3:80", nil, "10.1.2.3"},
+		{"client-sent Forwarded is ignored", "10.1.2.3:80", map[string]string{"Forwarded": "for=1.2.3.4", "X-Forwarded-For": "198.51.100.9"}, "198.51.100.9"},
+		{"only Forwarded", "10.1.2.3:80", map[string]string{"Forwarded": "for=1.2.3.4"}, "10.1.2.3"},
+		{"garbage hop", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "198.51.100.9, unknown"}, "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := r.Resolve(req); got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveForwarded(t *testing.T) {
+	r, err := NewResolver([]string{"10.0.0.0/8"}, "forwarded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:80"
+	req.Header.Set("Forwarded", `for=198.51.100.9;proto=https, for="[2001:db8::1]:4711"`)
+	if got := r.Resolve(req); got != "2001:db8::1" {
+		t.Errorf("Resolve() = %q, want 2001:db8::1", got)
+	}
+
+	req.Header.Set("Forwarded", "for=198.51.100.9")
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	if got := r.Resolve(req); got != "198.51.100.9" {
+		t.Errorf("Resolve() with a client-sent X-Forwarded-For = %q, want 198.51.100.9", got)
+	}
+}
+
+func TestNewResolverRejectsInvalid(t *testing.T) {
+	if _, err := NewResolver([]string{"not-an-ip"}, ""); err == nil {
+		t.Error("expected error for invalid proxy")
+	}
+}
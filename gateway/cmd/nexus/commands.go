@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"nexus-gateway/client"
+)
+
+type env struct {
+	cfg     *config
+	cfgPath string
+	// server is the gateway to talk to: -server, then the logged in one
+	server string
+	stdin  io.Reader
+	stdout io.Writer
+	// stderr gets prompts and progress so stdout can be piped
+	stderr io.Writer
+	open   func(url string) error
+	// http replaces http.DefaultClient in tests
+	http *http.Client
+}
+
+func (e *env) client() *client.Client {
+	opts := []client.Option{client.WithUserAgent("nexus-cli")}
+	// A token is only good for the server that issued it
+	if e.cfg.Token != "" && e.server == e.cfg.Server {
+		opts = append(opts, client.WithToken(e.cfg.Token))
+	}
+	if e.http != nil {
+		opts = append(opts, client.WithHTTPClient(e.http))
+	}
+	return client.New(e.server, opts...)
+}
+
+type command struct {
+	name string
+	args string
+	help string
+	run  func(ctx context.Context, e *env, args []string) error
+}
+
+// usageError means the command was called with the wrong arguments
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+var commands = []command{
+	{name: "login", args: "[-password-stdin] <username>", help: "log in and save the session", run: login},
+	{name: "logout", args: "", help: "forget the saved session", run: logout},
+	{name: "search", args: "[-web] [-wiki] [-ddg] [-pkb] [-workspace id] [-o table|json|markdown] [-open n] <query>", help: "search the web (all three sources by default) or your documents", run: search},
+	{name: "upload", args: "[-workspace id] <file|dir>...", help: "upload files, and the .txt, .pdf and .docx files under directories", run: upload},
+	{name: "docs ls", args: "[-o table|json]", help: "list your documents", run: docsList},
+	{name: "docs rm", args: "<id>...", help: "delete documents", run: docsRemove},
+}
+
+// parse parses flags for a command and checks it got want positional
+// arguments (-1 for at least one)
+func parse(fs *flag.FlagSet, args []string, want int) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, usageError(err.Error())
+	}
+	rest := fs.Args()
+	switch {
+	case want < 0 && len(rest) == 0:
+		return nil, usageError("expected at least one argument")
+	case want >= 0 && len(rest) != want:
+		return nil, usageError(fmt.Sprintf("expected %d argument(s), got %d", want, len(rest)))
+	}
+	return rest, nil
+}
+
+// Output formats
+const (
+	formatTable    = "table"
+	formatJSON     = "json"
+	formatMarkdown = "markdown"
+)
+
+func checkFormat(format string, allowed ...string) error {
+	for _, f := range allowed {
+		if format == f {
+			return nil
+		}
+	}
+	return usageError("-o must be one of " + strings.Join(allowed, ", "))
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func login(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fromStdin := fs.Bool("password-stdin", false, "")
+	args, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	// Without a terminal library the password is echoed; -password-stdin
+	// is the way to pass it from a password manager
+	if !*fromStdin {
+		fmt.Fprint(e.stderr, "Password: ")
+	}
+	line, err := bufio.NewReader(e.stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return errors.New("no password given")
+	}
+
+	c := e.client()
+	if err := c.Login(ctx, args[0], password); err != nil {
+		return err
+	}
+	e.cfg.Server, e.cfg.Username, e.cfg.Token = e.server, args[0], c.Token()
+	if err := e.cfg.save(e.cfgPath); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "Logged in to %s as %s\n", e.server, args[0])
+	return nil
+}
+
+func logout(ctx context.Context, e *env, args []string) error {
+	if _, err := parse(flag.NewFlagSet("", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	e.cfg.Token = ""
+	return e.cfg.save(e.cfgPath)
+}
+
+func search(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	var opts client.SearchOptions
+	fs.BoolVar(&opts.Web, "web", false, "")
+	fs.BoolVar(&opts.Wiki, "wiki", false, "")
+	fs.BoolVar(&opts.DDG, "ddg", false, "")
+	fs.BoolVar(&opts.PKB, "pkb", false, "")
+	fs.StringVar(&opts.Workspace, "workspace", "", "")
+	format := fs.String("o", formatTable, "")
+	open := fs.Int("open", 0, "")
+	args, err := parse(fs, args, -1)
+	if err != nil {
+		return err
+	}
+	if err := checkFormat(*format, formatTable, formatJSON, formatMarkdown); err != nil {
+		return err
+	}
+	if opts.Workspace != "" {
+		opts.PKB = true
+	}
+	// Same default as the web frontend
+	if !opts.Web && !opts.Wiki && !opts.DDG && !opts.PKB {
+		opts.Web, opts.Wiki, opts.DDG = true, true, true
+	}
+
+	resp, err := e.client().Search(ctx, strings.Join(args, " "), opts)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case formatJSON:
+		err = writeJSON(e.stdout, resp)
+	case formatMarkdown:
+		err = resultsMarkdown(e.stdout, resp.Results)
+	default:
+		err = resultsTable(e.stdout, resp.Results)
+		fmt.Fprintf(e.stderr, "%d results in %dms\n", len(resp.Results), resp.TimeTakenMs)
+	}
+	if err != nil || *open == 0 {
+		return err
+	}
+
+	if *open < 1 || *open > len(resp.Results) {
+		return fmt.Errorf("-open %d: there are %d results", *open, len(resp.Results))
+	}
+	link := resultURL(resp.Results[*open-1])
+	if link == "" {
+		return fmt.Errorf("result %d is an uploaded document and has no link", *open)
+	}
+	return e.open(link)
+}
+
+// resultURL is empty for results without a link, which PKB results mark
+// with "#"
+func resultURL(r client.SearchResult) string {
+	if r.URL == "#" {
+		return ""
+	}
+	return r.URL
+}
+
+func resultsTable(w io.Writer, results []client.SearchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tSOURCE\tTITLE\tURL")
+	for i, r := range results {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", i+1, r.Source, truncate(oneLine(r.Title), 60), resultURL(r))
+	}
+	return tw.Flush()
+}
+
+func resultsMarkdown(w io.Writer, results []client.SearchResult) error {
+	for i, r := range results {
+		title := oneLine(r.Title)
+		if link := resultURL(r); link != "" {
+			title = "[" + title + "](" + link + ")"
+		}
+		if _, err := fmt.Fprintf(w, "%d. **%s** (%s)\n", i+1, title, r.Source); err != nil {
+			return err
+		}
+		if snippet := oneLine(r.Snippet); snippet != "" {
+			fmt.Fprintf(w, "   %s\n", snippet)
+		}
+	}
+	return nil
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// uploadable are the file types the worker can parse, used to pick files
+// from directories. Files named on the command line are always sent.
+var uploadable = map[string]bool{".txt": true, ".pdf": true, ".docx": true}
+
+type file struct {
+	path string
+	size int64
+}
+
+// collect lists the files to upload, walking directories but skipping
+// hidden files and directories in them
+func collect(paths []string) ([]file, error) {
+	var files []file
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, file{root, info.Size()})
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || !uploadable[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files = append(files, file{path, info.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// upload sends files one at a time, reporting progress on stderr and the
+// new document IDs on stdout. A failed file doesn't stop the others.
+func upload(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	workspace := fs.String("workspace", "", "")
+	paths, err := parse(fs, args, -1)
+	if err != nil {
+		return err
+	}
+	files, err := collect(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no files to upload")
+	}
+	var total, done int64
+	for _, f := range files {
+		total += f.size
+	}
+
+	c := e.client()
+	failed := 0
+	for i, f := range files {
+		resp, err := uploadFile(ctx, c, f.path, client.UploadOptions{Workspace: *workspace})
+		done += f.size
+		progress := fmt.Sprintf("[%d/%d %3d%%] %s", i+1, len(files), percent(done, total), f.path)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failed++
+			fmt.Fprintf(e.stderr, "%s: %v\n", progress, err)
+			continue
+		}
+		fmt.Fprintf(e.stderr, "%s: %d chunks\n", progress, resp.Chunks)
+		fmt.Fprintf(e.stdout, "%s\t%s\n", resp.DocumentID, f.path)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads failed", failed, len(files))
+	}
+	return nil
+}
+
+func uploadFile(ctx context.Context, c *client.Client, path string, opts client.UploadOptions) (*client.UploadResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.Upload(ctx, filepath.Base(path), f, opts)
+}
+
+func percent(done, total int64) int64 {
+	if total == 0 {
+		return 100
+	}
+	return done * 100 / total
+}
+
+func docsList(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	format := fs.String("o", formatTable, "")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, formatTable, formatJSON); err != nil {
+		return err
+	}
+	docs, err := e.client().Documents(ctx)
+	if err != nil {
+		return err
+	}
+	if *format == formatJSON {
+		return writeJSON(e.stdout, docs)
+	}
+
+	tw := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tFILENAME\tSIZE\tCHUNKS\tSHARES\tUPLOADED")
+	for _, d := range docs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", d.ID.Hex(), d.Filename, size(d.SizeBytes), d.Chunks, len(d.Shares), d.CreatedAt.Local().Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+func docsRemove(ctx context.Context, e *env, args []string) error {
+	ids, err := parse(flag.NewFlagSet("", flag.ContinueOnError), args, -1)
+	if err != nil {
+		return err
+	}
+	c := e.client()
+	for _, id := range ids {
+		if err := c.DeleteDocument(ctx, id); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		fmt.Fprintln(e.stderr, "Deleted", id)
+	}
+	return nil
+}
+
+// size formats a byte count for people
+func size(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// openBrowser opens url with the desktop's default handler
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
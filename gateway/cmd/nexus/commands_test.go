@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/client"
+	"nexus-gateway/e2e"
+)
+
+// testEnv returns an env for a test gateway with an empty config file
+func testEnv(t *testing.T, h *e2e.Harness) (*env, *bytes.Buffer) {
+	t.Helper()
+	out := &bytes.Buffer{}
+	return &env{
+		cfg:     &config{},
+		cfgPath: filepath.Join(t.TempDir(), "nexus", "config.json"),
+		server:  h.URL,
+		stdout:  out,
+		stderr:  &bytes.Buffer{},
+		open:    func(string) error { t.Error("opened a browser"); return nil },
+		http:    h.HTTPClient(),
+	}, out
+}
+
+func run(t *testing.T, e *env, args ...string) error {
+	t.Helper()
+	cmd, rest := lookup(args)
+	if cmd == nil {
+		t.Fatalf("no command %q", args)
+	}
+	return cmd.run(context.Background(), e, rest)
+}
+
+// loggedIn returns an env logged in as a new user
+func loggedIn(t *testing.T, h *e2e.Harness, username string) (*env, *bytes.Buffer) {
+	t.Helper()
+	e, out := testEnv(t, h)
+	if err := client.New(h.URL).Register(context.Background(), username, e2e.Password); err != nil {
+		t.Fatal(err)
+	}
+	e.stdin = strings.NewReader(e2e.Password + "\n")
+	if err := run(t, e, "login", "-password-stdin", username); err != nil {
+		t.Fatal(err)
+	}
+	return e, out
+}
+
+func TestLogin(t *testing.T) {
+	h := e2e.Start(t)
+	e, _ := loggedIn(t, h, "alice")
+
+	info, err := os.Stat(e.cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("config mode = %v, want 0600", info.Mode().Perm())
+	}
+	saved, err := loadConfig(e.cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Server != h.URL || saved.Username != "alice" || saved.Token == "" {
+		t.Errorf("saved config = %+v", saved)
+	}
+
+	if err := run(t, e, "logout"); err != nil {
+		t.Fatal(err)
+	}
+	err = run(t, e, "docs", "ls")
+	if !client.IsCode(err, apierror.CodeUnauthorized) {
+		t.Errorf("after logout: err = %v, want unauthorized", err)
+	}
+
+	e.stdin = strings.NewReader("wrong\n")
+	if err := run(t, e, "login", "-password-stdin", "alice"); !client.IsCode(err, apierror.CodeInvalidCredentials) {
+		t.Errorf("wrong password: err = %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	h := e2e.Start(t)
+	e, out := loggedIn(t, h, "alice")
+
+	// The web sources are searched by default
+	if err := run(t, e, "search", "gophers"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !strings.HasPrefix(lines[0], "#") || len(lines) != 9 || !strings.Contains(out.String(), "Google (SerpApi)") {
+		t.Errorf("table:\n%s", out)
+	}
+
+	out.Reset()
+	if err := run(t, e, "search", "-wiki", "-o", "json", "gophers"); err != nil {
+		t.Fatal(err)
+	}
+	var resp client.SearchResponse
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 2 || resp.Results[0].Source != "Wikipedia" {
+		t.Errorf("json: %+v", resp.Results)
+	}
+
+	out.Reset()
+	var opened string
+	e.open = func(url string) error { opened = url; return nil }
+	if err := run(t, e, "search", "-wiki", "-o", "markdown", "-open", "2", "gophers"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "1. **[") || opened != resp.Results[1].URL {
+		t.Errorf("markdown:\n%s\nopened %q", out, opened)
+	}
+	if err := run(t, e, "search", "-wiki", "-open", "9", "gophers"); err == nil {
+		t.Error("-open past the results succeeded")
+	}
+	if err := run(t, e, "search", "-o", "yaml", "gophers"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestUploadAndDocs(t *testing.T) {
+	h := e2e.Start(t)
+	e, out := loggedIn(t, h, "alice")
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"gophers.txt":        "Gophers dig long burrows under meadows.",
+		"sub/bread.txt":      "Sourdough bread needs a starter.",
+		"README.md":          "not a type the worker reads",
+		".drafts/secret.txt": "hidden",
+	} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := run(t, e, "upload", dir); err != nil {
+		t.Fatal(err)
+	}
+	uploaded := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(uploaded) != 2 {
+		t.Fatalf("uploaded:\n%s", out)
+	}
+	if progress := e.stderr.(*bytes.Buffer).String(); !strings.Contains(progress, "[2/2 100%]") {
+		t.Errorf("progress:\n%s", progress)
+	}
+
+	out.Reset()
+	if err := run(t, e, "docs", "ls"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "gophers.txt") || !strings.Contains(out.String(), "bread.txt") {
+		t.Errorf("docs ls:\n%s", out)
+	}
+
+	var ids []string
+	for _, line := range uploaded {
+		id, _, _ := strings.Cut(line, "\t")
+		ids = append(ids, id)
+	}
+	if err := run(t, e, append([]string{"docs", "rm"}, ids...)...); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := run(t, e, "docs", "ls", "-o", "json"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(out.String()) != "[]" {
+		t.Errorf("documents left: %s", out)
+	}
+	if err := run(t, e, "docs", "rm", ids[0]); !client.IsCode(err, apierror.CodeNotFound) {
+		t.Errorf("removing twice: err = %v", err)
+	}
+}
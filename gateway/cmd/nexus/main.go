@@ -0,0 +1,125 @@
+// Command nexus searches the web sources and your knowledge base from the
+// terminal through the gateway API, e.g.
+//
+//	nexus login alice
+//	nexus upload ~/notes
+//	nexus search -pkb -o markdown sourdough starter
+//
+// login saves the server and session token in the user config directory
+// (~/.config/nexus/config.json on Linux), or in NEXUS_CLI_CONFIG if set.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/client"
+)
+
+// defaultServer is used until login is given another
+const defaultServer = "http://localhost:8080"
+
+func main() {
+	fs := flag.NewFlagSet("nexus", flag.ContinueOnError)
+	server := fs.String("server", os.Getenv("NEXUS_SERVER"), "gateway URL (NEXUS_SERVER), default the one logged in to")
+	cfgPath := fs.String("config", "", "config file (NEXUS_CLI_CONFIG)")
+	fs.Usage = func() { usage(fs.Output(), fs) }
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+
+	cmd, args := lookup(fs.Args())
+	if cmd == nil {
+		usage(os.Stderr, fs)
+		os.Exit(2)
+	}
+
+	if *cfgPath == "" {
+		p, err := defaultConfigPath()
+		if err != nil {
+			fail(err)
+		}
+		*cfgPath = p
+	}
+	cfg, err := loadConfig(*cfgPath)
+	if err != nil {
+		fail(err)
+	}
+	e := &env{
+		cfg:     cfg,
+		cfgPath: *cfgPath,
+		server:  firstNonEmpty(*server, cfg.Server, defaultServer),
+		stdin:   os.Stdin,
+		stdout:  os.Stdout,
+		stderr:  os.Stderr,
+		open:    openBrowser,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err = cmd.run(ctx, e, args)
+	stop()
+
+	var uerr usageError
+	if errors.As(err, &uerr) {
+		fmt.Fprintf(os.Stderr, "%s\nusage: nexus %s %s\n", uerr, cmd.name, cmd.args)
+		os.Exit(2)
+	}
+	if client.IsCode(err, apierror.CodeUnauthorized) {
+		err = fmt.Errorf("not logged in to %s or the session ended; run nexus login", e.server)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "nexus:", err)
+	os.Exit(1)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// lookup finds the command named by the first one or two words of args and
+// returns it with the remaining arguments
+func lookup(args []string) (*command, []string) {
+	for n := 2; n >= 1; n-- {
+		if len(args) < n {
+			continue
+		}
+		name := strings.Join(args[:n], " ")
+		for i := range commands {
+			if commands[i].name == name {
+				return &commands[i], args[n:]
+			}
+		}
+	}
+	return nil, nil
+}
+
+func usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: nexus [flags] <command> [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", strings.TrimSpace(c.name+" "+c.args), c.help)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nflags:")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"nexus-gateway/admin"
+	"nexus-gateway/audit"
+	"nexus-gateway/auth"
+	"nexus-gateway/store"
+	"nexus-gateway/store/mongostore"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actor recorded in the audit log for changes made with nexusctl
+const actor = "nexusctl"
+
+// schema is what the migrate and reindex commands need beyond store.Store
+type schema interface {
+	Migrate(ctx context.Context) ([]int, error)
+	MigrationStatus(ctx context.Context) ([]mongostore.MigrationStatus, error)
+	RebuildVectorIndex(ctx context.Context) error
+}
+
+type env struct {
+	db     store.Store
+	schema schema
+	stdin  io.Reader
+}
+
+type command struct {
+	name string
+	args string
+	help string
+	// offline commands do not connect to MongoDB
+	offline bool
+	run     func(ctx context.Context, e *env, args []string) (interface{}, error)
+}
+
+// usageError means the command was called with the wrong arguments
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+var commands = []command{
+	{name: "users list", args: "[-skip n] [-limit n]", help: "list users with their usage", run: usersList},
+	{name: "users show", args: "<username>", help: "show one user with their usage", run: usersShow},
+	{name: "users create", args: "[-admin] [-password-stdin] <username>", help: "create a user, generating a password unless one is piped in", run: usersCreate},
+	{name: "users passwd", args: "[-password-stdin] <username>", help: "reset a password and end the user's sessions", run: usersPasswd},
+	{name: "users role", args: "<username> <user|admin>", help: "change a role and end the user's sessions", run: usersRole},
+	{name: "users disable", args: "<username>", help: "disable an account and end its sessions", run: usersDisabled(true)},
+	{name: "users enable", args: "<username>", help: "re-enable an account", run: usersDisabled(false)},
+	{name: "users logout", args: "<username>", help: "end every session of a user", run: usersLogout},
+	{name: "quota set", args: "[-plan name] <username> <bytes>", help: "change a storage quota", run: quotaSet},
+	{name: "quota recalc", args: "<username>... | -all", help: "recompute total_storage_bytes from the stored chunks", run: quotaRecalc},
+	{name: "docs list", args: "<username>", help: "list a user's documents", run: docsList},
+	{name: "docs purge", args: "<username>", help: "delete all of a user's documents and share links", run: docsPurge},
+	{name: "migrate", args: "", help: "apply pending database migrations", run: migrate},
+	{name: "migrate status", args: "", help: "list migrations and when they were applied", run: migrateStatus},
+	{name: "reindex", args: "", help: "rebuild the Atlas vector index", run: reindex},
+	{name: "keys generate", args: "", help: "print a new random JWT_SECRET", offline: true, run: keysGenerate},
+}
+
+// parse parses flags for a command and checks it got want positional
+// arguments (-1 for any number)
+func parse(fs *flag.FlagSet, args []string, want int) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, usageError(err.Error())
+	}
+	rest := fs.Args()
+	if want >= 0 && len(rest) != want {
+		return nil, usageError(fmt.Sprintf("expected %d argument(s), got %d", want, len(rest)))
+	}
+	return rest, nil
+}
+
+func noFlags(args []string, want int) ([]string, error) {
+	return parse(flag.NewFlagSet("", flag.ContinueOnError), args, want)
+}
+
+// withUsage returns users with their chunk and document counts, without passwords
+func withUsage(ctx context.Context, db store.Store, users []store.User) ([]admin.UserUsage, error) {
+	ids := make([]primitive.ObjectID, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	stats, err := db.ChunkStats(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]admin.UserUsage, 0, len(users))
+	for _, u := range users {
+		u.Password = ""
+		u.Normalize()
+		result = append(result, admin.UserUsage{User: u, Documents: stats[u.ID].Documents, Chunks: stats[u.ID].Chunks})
+	}
+	return result, nil
+}
+
+func usersList(ctx context.Context, e *env, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	skip := fs.Int64("skip", 0, "")
+	limit := fs.Int64("limit", 0, "")
+	if _, err := parse(fs, args, 0); err != nil {
+		return nil, err
+	}
+	users, err := e.db.ListUsers(ctx, *skip, *limit)
+	if err != nil {
+		return nil, err
+	}
+	return withUsage(ctx, e.db, users)
+}
+
+func usersShow(ctx context.Context, e *env, args []string) (interface{}, error) {
+	args, err := noFlags(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	user, err := e.db.UserByName(ctx, args[0])
+	if err != nil {
+		return nil, userError(args[0], err)
+	}
+	list, err := withUsage(ctx, e.db, []store.User{*user})
+	if err != nil {
+		return nil, err
+	}
+	return list[0], nil
+}
+
+// password reads a password from the first line of stdin, or generates one
+func password(e *env, fromStdin bool) (string, bool, error) {
+	if !fromStdin {
+		p, err := randomString(18)
+		return p, true, err
+	}
+	line, err := bufio.NewReader(e.stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", false, errors.New("no password on stdin")
+	}
+	return line, false, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// credentials is printed when a password was set; the password itself only
+// when nexusctl generated it
+type credentials struct {
+	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func usersCreate(ctx context.Context, e *env, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	isAdmin := fs.Bool("admin", false, "")
+	fromStdin := fs.Bool("password-stdin", false, "")
+	args, err := parse(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw, generated, err := password(e, *fromStdin)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := auth.NewUser(args[0], pw)
+	if err != nil {
+		return nil, err
+	}
+	if *isAdmin {
+		user.Role = auth.RoleAdmin
+	}
+	if err := e.db.CreateUser(ctx, user); err != nil {
+		if err == store.ErrDuplicate {
+			return nil, fmt.Errorf("user %s already exists", args[0])
+		}
+		return nil, err
+	}
+	record(ctx, e, "admin.user.create", user.Username, map[string]interface{}{"role": user.Role})
+
+	c := credentials{Username: user.Username, Role: user.Role}
+	if generated {
+		c.Password = pw
+	}
+	return c, nil
+}
+
+func usersPasswd(ctx context.Context, e *env, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fromStdin := fs.Bool("password-stdin", false, "")
+	args, err := parse(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw, generated, err := password(e, *fromStdin)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := auth.HashPassword(pw)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := updateUser(ctx, e, args[0], store.UserUpdate{Password: &hash, EndSessions: true}, "admin.user.password", nil)
+	if err != nil {
+		return nil, err
+	}
+	c := credentials{Username: user.Username}
+	if generated {
+		c.Password = pw
+	}
+	return c, nil
+}
+
+func usersRole(ctx context.Context, e *env, args []string) (interface{}, error) {
+	args, err := noFlags(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	role := args[1]
+	if !auth.ValidRole(role) {
+		return nil, usageError("unknown role " + role)
+	}
+	return updateUser(ctx, e, args[0], store.UserUpdate{Role: &role, EndSessions: true}, "admin.role.update", map[string]interface{}{"role": role})
+}
+
+func usersDisabled(disabled bool) func(context.Context, *env, []string) (interface{}, error) {
+	return func(ctx context.Context, e *env, args []string) (interface{}, error) {
+		args, err := noFlags(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		update := store.UserUpdate{Disabled: &disabled}
+		action := "admin.user.enable"
+		if disabled {
+			update.EndSessions = true
+			action = "admin.user.disable"
+		}
+		return updateUser(ctx, e, args[0], update, action, nil)
+	}
+}
+
+func usersLogout(ctx context.Context, e *env, args []string) (interface{}, error) {
+	args, err := noFlags(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return updateUser(ctx, e, args[0], store.UserUpdate{EndSessions: true}, "admin.user.logout", nil)
+}
+
+func quotaSet(ctx context.Context, e *env, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	plan := fs.String("plan", "", "")
+	args, err := parse(fs, args, 2)
+	if err != nil {
+		return nil, err
+	}
+	quota, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || quota <= 0 {
+		return nil, usageError("bytes must be a positive integer")
+	}
+	update := store.UserUpdate{QuotaBytes: &quota}
+	if *plan != "" {
+		// The plan is in the token, like the role
+		update.Plan = plan
+		update.EndSessions = true
+	}
+	return updateUser(ctx, e, args[0], update, "admin.quota.update", map[string]interface{}{
+		"quota_bytes": quota,
+		"plan":        *plan,
+	})
+}
+
+// storageChange is a user's total_storage_bytes before and after a recalc
+type storageChange struct {
+	Username string `json:"username"`
+	Before   int64  `json:"before"`
+	After    int64  `json:"after"`
+}
+
+func quotaRecalc(ctx context.Context, e *env, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	all := fs.Bool("all", false, "")
+	names, err := parse(fs, args, -1)
+	if err != nil {
+		return nil, err
+	}
+	if *all == (len(names) > 0) {
+		return nil, usageError("give usernames or -all")
+	}
+	if *all {
+		users, err := e.db.ListUsers(ctx, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			names = append(names, u.Username)
+		}
+	}
+
+	changes := []storageChange{}
+	for _, name := range names {
+		before, err := e.db.UserByName(ctx, name)
+		if err != nil {
+			return changes, userError(name, err)
+		}
+		after, err := admin.RecalculateStorage(ctx, e.db, name)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, storageChange{Username: name, Before: before.TotalStorageBytes, After: after.TotalStorageBytes})
+		if before.TotalStorageBytes != after.TotalStorageBytes {
+			record(ctx, e, "admin.quota.recalculate", name, map[string]interface{}{
+				"before": before.TotalStorageBytes,
+				"after":  after.TotalStorageBytes,
+			})
+		}
+	}
+	return changes, nil
+}
+
+func docsList(ctx context.Context, e *env, args []string) (interface{}, error) {
+	args, err := noFlags(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	user, err := e.db.UserByName(ctx, args[0])
+	if err != nil {
+		return nil, userError(args[0], err)
+	}
+	docs, err := e.db.DocumentsByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if docs == nil {
+		docs = []store.Document{}
+	}
+	return docs, nil
+}
+
+func docsPurge(ctx context.Context, e *env, args []string) (interface{}, error) {
+	args, err := noFlags(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := admin.PurgeDocuments(ctx, e.db, args[0])
+	if err != nil {
+		return nil, userError(args[0], err)
+	}
+	record(ctx, e, "admin.documents.purge", args[0], map[string]interface{}{"chunks_deleted": chunks})
+	return map[string]interface{}{"username": args[0], "chunks_deleted": chunks}, nil
+}
+
+func migrate(ctx context.Context, e *env, args []string) (interface{}, error) {
+	if _, err := noFlags(args, 0); err != nil {
+		return nil, err
+	}
+	if _, err := e.schema.Migrate(ctx); err != nil {
+		return nil, err
+	}
+	return e.schema.MigrationStatus(ctx)
+}
+
+func migrateStatus(ctx context.Context, e *env, args []string) (interface{}, error) {
+	if _, err := noFlags(args, 0); err != nil {
+		return nil, err
+	}
+	return e.schema.MigrationStatus(ctx)
+}
+
+func reindex(ctx context.Context, e *env, args []string) (interface{}, error) {
+	if _, err := noFlags(args, 0); err != nil {
+		return nil, err
+	}
+	if err := e.schema.RebuildVectorIndex(ctx); err != nil {
+		return nil, err
+	}
+	record(ctx, e, "admin.reindex", mongostore.VectorIndexName, nil)
+	return map[string]string{"index": mongostore.VectorIndexName, "status": "rebuilding"}, nil
+}
+
+// keysGenerate prints a secret for rotating JWT_SECRET. Deploying it ends
+// every session, since tokens signed with the old secret stop verifying.
+func keysGenerate(ctx context.Context, e *env, args []string) (interface{}, error) {
+	if _, err := noFlags(args, 0); err != nil {
+		return nil, err
+	}
+	secret, err := randomString(48)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"jwt_secret": secret}, nil
+}
+
+// updateUser applies update, records it and returns the user without
+// their password
+func updateUser(ctx context.Context, e *env, username string, update store.UserUpdate, action string, details map[string]interface{}) (*store.User, error) {
+	user, err := e.db.UpdateUser(ctx, username, update)
+	if err != nil {
+		return nil, userError(username, err)
+	}
+	record(ctx, e, action, username, details)
+	user.Password = ""
+	user.Normalize()
+	return user, nil
+}
+
+func record(ctx context.Context, e *env, action, target string, details map[string]interface{}) {
+	audit.Record(ctx, e.db, audit.Event{Actor: actor, Action: action, Target: target, Details: details})
+}
+
+func userError(username string, err error) error {
+	if err == store.ErrNotFound {
+		return fmt.Errorf("user %s not found", username)
+	}
+	return err
+}
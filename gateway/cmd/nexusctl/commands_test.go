@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"nexus-gateway/store"
+	"nexus-gateway/store/memstore"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func run(t *testing.T, e *env, args ...string) (interface{}, error) {
+	t.Helper()
+	cmd, rest := lookup(args)
+	if cmd == nil {
+		t.Fatalf("no command %q", args)
+	}
+	return cmd.run(context.Background(), e, rest)
+}
+
+func TestUsers(t *testing.T) {
+	e := &env{db: memstore.New()}
+	ctx := context.Background()
+
+	out, err := run(t, e, "users", "create", "-admin", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := out.(credentials)
+	if created.Role != "admin" || created.Password == "" {
+		t.Errorf("created = %+v, want an admin with a generated password", created)
+	}
+	if _, err := run(t, e, "users", "create", "alice"); err == nil {
+		t.Error("creating alice twice succeeded")
+	}
+
+	e.stdin = strings.NewReader("new-password\n")
+	out, err = run(t, e, "users", "passwd", "-password-stdin", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.(credentials).Password != "" {
+		t.Error("passwd printed a password it did not generate")
+	}
+	user, _ := e.db.UserByName(ctx, "alice")
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("new-password")) != nil {
+		t.Error("password not changed")
+	}
+	if user.SessionVersion == 0 {
+		t.Error("passwd did not end sessions")
+	}
+
+	if _, err := run(t, e, "users", "role", "alice", "owner"); !errors.As(err, new(usageError)) {
+		t.Errorf("unknown role: err = %v, want a usage error", err)
+	}
+	if _, err := run(t, e, "users", "disable", "bob"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("disabling a missing user: err = %v", err)
+	}
+
+	var events []store.Event
+	e.db.EachEvent(ctx, store.EventFilter{Actor: actor}, func(ev store.Event) error {
+		events = append(events, ev)
+		return nil
+	})
+	if len(events) != 2 {
+		t.Errorf("%d audit events, want create and password", len(events))
+	}
+}
+
+func TestQuotaAndDocs(t *testing.T) {
+	e := &env{db: memstore.New()}
+	ctx := context.Background()
+	if _, err := run(t, e, "users", "create", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := e.db.UserByName(ctx, "alice")
+
+	// Two uploads, of 100 and 40 bytes, but a total that drifted
+	var chunks []store.Chunk
+	for i := 0; i < 3; i++ {
+		chunks = append(chunks, store.Chunk{UserID: user.ID, Filename: "a.txt", ChunkIndex: i, SizeBytes: 100})
+	}
+	chunks = append(chunks, store.Chunk{UserID: user.ID, Filename: "b.txt", SizeBytes: 40})
+	e.db.InsertChunks(ctx, chunks)
+	e.db.InsertDocument(ctx, store.Document{ID: primitive.NewObjectID(), UserID: user.ID, Filename: "a.txt", SizeBytes: 100, Chunks: 3})
+	drift := int64(999)
+	e.db.UpdateUser(ctx, "alice", store.UserUpdate{TotalStorageBytes: &drift})
+
+	out, err := run(t, e, "quota", "recalc", "-all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := out.([]storageChange)
+	if len(changes) != 1 || changes[0].Before != 999 || changes[0].After != 140 {
+		t.Errorf("recalc = %+v, want 999 -> 140", changes)
+	}
+	if _, err := run(t, e, "quota", "recalc", "-all", "alice"); !errors.As(err, new(usageError)) {
+		t.Errorf("-all with usernames: err = %v, want a usage error", err)
+	}
+
+	if _, err := run(t, e, "quota", "set", "alice", "0"); !errors.As(err, new(usageError)) {
+		t.Errorf("zero quota: err = %v, want a usage error", err)
+	}
+
+	out, err = run(t, e, "docs", "purge", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := out.(map[string]interface{})["chunks_deleted"]; n != int64(4) {
+		t.Errorf("chunks_deleted = %v, want 4", n)
+	}
+	out, _ = run(t, e, "docs", "list", "alice")
+	if docs := out.([]store.Document); len(docs) != 0 {
+		t.Errorf("documents left after purge: %+v", docs)
+	}
+	user, _ = e.db.UserByName(ctx, "alice")
+	if user.TotalStorageBytes != 0 {
+		t.Errorf("total_storage_bytes = %d after purge", user.TotalStorageBytes)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	cmd, args := lookup([]string{"migrate", "status"})
+	if cmd == nil || cmd.name != "migrate status" || len(args) != 0 {
+		t.Errorf("migrate status -> %v %q", cmd, args)
+	}
+	cmd, args = lookup([]string{"migrate"})
+	if cmd == nil || cmd.name != "migrate" {
+		t.Errorf("migrate -> %v %q", cmd, args)
+	}
+	if cmd, _ := lookup([]string{"users"}); cmd != nil {
+		t.Errorf("users -> %s, want no command", cmd.name)
+	}
+}
@@ -0,0 +1,116 @@
+// Command nexusctl runs administrative tasks directly against the gateway's
+// MongoDB database, using the same packages as the gateway. Results are
+// printed as JSON for scripting, e.g.
+//
+//	nexusctl users list | jq -r '.[].username'
+//
+// MONGODB_URI is read from the environment or .env like the gateway does.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"nexus-gateway/store/mongostore"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func main() {
+	godotenv.Load()
+
+	fs := flag.NewFlagSet("nexusctl", flag.ContinueOnError)
+	uri := fs.String("mongodb-uri", os.Getenv("MONGODB_URI"), "MongoDB connection string (MONGODB_URI)")
+	database := fs.String("database", "nexus_search", "database the gateway uses")
+	timeout := fs.Duration("timeout", 5*time.Minute, "give up after this long")
+	fs.Usage = func() { usage(fs.Output(), fs) }
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+
+	cmd, args := lookup(fs.Args())
+	if cmd == nil {
+		usage(os.Stderr, fs)
+		os.Exit(2)
+	}
+
+	// Commands that do not touch the database run without a connection
+	e := &env{stdin: os.Stdin}
+	if !cmd.offline {
+		if *uri == "" {
+			fail(errors.New("MONGODB_URI is required"))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(*uri))
+		cancel()
+		if err != nil {
+			fail(err)
+		}
+		defer client.Disconnect(context.Background())
+		db := mongostore.New(client.Database(*database))
+		e.db, e.schema = db, db
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	result, err := cmd.run(ctx, e, args)
+	cancel()
+
+	var uerr usageError
+	if errors.As(err, &uerr) {
+		fmt.Fprintf(os.Stderr, "%s\nusage: nexusctl %s %s\n", uerr, cmd.name, cmd.args)
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	out.Encode(result)
+}
+
+// fail prints err as JSON on stderr and exits
+func fail(err error) {
+	json.NewEncoder(os.Stderr).Encode(map[string]string{"error": err.Error()})
+	os.Exit(1)
+}
+
+// lookup finds the command named by the first one or two words of args and
+// returns it with the remaining arguments
+func lookup(args []string) (*command, []string) {
+	for n := 2; n >= 1; n-- {
+		if len(args) < n {
+			continue
+		}
+		name := strings.Join(args[:n], " ")
+		for i := range commands {
+			if commands[i].name == name {
+				return &commands[i], args[n:]
+			}
+		}
+	}
+	return nil, nil
+}
+
+func usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: nexusctl [flags] <command> [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", strings.TrimSpace(c.name+" "+c.args), c.help)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nflags:")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
@@ -0,0 +1,333 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Budget is a rate-limit budget: Rate requests per second on average with
+// bursts of up to Burst requests
+type Budget struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// Cookies are the attributes of the session cookies set at login
+type Cookies struct {
+	// SameSite is lax, strict or none
+	SameSite string `yaml:"samesite"`
+	Secure   bool   `yaml:"secure"`
+}
+
+// RateLimit configures the rate limiter. Budgets and Plans override the
+// built-in defaults per class and plan.
+type RateLimit struct {
+	// Store is memory (per replica) or mongo (shared between replicas)
+	Store   string             `yaml:"store"`
+	Budgets map[string]Budget  `yaml:"budgets"`
+	Plans   map[string]float64 `yaml:"plans"`
+}
+
+// Providers override the web search endpoints, e.g. to go through a proxy.
+// Empty values mean the public APIs.
+type Providers struct {
+	SerpAPIURL    string `yaml:"serpapi_url"`
+	DuckDuckGoURL string `yaml:"duckduckgo_url"`
+	WikipediaURL  string `yaml:"wikipedia_url"`
+}
+
+// Config is the gateway configuration. It is loaded once at startup from, in
+// increasing order of precedence, the defaults, an optional YAML file, the
+// environment and command-line flags.
+type Config struct {
+	Port       string    `yaml:"port"`
+	MongoURI   string    `yaml:"mongodb_uri"`
+	JWTSecret  string    `yaml:"jwt_secret"`
+	SerpAPIKey string    `yaml:"serpapi_key"`
+	WorkerURL  string    `yaml:"worker_url"`
+	Providers  Providers `yaml:"providers"`
+
+	// GRPCPort serves the gRPC API as well when set
+	GRPCPort string `yaml:"grpc_port"`
+
+	// AllowedOrigins are added to the built-in localhost origins for CORS
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// TrustedProxies are the CIDRs or IPs whose forwarding header is believed
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// TrustedProxyHeader is the forwarding header those proxies write,
+	// X-Forwarded-For or Forwarded; the other one is ignored
+	TrustedProxyHeader string `yaml:"trusted_proxy_header"`
+	// AdminUsers are given the admin role when they register
+	AdminUsers []string `yaml:"admin_users"`
+
+	// MigrateOnStart applies pending database migrations before serving
+	MigrateOnStart bool `yaml:"migrate_on_start"`
+
+	Cookies              Cookies       `yaml:"cookies"`
+	RateLimit            RateLimit     `yaml:"rate_limit"`
+	AccountDeletionGrace time.Duration `yaml:"account_deletion_grace"`
+
+	LogLevel        string        `yaml:"log_level"`
+	TracesExporter  string        `yaml:"traces_exporter"`
+	MetricsToken    string        `yaml:"metrics_token"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// Default returns the configuration used for anything left unset
+func Default() *Config {
+	return &Config{
+		Port:                 "8080",
+		WorkerURL:            "http://127.0.0.1:5000",
+		TrustedProxyHeader:   "X-Forwarded-For",
+		MigrateOnStart:       true,
+		Cookies:              Cookies{SameSite: "lax"},
+		RateLimit:            RateLimit{Store: "memory"},
+		AccountDeletionGrace: 7 * 24 * time.Hour,
+		LogLevel:             "info",
+		ShutdownTimeout:      30 * time.Second,
+	}
+}
+
+// Load builds the configuration from args (without the program name) and
+// getenv, and validates it. The YAML file is taken from -config or
+// NEXUS_CONFIG.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("gateway", flag.ContinueOnError)
+	path := fs.String("config", getenv("NEXUS_CONFIG"), "path to a YAML config file")
+	port := fs.String("port", "", "port to listen on (PORT)")
+	logLevel := fs.String("log-level", "", "debug, info, warn or error (LOG_LEVEL)")
+	workerURL := fs.String("worker-url", "", "base URL of the Python worker (WORKER_URL)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	// Flags win over everything else
+	if *port != "" {
+		cfg.Port = *port
+	}
+	if *logLevel != "" {
+		cfg.LogLevel = *logLevel
+	}
+	if *workerURL != "" {
+		cfg.WorkerURL = *workerURL
+	}
+
+	cfg.WorkerURL = strings.TrimRight(cfg.WorkerURL, "/")
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv overrides c with the environment variables that are set
+func (c *Config) loadEnv(getenv func(string) string) error {
+	var errs []error
+	str := func(dst *string, name string) {
+		if v := getenv(name); v != "" {
+			*dst = v
+		}
+	}
+	list := func(dst *[]string, name string) {
+		if v := getenv(name); v != "" {
+			*dst = splitList(v)
+		}
+	}
+	duration := func(dst *time.Duration, name string) {
+		if v := getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	str(&c.Port, "PORT")
+	str(&c.GRPCPort, "GRPC_PORT")
+	str(&c.MongoURI, "MONGODB_URI")
+	str(&c.JWTSecret, "JWT_SECRET")
+	str(&c.SerpAPIKey, "SERPAPI_KEY")
+	str(&c.WorkerURL, "WORKER_URL")
+	str(&c.Providers.SerpAPIURL, "SERPAPI_URL")
+	str(&c.Providers.DuckDuckGoURL, "DUCKDUCKGO_URL")
+	str(&c.Providers.WikipediaURL, "WIKIPEDIA_URL")
+	list(&c.AllowedOrigins, "ALLOWED_ORIGINS")
+	list(&c.TrustedProxies, "TRUSTED_PROXIES")
+	str(&c.TrustedProxyHeader, "TRUSTED_PROXY_HEADER")
+	list(&c.AdminUsers, "ADMIN_USERS")
+	str(&c.Cookies.SameSite, "COOKIE_SAMESITE")
+	if v := getenv("COOKIE_SECURE"); v != "" {
+		secure, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("COOKIE_SECURE: %w", err))
+		}
+		c.Cookies.Secure = secure
+	}
+	if v := getenv("MIGRATE_ON_START"); v != "" {
+		migrate, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("MIGRATE_ON_START: %w", err))
+		}
+		c.MigrateOnStart = migrate
+	}
+	str(&c.RateLimit.Store, "RATE_LIMIT_STORE")
+	if v := getenv("RATE_LIMITS"); v != "" {
+		budgets, err := parseBudgets(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("RATE_LIMITS: %w", err))
+		}
+		c.RateLimit.Budgets = budgets
+	}
+	if v := getenv("RATE_LIMIT_PLANS"); v != "" {
+		plans, err := parsePlans(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("RATE_LIMIT_PLANS: %w", err))
+		}
+		c.RateLimit.Plans = plans
+	}
+	duration(&c.AccountDeletionGrace, "ACCOUNT_DELETION_GRACE")
+	str(&c.LogLevel, "LOG_LEVEL")
+	str(&c.TracesExporter, "OTEL_TRACES_EXPORTER")
+	str(&c.MetricsToken, "METRICS_TOKEN")
+	duration(&c.ShutdownTimeout, "SHUTDOWN_TIMEOUT")
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every problem with c at once
+func (c *Config) Validate() error {
+	var errs []error
+	if c.MongoURI == "" {
+		errs = append(errs, errors.New("MONGODB_URI is required"))
+	}
+	if c.JWTSecret == "" {
+		errs = append(errs, errors.New("JWT_SECRET is required"))
+	} else if len(c.JWTSecret) < 32 {
+		slog.Warn("JWT_SECRET is shorter than 32 bytes; use a longer random secret")
+	}
+	if c.Port == "" {
+		errs = append(errs, errors.New("port must not be empty"))
+	}
+	if c.GRPCPort != "" && c.GRPCPort == c.Port {
+		errs = append(errs, errors.New("GRPC_PORT must differ from the HTTP port"))
+	}
+	if c.WorkerURL == "" {
+		errs = append(errs, errors.New("WORKER_URL must not be empty"))
+	}
+	if c.TrustedProxyHeader == "" {
+		errs = append(errs, errors.New("TRUSTED_PROXY_HEADER must not be empty"))
+	}
+	switch strings.ToLower(c.Cookies.SameSite) {
+	case "lax", "strict", "none":
+	default:
+		errs = append(errs, fmt.Errorf("COOKIE_SAMESITE must be lax, strict or none, not %q", c.Cookies.SameSite))
+	}
+	switch c.RateLimit.Store {
+	case "memory", "mongo":
+	default:
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_STORE must be memory or mongo, not %q", c.RateLimit.Store))
+	}
+	for class, b := range c.RateLimit.Budgets {
+		if b.Rate <= 0 || b.Burst <= 0 {
+			errs = append(errs, fmt.Errorf("rate limit for %s must have a positive rate and burst", class))
+		}
+	}
+	for plan, m := range c.RateLimit.Plans {
+		if m <= 0 {
+			errs = append(errs, fmt.Errorf("rate limit multiplier for plan %s must be positive", plan))
+		}
+	}
+	if c.AccountDeletionGrace < 0 {
+		errs = append(errs, errors.New("ACCOUNT_DELETION_GRACE must not be negative"))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: unknown level %q", c.LogLevel))
+	}
+	switch strings.ToLower(c.TracesExporter) {
+	case "", "none", "otlp", "stdout":
+	default:
+		errs = append(errs, fmt.Errorf("OTEL_TRACES_EXPORTER must be otlp, stdout or none, not %q", c.TracesExporter))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("SHUTDOWN_TIMEOUT must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// parseBudgets parses "login=0.1:5,serp=0.5:10" (class=requests-per-second:burst)
+func parseBudgets(s string) (map[string]Budget, error) {
+	budgets := make(map[string]Budget)
+	for _, item := range splitList(s) {
+		class, spec, ok := strings.Cut(item, "=")
+		rateStr, burstStr, ok2 := strings.Cut(spec, ":")
+		rate, err1 := strconv.ParseFloat(rateStr, 64)
+		burst, err2 := strconv.Atoi(burstStr)
+		if !ok || !ok2 || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid budget %q", item)
+		}
+		budgets[strings.TrimSpace(class)] = Budget{Rate: rate, Burst: burst}
+	}
+	return budgets, nil
+}
+
+// parsePlans parses "free=1,pro=5"
+func parsePlans(s string) (map[string]float64, error) {
+	plans := make(map[string]float64)
+	for _, item := range splitList(s) {
+		plan, spec, ok := strings.Cut(item, "=")
+		m, err := strconv.ParseFloat(strings.TrimSpace(spec), 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid plan multiplier %q", item)
+		}
+		plans[strings.TrimSpace(plan)] = m
+	}
+	return plans, nil
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	file := `
+port: "9000"
+mongodb_uri: mongodb://file
+jwt_secret: from-file
+worker_url: http://worker-from-file/
+shutdown_timeout: 10s
+rate_limit:
+  store: mongo
+  budgets:
+    login: {rate: 0.5, burst: 2}
+`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load([]string{"-config", path, "-port", "7000"}, env(map[string]string{
+		"JWT_SECRET":           "from-env",
+		"ALLOWED_ORIGINS":      "https://a.example, https://b.example",
+		"TRUSTED_PROXY_HEADER": "Forwarded",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port != "7000" {
+		t.Errorf("Port = %q, want the flag value", cfg.Port)
+	}
+	if cfg.JWTSecret != "from-env" {
+		t.Errorf("JWTSecret = %q, want the env value", cfg.JWTSecret)
+	}
+	if cfg.MongoURI != "mongodb://file" || cfg.RateLimit.Store != "mongo" {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+	if cfg.WorkerURL != "http://worker-from-file" {
+		t.Errorf("WorkerURL = %q, want trailing slash trimmed", cfg.WorkerURL)
+	}
+	if cfg.ShutdownTimeout != 10*time.Second {
+		t.Errorf("ShutdownTimeout = %v", cfg.ShutdownTimeout)
+	}
+	if b := cfg.RateLimit.Budgets["login"]; b.Rate != 0.5 || b.Burst != 2 {
+		t.Errorf("login budget = %+v", b)
+	}
+	if len(cfg.AllowedOrigins) != 2 || cfg.AllowedOrigins[1] != "https://b.example" {
+		t.Errorf("AllowedOrigins = %q", cfg.AllowedOrigins)
+	}
+	if cfg.TrustedProxyHeader != "Forwarded" {
+		t.Errorf("TrustedProxyHeader = %q, want the env value", cfg.TrustedProxyHeader)
+	}
+	if cfg.LogLevel != "info" || cfg.AccountDeletionGrace != 7*24*time.Hour {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	valid := map[string]string{"MONGODB_URI": "mongodb://localhost", "JWT_SECRET": "secret"}
+	tests := []struct {
+		name string
+		set  map[string]string
+		want string
+	}{
+		{"empty secret", map[string]string{"JWT_SECRET": ""}, "JWT_SECRET is required"},
+		{"missing mongo", map[string]string{"MONGODB_URI": ""}, "MONGODB_URI is required"},
+		{"bad duration", map[string]string{"SHUTDOWN_TIMEOUT": "soon"}, "SHUTDOWN_TIMEOUT"},
+		{"bad budget", map[string]string{"RATE_LIMITS": "login=fast"}, "RATE_LIMITS"},
+		{"zero burst", map[string]string{"RATE_LIMITS": "login=1:0"}, "positive rate and burst"},
+		{"bad store", map[string]string{"RATE_LIMIT_STORE": "redis"}, "RATE_LIMIT_STORE"},
+		{"bad level", map[string]string{"LOG_LEVEL": "loud"}, "LOG_LEVEL"},
+		{"bad samesite", map[string]string{"COOKIE_SAMESITE": "sometimes"}, "COOKIE_SAMESITE"},
+		{"same ports", map[string]string{"PORT": "9000", "GRPC_PORT": "9000"}, "GRPC_PORT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := make(map[string]string)
+			for k, v := range valid {
+				vars[k] = v
+			}
+			for k, v := range tt.set {
+				vars[k] = v
+			}
+			_, err := Load(nil, env(vars))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package documents
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/audit"
+	"nexus-gateway/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Document is the metadata record of an uploaded file; see store.Document
+type Document = store.Document
+
+// Insert stores the metadata record of a processed upload
+func Insert(ctx context.Context, docs store.DocumentStore, doc Document) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return docs.InsertDocument(ctx, doc)
+}
+
+// Listing is a document together with its active share links
+type Listing struct {
+	Document
+	Shares []Share `json:"shares"`
+}
+
+// userOID resolves a username to the user's ObjectID
+func userOID(ctx context.Context, users store.UserStore, username string) (primitive.ObjectID, error) {
+	user, err := users.UserByName(ctx, username)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return user.ID, nil
+}
+
+// List returns the documents uploaded by ownerID, newest first, each with
+// its share links that have not been revoked
+func List(ctx context.Context, db store.Store, ownerID primitive.ObjectID) ([]Listing, error) {
+	docs, err := db.DocumentsByUser(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	active, err := db.ActiveShares(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	byDoc := make(map[primitive.ObjectID][]Share)
+	for _, sh := range active {
+		byDoc[sh.DocumentID] = append(byDoc[sh.DocumentID], sh)
+	}
+
+	result := make([]Listing, 0, len(docs))
+	for _, d := range docs {
+		l := Listing{Document: d, Shares: byDoc[d.ID]}
+		if l.Shares == nil {
+			l.Shares = []Share{}
+		}
+		result = append(result, l)
+	}
+	return result, nil
+}
+
+// ListHandler returns the caller's documents, see List
+func ListHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+		username := r.Context().Value("user").(string)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		ownerID, err := userOID(ctx, db, username)
+		if err != nil {
+			apierror.Unauthorized(w, r, "User not found")
+			return
+		}
+
+		result, err := List(ctx, db, ownerID)
+		if err != nil {
+			apierror.Internal(w, r, "Failed to list documents", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// Delete removes one of user's documents and its chunks and frees its
+// storage, recording the event for who. Share links to it stop working. It
+// returns store.ErrNotFound unless user uploaded the document.
+func Delete(ctx context.Context, db store.Store, user *store.User, docID primitive.ObjectID, who audit.Event) error {
+	doc, err := db.Document(ctx, docID)
+	if err != nil {
+		return err
+	}
+	if doc.UserID != user.ID {
+		return store.ErrNotFound
+	}
+
+	chunks, err := db.DeleteChunksByDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	if err := db.DeleteDocument(ctx, docID); err != nil && err != store.ErrNotFound {
+		return err
+	}
+
+	if _, err := db.UpdateUser(ctx, user.Username, store.UserUpdate{AddStorageBytes: -doc.SizeBytes}); err != nil {
+		return err
+	}
+
+	who.Action = "document.delete"
+	who.Target = docID.Hex()
+	who.Details = map[string]interface{}{"filename": doc.Filename, "size_bytes": doc.SizeBytes, "chunks_deleted": chunks}
+	audit.Record(ctx, db, who)
+	return nil
+}
+
+// DeleteHandler deletes one of the caller's documents
+// (DELETE /api/v1/documents/{document}), see Delete
+func DeleteHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("user").(string)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		user, err := db.UserByName(ctx, username)
+		if err != nil {
+			apierror.Unauthorized(w, r, "User not found")
+			return
+		}
+		docID, err := primitive.ObjectIDFromHex(r.PathValue("document"))
+		if err != nil {
+			apierror.BadRequest(w, r, "Invalid document")
+			return
+		}
+
+		err = Delete(ctx, db, user, docID, audit.Requester(r))
+		if err == store.ErrNotFound {
+			apierror.NotFound(w, r, "Document not found")
+			return
+		}
+		if err != nil {
+			apierror.Internal(w, r, "Failed to delete document", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
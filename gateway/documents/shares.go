@@ -0,0 +1,218 @@
+package documents
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/audit"
+	"nexus-gateway/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrInvalidShare = errors.New("invalid share link")
+	// ErrShareUnavailable covers revoked, expired and used-up links
+	ErrShareUnavailable = errors.New("share link is no longer available")
+)
+
+// Share is a signed, revocable link to a single document; see store.Share
+type Share = store.Share
+
+// ShareRequest creates a share link. DocumentID is only read on the
+// deprecated route; /api/v1 takes it from the path.
+type ShareRequest struct {
+	DocumentID string `json:"document_id,omitempty"`
+	// ExpiresIn is a duration such as "72h"; empty means the link never expires
+	ExpiresIn string `json:"expires_in,omitempty"`
+	MaxUses   int    `json:"max_uses,omitempty"`
+}
+
+type ShareResponse struct {
+	Share Share  `json:"share"`
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// signShare returns the MAC of a share ID. The "share:" prefix keeps these
+// signatures distinct from anything else signed with the same secret.
+func signShare(secret string, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("share:" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ShareToken builds the signed token handed out for a share
+func ShareToken(secret string, id primitive.ObjectID) string {
+	return id.Hex() + "." + signShare(secret, id.Hex())
+}
+
+func parseShareToken(secret, token string) (primitive.ObjectID, error) {
+	idHex, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return primitive.NilObjectID, ErrInvalidShare
+	}
+	if !hmac.Equal([]byte(sig), []byte(signShare(secret, idHex))) {
+		return primitive.NilObjectID, ErrInvalidShare
+	}
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidShare
+	}
+	return id, nil
+}
+
+// RedeemShare verifies a share token and counts one use of it. It returns
+// the shared document if the link is still valid.
+func RedeemShare(ctx context.Context, db store.Store, secret, token string) (*Share, *Document, error) {
+	id, err := parseShareToken(secret, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	share, err := db.UseShare(ctx, id, time.Now().UTC())
+	if err == store.ErrNotFound {
+		return nil, nil, ErrShareUnavailable
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := db.Document(ctx, share.DocumentID)
+	if err == store.ErrNotFound {
+		return nil, nil, ErrShareUnavailable
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return share, doc, nil
+}
+
+// SharesHandler lets document owners manage share links: POST
+// /api/v1/documents/{document}/shares creates a signed link and DELETE
+// /api/v1/shares/{id} revokes one. The deprecated /api/documents/shares takes
+// document_id in the body and ?id= instead.
+func SharesHandler(db store.Store, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("user").(string)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		ownerID, err := userOID(ctx, db, username)
+		if err != nil {
+			apierror.Unauthorized(w, r, "User not found")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			// The body may be empty when the document is in the path
+			var req ShareRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); (err != nil && err != io.EOF) || req.MaxUses < 0 {
+				apierror.BadRequest(w, r, "Invalid request")
+				return
+			}
+			if id := r.PathValue("document"); id != "" {
+				req.DocumentID = id
+			}
+			docID, err := primitive.ObjectIDFromHex(req.DocumentID)
+			if err != nil {
+				apierror.BadRequest(w, r, "Invalid document_id")
+				return
+			}
+
+			doc, err := db.Document(ctx, docID)
+			if err != nil && err != store.ErrNotFound {
+				apierror.Internal(w, r, "Server error", err)
+				return
+			}
+			if doc == nil || doc.UserID != ownerID {
+				apierror.NotFound(w, r, "Document not found")
+				return
+			}
+
+			share := Share{
+				ID:         primitive.NewObjectID(),
+				DocumentID: docID,
+				OwnerID:    ownerID,
+				CreatedAt:  time.Now().UTC(),
+				MaxUses:    req.MaxUses,
+			}
+			if req.ExpiresIn != "" {
+				d, err := time.ParseDuration(req.ExpiresIn)
+				if err != nil || d <= 0 {
+					apierror.BadRequest(w, r, "Invalid expires_in")
+					return
+				}
+				expiresAt := share.CreatedAt.Add(d)
+				share.ExpiresAt = &expiresAt
+			}
+
+			if err := db.InsertShare(ctx, share); err != nil {
+				apierror.Internal(w, r, "Failed to create share", err)
+				return
+			}
+
+			audit.RecordRequest(r, db, audit.Event{
+				Actor:   username,
+				Action:  "document.share.create",
+				Target:  docID.Hex(),
+				Details: map[string]interface{}{"share_id": share.ID.Hex(), "expires_at": share.ExpiresAt, "max_uses": share.MaxUses},
+			})
+
+			token := ShareToken(secret, share.ID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(ShareResponse{
+				Share: share,
+				Token: token,
+				URL:   "/api/v1/shared/search?token=" + token,
+			})
+
+		case http.MethodDelete:
+			id := r.PathValue("id")
+			if id == "" {
+				id = r.URL.Query().Get("id")
+			}
+			shareID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				apierror.BadRequest(w, r, "Invalid id")
+				return
+			}
+
+			err = db.RevokeShare(ctx, shareID, ownerID, time.Now().UTC())
+			if err == store.ErrNotFound {
+				apierror.NotFound(w, r, "Share not found")
+				return
+			}
+			if err != nil {
+				apierror.Internal(w, r, "Failed to revoke share", err)
+				return
+			}
+
+			audit.RecordRequest(r, db, audit.Event{
+				Actor:  username,
+				Action: "document.share.revoke",
+				Target: shareID.Hex(),
+			})
+
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			apierror.MethodNotAllowed(w, r)
+		}
+	}
+}
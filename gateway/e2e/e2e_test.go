@@ -0,0 +1,613 @@
+package e2e
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/audit"
+	"nexus-gateway/auth"
+	"nexus-gateway/config"
+	"nexus-gateway/middleware"
+	"nexus-gateway/openapi"
+	"nexus-gateway/search"
+)
+
+const notes = `Gophers dig long burrows under meadows and gardens.
+
+Sourdough bread needs a starter, flour, water and salt.
+
+The quarterly budget review happens on the first Monday.`
+
+func searchFor(t *testing.T, h *Harness, token string, params url.Values) search.SearchResponse {
+	t.Helper()
+	resp := h.Do(t, http.MethodGet, "/api/v1/search?"+params.Encode(), token, nil)
+	Expect(t, resp, http.StatusOK)
+	var body search.SearchResponse
+	Decode(t, resp, &body)
+	return body
+}
+
+func TestRegisterLoginUploadSearch(t *testing.T) {
+	h := Start(t)
+	token := h.Signup(t, "alice")
+
+	resp := h.Upload(t, token, "notes.txt", notes, nil)
+	Expect(t, resp, http.StatusOK)
+	var uploaded struct {
+		Chunks     int    `json:"chunks"`
+		DocumentID string `json:"document_id"`
+	}
+	Decode(t, resp, &uploaded)
+	if uploaded.Chunks != 3 || uploaded.DocumentID == "" {
+		t.Fatalf("upload response = %+v", uploaded)
+	}
+
+	resp = h.Do(t, http.MethodGet, "/api/v1/user", token, nil)
+	Expect(t, resp, http.StatusOK)
+	var profile auth.User
+	Decode(t, resp, &profile)
+	if profile.TotalStorageBytes != int64(len(notes)) {
+		t.Errorf("total_storage_bytes = %d, want %d", profile.TotalStorageBytes, len(notes))
+	}
+
+	body := searchFor(t, h, token, url.Values{"q": {"sourdough bread starter"}, "pkb": {"true"}})
+	if len(body.Results) == 0 {
+		t.Fatal("PKB search returned nothing")
+	}
+	top := body.Results[0]
+	if top.Source != "PKB (notes.txt)" || !strings.Contains(top.Snippet, "Sourdough") {
+		t.Errorf("top result = %+v, want the sourdough chunk of notes.txt", top)
+	}
+
+	resp = h.Do(t, http.MethodGet, "/api/v1/documents", token, nil)
+	Expect(t, resp, http.StatusOK)
+	var docs []struct {
+		ID       string `json:"id"`
+		Filename string `json:"filename"`
+		Chunks   int    `json:"chunks"`
+	}
+	Decode(t, resp, &docs)
+	if len(docs) != 1 || docs[0].ID != uploaded.DocumentID || docs[0].Chunks != 3 {
+		t.Errorf("documents = %+v", docs)
+	}
+}
+
+func TestWebSources(t *testing.T) {
+	h := Start(t)
+	token := h.Signup(t, "alice")
+
+	body := searchFor(t, h, token, url.Values{"q": {"gophers"}, "web": {"true"}, "wiki": {"true"}, "ddg": {"true"}})
+
+	sources := make(map[string]int)
+	for _, r := range body.Results {
+		sources[r.Source]++
+	}
+	// SerpApi is capped at 3 results and DuckDuckGo at 2 related topics
+	want := map[string]int{"Google (SerpApi)": 3, "Wikipedia": 2, "DuckDuckGo (Instant)": 1, "DuckDuckGo": 2}
+	for source, n := range want {
+		if sources[source] != n {
+			t.Errorf("%d results from %s, want %d (all: %v)", sources[source], source, n, sources)
+		}
+	}
+	for _, r := range body.Results {
+		if strings.Contains(r.Snippet, "<span") {
+			t.Errorf("snippet still has HTML: %q", r.Snippet)
+		}
+	}
+
+	queries := h.Providers.Queries("serpapi")
+	if len(queries) != 1 || queries[0].Get("q") != "gophers" || queries[0].Get("api_key") != h.Config.SerpAPIKey {
+		t.Errorf("SerpApi queries = %v", queries)
+	}
+}
+
+func TestProviderOutage(t *testing.T) {
+	h := Start(t)
+	token := h.Signup(t, "alice")
+	h.Providers.SetDown("serpapi", true)
+
+	// A failing provider is left out rather than failing the whole search
+	body := searchFor(t, h, token, url.Values{"q": {"gophers"}, "web": {"true"}, "wiki": {"true"}})
+	for _, r := range body.Results {
+		if r.Source != "Wikipedia" {
+			t.Errorf("unexpected result from %s", r.Source)
+		}
+	}
+	if len(body.Results) != 2 {
+		t.Errorf("%d results, want the 2 from Wikipedia", len(body.Results))
+	}
+}
+
+func TestSearchOnlySeesOwnDocuments(t *testing.T) {
+	h := Start(t)
+	alice := h.Signup(t, "alice")
+	bob := h.Signup(t, "bob")
+
+	Expect(t, h.Upload(t, alice, "alice.txt", "Gophers dig long burrows under meadows.", nil), http.StatusOK)
+
+	body := searchFor(t, h, bob, url.Values{"q": {"gophers burrows"}, "pkb": {"true"}})
+	if len(body.Results) != 0 {
+		t.Errorf("bob found alice's documents: %+v", body.Results)
+	}
+}
+
+func TestWorkspaceSharing(t *testing.T) {
+	h := Start(t)
+	alice := h.Signup(t, "alice")
+	bob := h.Signup(t, "bob")
+
+	resp := h.Do(t, http.MethodPost, "/api/v1/workspaces", alice, map[string]string{"name": "garden"})
+	Expect(t, resp, http.StatusCreated)
+	var ws struct {
+		ID string `json:"id"`
+	}
+	Decode(t, resp, &ws)
+
+	resp = h.Do(t, http.MethodPost, "/api/v1/workspaces/"+ws.ID+"/members", alice, map[string]string{
+		"username": "bob", "role": "viewer",
+	})
+	Expect(t, resp, http.StatusOK)
+
+	Expect(t, h.Upload(t, alice, "garden.txt", "Gophers dig long burrows under meadows.", map[string]string{"workspace": ws.ID}), http.StatusOK)
+
+	// Viewers can search the workspace but not upload to it
+	body := searchFor(t, h, bob, url.Values{"q": {"gophers burrows"}, "pkb": {"true"}})
+	if len(body.Results) != 1 || body.Results[0].Title != "garden.txt" {
+		t.Errorf("bob's results = %+v, want garden.txt from the workspace", body.Results)
+	}
+	Expect(t, h.Upload(t, bob, "bob.txt", "hello", map[string]string{"workspace": ws.ID}), http.StatusForbidden)
+
+	// Members can leave
+	Expect(t, h.Do(t, http.MethodDelete, "/api/v1/workspaces/"+ws.ID+"/members/bob", bob, nil), http.StatusNoContent)
+	body = searchFor(t, h, bob, url.Values{"q": {"gophers burrows"}, "pkb": {"true"}})
+	if len(body.Results) != 0 {
+		t.Errorf("bob still sees the workspace after leaving: %+v", body.Results)
+	}
+}
+
+func TestWorkspaceCreatorStaysOwner(t *testing.T) {
+	h := Start(t)
+	alice := h.Signup(t, "alice")
+	bob := h.Signup(t, "bob")
+
+	resp := h.Do(t, http.MethodPost, "/api/v1/workspaces", alice, map[string]string{"name": "garden"})
+	Expect(t, resp, http.StatusCreated)
+	var ws struct {
+		ID string `json:"id"`
+	}
+	Decode(t, resp, &ws)
+	members := "/api/v1/workspaces/" + ws.ID + "/members"
+	Expect(t, h.Do(t, http.MethodPost, members, alice, map[string]string{"username": "bob", "role": "owner"}), http.StatusOK)
+
+	// A second owner can neither demote nor remove the creator
+	Expect(t, h.Do(t, http.MethodPost, members, bob, map[string]string{"username": "alice", "role": "viewer"}), http.StatusForbidden)
+	Expect(t, h.Do(t, http.MethodDelete, members+"/alice", bob, nil), http.StatusForbidden)
+
+	// but the creator can still demote and remove other owners
+	Expect(t, h.Do(t, http.MethodPost, members, alice, map[string]string{"username": "bob", "role": "editor"}), http.StatusOK)
+	Expect(t, h.Do(t, http.MethodDelete, members+"/bob", alice, nil), http.StatusNoContent)
+}
+
+func TestShareLink(t *testing.T) {
+	h := Start(t)
+	alice := h.Signup(t, "alice")
+	bob := h.Signup(t, "bob")
+
+	resp := h.Upload(t, alice, "notes.txt", notes, nil)
+	Expect(t, resp, http.StatusOK)
+	var uploaded struct {
+		DocumentID string `json:"document_id"`
+	}
+	Decode(t, resp, &uploaded)
+
+	resp = h.Do(t, http.MethodPost, "/api/v1/documents/"+uploaded.DocumentID+"/shares", alice, map[string]interface{}{
+		"max_uses": 1,
+	})
+	Expect(t, resp, http.StatusCreated)
+	var share struct {
+		Token string `json:"token"`
+	}
+	Decode(t, resp, &share)
+
+	resp = h.Do(t, http.MethodGet, "/api/v1/shared/chunks?token="+url.QueryEscape(share.Token), bob, nil)
+	Expect(t, resp, http.StatusOK)
+	var shared struct {
+		Chunks []search.SharedChunk `json:"chunks"`
+	}
+	Decode(t, resp, &shared)
+	if len(shared.Chunks) != 3 || !strings.HasPrefix(shared.Chunks[0].Content, "Gophers") {
+		t.Errorf("shared chunks = %+v", shared.Chunks)
+	}
+
+	// The link allowed a single use
+	resp = h.Do(t, http.MethodGet, "/api/v1/shared/chunks?token="+url.QueryEscape(share.Token), bob, nil)
+	Expect(t, resp, http.StatusGone)
+}
+
+func TestDeleteDocument(t *testing.T) {
+	h := Start(t)
+	alice := h.Signup(t, "alice")
+	bob := h.Signup(t, "bob")
+
+	resp := h.Upload(t, alice, "notes.txt", notes, nil)
+	Expect(t, resp, http.StatusOK)
+	var uploaded struct {
+		DocumentID string `json:"document_id"`
+	}
+	Decode(t, resp, &uploaded)
+	path := "/api/v1/documents/" + uploaded.DocumentID
+
+	// Only the uploader can delete it
+	Expect(t, h.Do(t, http.MethodDelete, path, bob, nil), http.StatusNotFound)
+	Expect(t, h.Do(t, http.MethodDelete, path, alice, nil), http.StatusNoContent)
+	Expect(t, h.Do(t, http.MethodDelete, path, alice, nil), http.StatusNotFound)
+
+	body := searchFor(t, h, alice, url.Values{"q": {"sourdough bread starter"}, "pkb": {"true"}})
+	if len(body.Results) != 0 {
+		t.Errorf("deleted document still found: %+v", body.Results)
+	}
+	resp = h.Do(t, http.MethodGet, "/api/v1/user", alice, nil)
+	Expect(t, resp, http.StatusOK)
+	var profile auth.User
+	Decode(t, resp, &profile)
+	if profile.TotalStorageBytes != 0 {
+		t.Errorf("total_storage_bytes = %d after deleting the only document", profile.TotalStorageBytes)
+	}
+}
+
+func TestWorkerFailures(t *testing.T) {
+	h := Start(t)
+	token := h.Signup(t, "alice")
+
+	// Worker failures are the gateway's upstream, not the client's fault
+	h.Worker.Fail("process", http.StatusInternalServerError)
+	Expect(t, h.Upload(t, token, "notes.txt", notes, nil), http.StatusBadGateway)
+
+	h.Worker.Fail("health", http.StatusServiceUnavailable)
+	Expect(t, h.Do(t, http.MethodGet, "/readyz", "", nil), http.StatusServiceUnavailable)
+
+	h.Worker.Fail("health", 0)
+	Expect(t, h.Do(t, http.MethodGet, "/readyz", "", nil), http.StatusOK)
+}
+
+func TestAuthRequired(t *testing.T) {
+	h := Start(t)
+
+	Expect(t, h.Do(t, http.MethodGet, "/api/v1/search?q=gophers", "", nil), http.StatusUnauthorized)
+	Expect(t, h.Do(t, http.MethodGet, "/api/v1/user", "not-a-token", nil), http.StatusUnauthorized)
+
+	h.Register(t, "alice", Password)
+	resp := h.Do(t, http.MethodPost, "/api/v1/login", "", map[string]string{"username": "alice", "password": "wrong"})
+	Expect(t, resp, http.StatusUnauthorized)
+
+	// Non-admins are kept out of the admin API
+	token := h.Login(t, "alice", Password)
+	Expect(t, h.Do(t, http.MethodGet, "/api/v1/admin/users", token, nil), http.StatusForbidden)
+}
+
+func TestRouting(t *testing.T) {
+	h := Start(t)
+	token := h.Signup(t, "alice")
+
+	// Wrong methods are refused by the router, before any handler runs
+	resp := h.Do(t, http.MethodGet, "/api/v1/login", "", nil)
+	Expect(t, resp, http.StatusMethodNotAllowed)
+	if allow := resp.Header.Get("Allow"); allow != "POST" {
+		t.Errorf("Allow = %q, want POST", allow)
+	}
+	Expect(t, h.Do(t, http.MethodGet, "/api/login", "", nil), http.StatusMethodNotAllowed)
+	Expect(t, h.Do(t, http.MethodPut, "/api/v1/user", token, nil), http.StatusMethodNotAllowed)
+	Expect(t, h.Do(t, http.MethodGet, "/api/v1/nothing-here", token, nil), http.StatusNotFound)
+
+	resp = h.Do(t, http.MethodGet, "/api/v1/user", token, nil)
+	Expect(t, resp, http.StatusOK)
+	if resp.Header.Get("Deprecation") != "" {
+		t.Error("/api/v1/user is marked deprecated")
+	}
+
+	// The unversioned paths still work but point at their replacement
+	resp = h.Do(t, http.MethodGet, "/api/user", token, nil)
+	Expect(t, resp, http.StatusOK)
+	if resp.Header.Get("Deprecation") != "true" || resp.Header.Get("Link") != `</api/v1/user>; rel="successor-version"` {
+		t.Errorf("deprecated route headers = %v", resp.Header)
+	}
+
+	resp = h.Do(t, http.MethodPost, "/api/workspaces", token, map[string]string{"name": "garden"})
+	Expect(t, resp, http.StatusCreated)
+	var ws struct {
+		ID string `json:"id"`
+	}
+	Decode(t, resp, &ws)
+	h.Signup(t, "bob")
+	resp = h.Do(t, http.MethodPost, "/api/workspaces/members", token, map[string]string{
+		"workspace": ws.ID, "username": "bob", "role": "viewer",
+	})
+	Expect(t, resp, http.StatusOK)
+	if resp.Header.Get("Deprecation") != "true" {
+		t.Error("/api/workspaces/members is not marked deprecated")
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	h := Start(t)
+	token := h.Signup(t, "alice")
+
+	expectError := func(resp *http.Response, status int, code string) apierror.Error {
+		t.Helper()
+		Expect(t, resp, status)
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Content-Type = %q", resp.Request.URL.Path, ct)
+		}
+		var env struct{ Error apierror.Error }
+		Decode(t, resp, &env)
+		if env.Error.Code != code || env.Error.Message == "" {
+			t.Errorf("%s: error = %+v, want code %s", resp.Request.URL.Path, env.Error, code)
+		}
+		if id := resp.Header.Get("X-Request-ID"); id == "" || env.Error.RequestID != id {
+			t.Errorf("%s: request_id = %q, X-Request-ID = %q", resp.Request.URL.Path, env.Error.RequestID, id)
+		}
+		return env.Error
+	}
+
+	expectError(h.Do(t, http.MethodGet, "/api/v1/user", "", nil), http.StatusUnauthorized, apierror.CodeUnauthorized)
+	expectError(h.Do(t, http.MethodGet, "/api/v1/nothing-here", token, nil), http.StatusNotFound, apierror.CodeNotFound)
+
+	e := expectError(h.Do(t, http.MethodGet, "/api/v1/login", "", nil), http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed)
+	if allowed, _ := e.Details["allowed"].([]interface{}); len(allowed) != 1 || allowed[0] != "POST" {
+		t.Errorf("allowed = %v, want [POST]", e.Details["allowed"])
+	}
+
+	// The worker's own error body is not passed on
+	h.Worker.Fail("process", http.StatusForbidden)
+	e = expectError(h.Upload(t, token, "notes.txt", notes, nil), http.StatusForbidden, apierror.CodeQuotaExceeded)
+	if strings.Contains(e.Message, "injected") {
+		t.Errorf("worker error leaked: %q", e.Message)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	h := Start(t)
+	h.Register(t, "alice", Password)
+
+	resp := h.Do(t, http.MethodPost, "/api/v1/login", "", map[string]string{"username": "alice", "password": Password})
+	Expect(t, resp, http.StatusOK)
+	var login auth.LoginResponse
+	Decode(t, resp, &login)
+	var session *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == auth.TokenCookie {
+			session = c
+		}
+	}
+	if session == nil || login.CSRFToken == "" {
+		t.Fatalf("login set no session cookie or CSRF token: %+v", login)
+	}
+
+	// createWorkspace authenticates with the session cookie, as a browser
+	// would, and sends csrfToken unless it is empty
+	createWorkspace := func(name, csrfToken string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, h.URL+"/api/v1/workspaces", strings.NewReader(`{"name":"`+name+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(session)
+		if csrfToken != "" {
+			req.Header.Set(auth.CSRFHeader, csrfToken)
+		}
+		resp, err := h.HTTPClient().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	expectCSRFError := func(resp *http.Response) {
+		t.Helper()
+		Expect(t, resp, http.StatusForbidden)
+		var env struct{ Error apierror.Error }
+		Decode(t, resp, &env)
+		if env.Error.Code != apierror.CodeInvalidCSRFToken {
+			t.Errorf("error = %+v, want code %s", env.Error, apierror.CodeInvalidCSRFToken)
+		}
+	}
+
+	expectCSRFError(createWorkspace("garden", ""))
+	expectCSRFError(createWorkspace("garden", auth.CSRFToken("some-other-secret", session.Value)))
+	Expect(t, createWorkspace("garden", login.CSRFToken), http.StatusCreated)
+
+	// Browsers never attach bearer tokens, so those requests need no CSRF token
+	Expect(t, h.Do(t, http.MethodPost, "/api/v1/workspaces", login.Token, map[string]string{"name": "shed"}), http.StatusCreated)
+}
+
+func TestMalformedLoginsAreRateLimited(t *testing.T) {
+	h := Start(t, func(c *config.Config) {
+		c.RateLimit.Budgets[middleware.ClassLogin] = config.Budget{Rate: 0.001, Burst: 2}
+	})
+	for i, want := range []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusTooManyRequests} {
+		resp := h.Do(t, http.MethodPost, "/api/v1/login", "", map[string]interface{}{"username": 42})
+		if resp.StatusCode != want {
+			t.Fatalf("login %d: status %d, want %d", i+1, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	h := Start(t, func(c *config.Config) { c.AdminUsers = []string{"root"} })
+	token := h.Signup(t, "alice")
+	root := h.Signup(t, "root")
+
+	// The spec is served at both paths
+	for _, path := range []string{"/api/openapi.json", "/api/v1/openapi.json"} {
+		var spec openapi.Document
+		resp := h.Do(t, http.MethodGet, path, "", nil)
+		Expect(t, resp, http.StatusOK)
+		Decode(t, resp, &spec)
+		if spec.Paths["/api/v1/search"]["get"] == nil {
+			t.Errorf("%s does not describe GET /api/v1/search", path)
+		}
+	}
+
+	// Requests that don't match the spec are refused with every problem listed
+	invalid := func(resp *http.Response, problems ...string) {
+		t.Helper()
+		Expect(t, resp, http.StatusBadRequest)
+		var env struct{ Error apierror.Error }
+		Decode(t, resp, &env)
+		got, _ := env.Error.Details["errors"].([]interface{})
+		if len(got) != len(problems) {
+			t.Fatalf("%s: errors = %v, want %q", resp.Request.URL.Path, got, problems)
+		}
+		for i, p := range problems {
+			if got[i] != p {
+				t.Errorf("%s: errors[%d] = %v, want %q", resp.Request.URL.Path, i, got[i], p)
+			}
+		}
+	}
+	invalid(h.Do(t, http.MethodGet, "/api/v1/search?web=yes", token, nil),
+		"query parameter q is required", "query parameter web must be true or false")
+	invalid(h.Do(t, http.MethodGet, "/api/v1/admin/users?limit=1000", root, nil),
+		"query parameter limit must be at most 500")
+	invalid(h.Do(t, http.MethodPost, "/api/v1/workspaces", token, map[string]interface{}{"name": 42, "owner": "bob"}),
+		"body.name must be a string", "body.owner is not allowed")
+	invalid(h.Do(t, http.MethodPost, "/api/v1/workspaces/not-an-id/members", token, map[string]string{"username": "bob", "role": "viewer"}),
+		"path parameter workspace is malformed")
+	invalid(h.Do(t, http.MethodPut, "/api/v1/admin/loglevel", root, nil), "body is required")
+
+	// but only once the caller is authenticated and allowed in
+	Expect(t, h.Do(t, http.MethodPost, "/api/v1/workspaces", "", map[string]interface{}{"name": 42}), http.StatusUnauthorized)
+	Expect(t, h.Do(t, http.MethodPut, "/api/v1/admin/loglevel", token, nil), http.StatusForbidden)
+
+	// Empty lists are [] rather than null; the harness checks every body
+	for _, path := range []string{"/api/v1/workspaces", "/api/v1/documents", "/api/v1/status", "/api/v1/user"} {
+		Expect(t, h.Do(t, http.MethodGet, path, token, nil), http.StatusOK)
+	}
+	for _, path := range []string{"/api/v1/admin/users", "/api/v1/admin/usage?username=nobody", "/api/v1/admin/audit?action=none", "/api/v1/admin/loglevel"} {
+		Expect(t, h.Do(t, http.MethodGet, path, root, nil), http.StatusOK)
+	}
+	Expect(t, h.Do(t, http.MethodPost, "/api/v1/admin/users/alice/logout", root, nil), http.StatusOK)
+	Expect(t, h.Do(t, http.MethodGet, "/readyz", "", nil), http.StatusOK)
+}
+
+func TestPlanChangeEndsSessions(t *testing.T) {
+	h := Start(t, func(c *config.Config) { c.AdminUsers = []string{"root"} })
+	token := h.Signup(t, "alice")
+	root := h.Signup(t, "root")
+
+	// The plan in the token picks the rate limits, so it must not outlive a
+	// downgrade
+	Expect(t, h.Do(t, http.MethodPost, "/api/v1/admin/users/alice/quota", root, map[string]string{"plan": "free"}), http.StatusOK)
+	Expect(t, h.Do(t, http.MethodGet, "/api/v1/user", token, nil), http.StatusUnauthorized)
+	Expect(t, h.Do(t, http.MethodGet, "/api/v1/user", h.Login(t, "alice", Password), nil), http.StatusOK)
+}
+
+func TestUnknownPlan(t *testing.T) {
+	h := Start(t, func(c *config.Config) {
+		c.AdminUsers = []string{"root"}
+		c.RateLimit.Plans = map[string]float64{"team": 10}
+	})
+	h.Register(t, "alice", Password)
+	root := h.Signup(t, "root")
+	quota := "/api/v1/admin/users/alice/quota"
+
+	Expect(t, h.Do(t, http.MethodPost, quota, root, map[string]string{"plan": "platinum"}), http.StatusBadRequest)
+	Expect(t, h.Do(t, http.MethodPost, quota, root, map[string]string{"plan": "pro"}), http.StatusOK)
+	Expect(t, h.Do(t, http.MethodPost, quota, root, map[string]string{"plan": "team"}), http.StatusOK)
+}
+
+func TestDisableAndEnable(t *testing.T) {
+	h := Start(t, func(c *config.Config) { c.AdminUsers = []string{"root"} })
+	token := h.Signup(t, "alice")
+	root := h.Signup(t, "root")
+	login := func() *http.Response {
+		return h.Do(t, http.MethodPost, "/api/v1/login", "", map[string]string{"username": "alice", "password": Password})
+	}
+
+	// No body needed: the route says what happens
+	Expect(t, h.Do(t, http.MethodPost, "/api/v1/admin/users/alice/disable", root, nil), http.StatusOK)
+	Expect(t, h.Do(t, http.MethodGet, "/api/v1/user", token, nil), http.StatusUnauthorized)
+	Expect(t, login(), http.StatusForbidden)
+
+	Expect(t, h.Do(t, http.MethodPost, "/api/v1/admin/users/alice/enable", root, nil), http.StatusOK)
+	Expect(t, login(), http.StatusOK)
+
+	Expect(t, h.Do(t, http.MethodPost, "/api/v1/admin/users/root/disable", root, nil), http.StatusBadRequest)
+
+	// The deprecated route still takes the state from the body
+	resp := h.Do(t, http.MethodPost, "/api/admin/users/disable", root, map[string]interface{}{"username": "alice", "disabled": true})
+	Expect(t, resp, http.StatusOK)
+	Expect(t, login(), http.StatusForbidden)
+}
+
+func TestAuditLog(t *testing.T) {
+	h := Start(t, func(c *config.Config) { c.AdminUsers = []string{"root"} })
+	root := h.Signup(t, "root")
+	token := h.Signup(t, "alice")
+	// Filters take whole seconds
+	since := time.Now().UTC().Truncate(time.Second)
+
+	Expect(t, h.Do(t, http.MethodPost, "/api/v1/login", "", map[string]string{"username": "alice", "password": "wrong"}), http.StatusUnauthorized)
+	Expect(t, h.Upload(t, token, "notes.txt", notes, nil), http.StatusOK)
+
+	query := func(params url.Values) []audit.Event {
+		t.Helper()
+		resp := h.Do(t, http.MethodGet, "/api/v1/admin/audit?"+params.Encode(), root, nil)
+		Expect(t, resp, http.StatusOK)
+		var events []audit.Event
+		Decode(t, resp, &events)
+		return events
+	}
+
+	events := query(url.Values{
+		"actor": {"alice"}, "action": {"auth.login"}, "outcome": {audit.OutcomeFailure},
+		"ip": {"127.0.0.1"}, "since": {since.Format(time.RFC3339)},
+	})
+	if len(events) != 1 {
+		t.Fatalf("failed logins = %+v, want one", events)
+	}
+	if e := events[0]; e.Target != "alice" || e.UserAgent == "" || e.Details["reason"] == nil {
+		t.Errorf("failed login = %+v, want alice as target, the user agent and a reason", e)
+	}
+
+	events = query(url.Values{"actor": {"alice"}, "action": {"document.upload"}})
+	if len(events) != 1 || events[0].Target != "notes.txt" || events[0].Outcome != audit.OutcomeSuccess || events[0].Details["document_id"] == nil {
+		t.Errorf("uploads = %+v, want notes.txt with its document ID", events)
+	}
+
+	if events := query(url.Values{"actor": {"alice"}, "outcome": {audit.OutcomeFailure}, "until": {since.Add(-time.Second).Format(time.RFC3339)}}); len(events) != 0 {
+		t.Errorf("failures before the failed login = %+v", events)
+	}
+	if events := query(url.Values{"ip": {"192.0.2.1"}}); len(events) != 0 {
+		t.Errorf("events from another IP = %+v", events)
+	}
+
+	// The export has one event per line, oldest first
+	resp := h.Do(t, http.MethodGet, "/api/v1/admin/audit/export?actor=alice", root, nil)
+	Expect(t, resp, http.StatusOK)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("export Content-Type = %q", ct)
+	}
+	var actions []string
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		var e audit.Event
+		if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+			t.Fatalf("export line %q: %v", lines.Text(), err)
+		}
+		if e.Actor != "alice" {
+			t.Errorf("exported %+v, want only alice's events", e)
+		}
+		actions = append(actions, e.Action+"/"+e.Outcome)
+	}
+	want := []string{"auth.register/success", "auth.login/success", "auth.login/failure", "document.upload/success"}
+	if strings.Join(actions, " ") != strings.Join(want, " ") {
+		t.Errorf("exported %v, want %v", actions, want)
+	}
+}
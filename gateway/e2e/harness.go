@@ -0,0 +1,267 @@
+// Package e2e runs the whole gateway in-process for tests: the handler chain
+// from package server on top of memstore, with a fake Python worker and
+// recorded search provider responses, so no external service is needed.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"nexus-gateway/config"
+	"nexus-gateway/health"
+	"nexus-gateway/middleware"
+	"nexus-gateway/openapi"
+	"nexus-gateway/server"
+	"nexus-gateway/store/memstore"
+)
+
+// Password is what Signup registers users with
+const Password = "correct horse battery staple"
+
+// Harness is a running gateway and its fakes
+type Harness struct {
+	// URL is the gateway's base URL
+	URL       string
+	Config    *config.Config
+	Store     *memstore.Store
+	Worker    *Worker
+	Providers *Providers
+	// Spec is the OpenAPI document the gateway serves. Every response the
+	// harness receives is checked against it.
+	Spec *openapi.Document
+
+	client *http.Client
+}
+
+// Option changes the configuration before the gateway starts
+type Option func(*config.Config)
+
+// Start boots a gateway that is shut down when the test ends. Rate limits
+// are raised well beyond what a test needs unless an Option sets them.
+func Start(t testing.TB, opts ...Option) *Harness {
+	t.Helper()
+
+	db := memstore.New()
+	worker := newWorker(db)
+	t.Cleanup(worker.Close)
+	providers := newProviders()
+	t.Cleanup(providers.Close)
+
+	cfg := config.Default()
+	cfg.MongoURI = "memory://"
+	cfg.JWTSecret = "e2e-test-secret-that-is-long-enough"
+	cfg.SerpAPIKey = "test-serpapi-key"
+	cfg.WorkerURL = worker.URL
+	cfg.Providers = config.Providers{
+		SerpAPIURL:    providers.SerpAPIURL(),
+		DuckDuckGoURL: providers.DuckDuckGoURL(),
+		WikipediaURL:  providers.WikipediaURL(),
+	}
+	cfg.RateLimit.Budgets = make(map[string]config.Budget)
+	for _, class := range []string{middleware.ClassDefault, middleware.ClassLogin, middleware.ClassSearch, middleware.ClassUpload, middleware.ClassSerp} {
+		cfg.RateLimit.Budgets[class] = config.Budget{Rate: 1000, Burst: 1000}
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	t.Cleanup(func() {
+		stopJobs()
+		jobs.Wait()
+	})
+
+	handler, err := server.New(server.Options{
+		Config:  cfg,
+		Store:   db,
+		Limiter: middleware.NewMemoryStore(time.Minute),
+		Checks:  []health.Check{health.Worker(cfg.WorkerURL)},
+		RunJob: func(job func(ctx context.Context)) {
+			jobs.Add(1)
+			go func() {
+				defer jobs.Done()
+				job(jobsCtx)
+			}()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/api/v1/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	spec := &openapi.Document{}
+	if err := json.NewDecoder(resp.Body).Decode(spec); err != nil {
+		t.Fatalf("decoding OpenAPI spec: %v", err)
+	}
+
+	return &Harness{
+		URL:       srv.URL,
+		Config:    cfg,
+		Store:     db,
+		Worker:    worker,
+		Providers: providers,
+		Spec:      spec,
+		client:    &http.Client{Transport: specCheck{t: t, spec: spec}},
+	}
+}
+
+// specCheck fails the test when a response doesn't match the spec
+type specCheck struct {
+	t    testing.TB
+	spec *openapi.Document
+}
+
+func (c specCheck) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	err = c.spec.CheckResponse(req.Method, req.URL.Path, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	if err != nil && err != openapi.ErrNoOperation {
+		c.t.Errorf("response does not match the OpenAPI spec: %v", err)
+	}
+	return resp, nil
+}
+
+// HTTPClient returns a client that checks every response against Spec,
+// for tests that build their own requests
+func (h *Harness) HTTPClient() *http.Client {
+	return h.client
+}
+
+// Do sends a request with an optional bearer token. A body that is not an
+// io.Reader is sent as JSON.
+func (h *Harness) Do(t testing.TB, method, path, token string, body interface{}) *http.Response {
+	t.Helper()
+
+	var r io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		r = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, h.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// Register creates an account and fails the test unless that worked
+func (h *Harness) Register(t testing.TB, username, password string) {
+	t.Helper()
+	resp := h.Do(t, http.MethodPost, "/api/v1/register", "", map[string]string{"username": username, "password": password})
+	Expect(t, resp, http.StatusCreated)
+}
+
+// Login returns a session token
+func (h *Harness) Login(t testing.TB, username, password string) string {
+	t.Helper()
+	resp := h.Do(t, http.MethodPost, "/api/v1/login", "", map[string]string{"username": username, "password": password})
+	Expect(t, resp, http.StatusOK)
+	var body struct {
+		Token string `json:"token"`
+	}
+	Decode(t, resp, &body)
+	return body.Token
+}
+
+// Signup registers username with Password and logs in
+func (h *Harness) Signup(t testing.TB, username string) string {
+	t.Helper()
+	h.Register(t, username, Password)
+	return h.Login(t, username, Password)
+}
+
+// Upload sends content as a file upload together with extra form fields
+// (such as "workspace")
+func (h *Harness) Upload(t testing.TB, token, filename, content string, fields map[string]string) *http.Response {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, content)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	mw.Close()
+
+	req, err := http.NewRequest(http.MethodPost, h.URL+"/api/v1/upload", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// Expect fails the test unless resp has the wanted status
+func Expect(t testing.TB, resp *http.Response, status int) {
+	t.Helper()
+	if resp.StatusCode != status {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("%s %s: status %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, status, body)
+	}
+}
+
+// Decode reads a JSON response body into v
+func Decode(t testing.TB, resp *http.Response, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: decoding response: %v", resp.Request.Method, resp.Request.URL.Path, err)
+	}
+}
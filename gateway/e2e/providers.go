@@ -0,0 +1,74 @@
+package e2e
+
+import (
+	"embed"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+)
+
+// Responses recorded from the real providers
+//
+//go:embed testdata/*.json
+var recorded embed.FS
+
+// Providers stands in for SerpApi, DuckDuckGo and Wikipedia. Every provider
+// answers any query with its recorded response.
+type Providers struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	queries map[string][]url.Values
+	down    map[string]bool
+}
+
+func newProviders() *Providers {
+	p := &Providers{queries: make(map[string][]url.Values), down: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /serpapi/search.json", p.serve("serpapi", "testdata/serpapi.json"))
+	mux.HandleFunc("GET /duckduckgo/", p.serve("duckduckgo", "testdata/duckduckgo.json"))
+	mux.HandleFunc("GET /wikipedia/w/api.php", p.serve("wikipedia", "testdata/wikipedia.json"))
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *Providers) SerpAPIURL() string    { return p.URL + "/serpapi/search.json" }
+func (p *Providers) DuckDuckGoURL() string { return p.URL + "/duckduckgo/" }
+func (p *Providers) WikipediaURL() string  { return p.URL + "/wikipedia/w/api.php" }
+
+// Queries returns the query strings provider ("serpapi", "duckduckgo" or
+// "wikipedia") was called with
+func (p *Providers) Queries(provider string) []url.Values {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]url.Values(nil), p.queries[provider]...)
+}
+
+// SetDown makes provider answer 503 until it is set up again
+func (p *Providers) SetDown(provider string, down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down[provider] = down
+}
+
+func (p *Providers) serve(provider, file string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.queries[provider] = append(p.queries[provider], r.URL.Query())
+		down := p.down[provider]
+		p.mu.Unlock()
+
+		if down {
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		body, err := recorded.ReadFile(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
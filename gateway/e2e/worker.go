@@ -0,0 +1,200 @@
+package e2e
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"nexus-gateway/store"
+	"nexus-gateway/store/memstore"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Dimensions of the fake embeddings
+const embeddingSize = 64
+
+// Embed is the fake worker's embedding: a bag of lower-cased words hashed
+// into a small vector, so texts sharing words come out similar
+func Embed(text string) []float32 {
+	v := make([]float32, embeddingSize)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		word = strings.Trim(word, ".,;:!?\"'()")
+		if word == "" {
+			continue
+		}
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		v[h.Sum32()%embeddingSize]++
+	}
+	return v
+}
+
+// Worker stands in for the Python worker. It parses uploads as plain text,
+// splits them into one chunk per paragraph and writes the chunks to the
+// same store the gateway reads from.
+type Worker struct {
+	*httptest.Server
+	store *memstore.Store
+
+	mu       sync.Mutex
+	failures map[string]int
+	requests map[string]int
+}
+
+func newWorker(s *memstore.Store) *Worker {
+	w := &Worker{store: s, failures: make(map[string]int), requests: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", w.health)
+	mux.HandleFunc("POST /embed", w.embed)
+	mux.HandleFunc("POST /process", w.process)
+	w.Server = httptest.NewServer(mux)
+	return w
+}
+
+// Fail makes every later call to endpoint ("health", "embed" or "process")
+// answer with status; a status of 0 restores normal behaviour
+func (w *Worker) Fail(endpoint string, status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failures[endpoint] = status
+}
+
+// Requests returns how many times endpoint was called
+func (w *Worker) Requests(endpoint string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.requests[endpoint]
+}
+
+// failing counts the call and writes the injected failure, if any
+func (w *Worker) failing(rw http.ResponseWriter, endpoint string) bool {
+	w.mu.Lock()
+	w.requests[endpoint]++
+	status := w.failures[endpoint]
+	w.mu.Unlock()
+
+	if status == 0 {
+		return false
+	}
+	writeJSON(rw, status, map[string]string{"error": "injected failure"})
+	return true
+}
+
+func (w *Worker) health(rw http.ResponseWriter, r *http.Request) {
+	if w.failing(rw, "health") {
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (w *Worker) embed(rw http.ResponseWriter, r *http.Request) {
+	if w.failing(rw, "embed") {
+		return
+	}
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Text == "" {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "No text provided"})
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"embedding": Embed(req.Text)})
+}
+
+func (w *Worker) process(rw http.ResponseWriter, r *http.Request) {
+	if w.failing(rw, "process") {
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "No file part"})
+		return
+	}
+	defer file.Close()
+	userID, err := primitive.ObjectIDFromHex(r.FormValue("user_id"))
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "User ID required"})
+		return
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "Parsing failed"})
+		return
+	}
+	size := int64(len(content))
+
+	ctx := r.Context()
+	user, err := w.user(r, userID)
+	if err != nil {
+		writeJSON(rw, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	user.Normalize()
+	if user.TotalStorageBytes+size > user.QuotaBytes {
+		writeJSON(rw, http.StatusForbidden, map[string]string{"error": "Storage quota exceeded"})
+		return
+	}
+
+	base := store.Chunk{
+		UserID:    userID,
+		Filename:  header.Filename,
+		SizeBytes: size,
+		CreatedAt: time.Now().UTC(),
+	}
+	if id, err := primitive.ObjectIDFromHex(r.FormValue("document_id")); err == nil {
+		base.DocumentID = &id
+	}
+	if id, err := primitive.ObjectIDFromHex(r.FormValue("workspace_id")); err == nil {
+		base.WorkspaceID = &id
+	}
+
+	var chunks []store.Chunk
+	for _, text := range strings.Split(string(content), "\n\n") {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		c := base
+		c.ChunkIndex = len(chunks)
+		c.Content = text
+		c.Embedding = Embed(text)
+		chunks = append(chunks, c)
+	}
+	if err := w.store.InsertChunks(ctx, chunks); err != nil {
+		writeJSON(rw, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// The real worker uses $inc
+	if _, err := w.store.UpdateUser(ctx, user.Username, store.UserUpdate{AddStorageBytes: size}); err != nil {
+		writeJSON(rw, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"status": "success", "chunks": len(chunks), "size": size})
+}
+
+// user finds a user by ID; the stores only look users up by name
+func (w *Worker) user(r *http.Request, id primitive.ObjectID) (*store.User, error) {
+	users, err := w.store.ListUsers(r.Context(), 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if u.ID == id {
+			return &u, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
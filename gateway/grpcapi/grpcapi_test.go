@@ -0,0 +1,304 @@
+package grpcapi_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/config"
+	"nexus-gateway/e2e"
+	"nexus-gateway/grpcapi"
+	"nexus-gateway/middleware"
+	nexusv1 "nexus-gateway/proto/nexus/v1"
+	"nexus-gateway/server"
+	"nexus-gateway/store"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const notes = `Gophers dig long burrows under meadows and gardens.
+
+Sourdough bread needs a starter, flour, water and salt.
+
+The quarterly budget review happens on the first Monday.`
+
+// start serves the gRPC API on the stores of an e2e gateway
+func start(t *testing.T, opts ...e2e.Option) (*e2e.Harness, nexusv1.NexusClient) {
+	t.Helper()
+	h := e2e.Start(t, opts...)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpcapi.New(grpcapi.Options{
+		Store:     h.Store,
+		JWTSecret: h.Config.JWTSecret,
+		Search:    server.SearchConfig(h.Config),
+		Limiter:   server.RateLimiter(h.Config, middleware.NewMemoryStore(time.Minute)),
+	})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return h, nexusv1.NewNexusClient(conn)
+}
+
+func expectCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("code = %v (%v), want %v", got, err, want)
+	}
+}
+
+func upload(t *testing.T, c nexusv1.NexusClient, ctx context.Context, filename, content string) *nexusv1.UploadResponse {
+	t.Helper()
+	stream, err := c.Upload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Send the file in small pieces to exercise the reassembly
+	first := true
+	for len(content) > 0 {
+		n := min(16, len(content))
+		chunk := &nexusv1.UploadChunk{Data: []byte(content[:n])}
+		if first {
+			chunk.Filename, first = filename, false
+		}
+		if err := stream.Send(chunk); err != nil {
+			t.Fatal(err)
+		}
+		content = content[n:]
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestUnauthenticated(t *testing.T) {
+	_, c := start(t)
+
+	_, err := c.Search(context.Background(), &nexusv1.SearchRequest{Query: "gophers", Web: true})
+	expectCode(t, err, codes.Unauthenticated)
+
+	ctx := grpcapi.WithToken(context.Background(), "not-a-token")
+	_, err = c.ListDocuments(ctx, &nexusv1.ListDocumentsRequest{})
+	expectCode(t, err, codes.Unauthenticated)
+
+	stream, err := c.SearchStream(ctx, &nexusv1.SearchRequest{Query: "gophers", Web: true})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	expectCode(t, err, codes.Unauthenticated)
+}
+
+func TestUploadSearchDelete(t *testing.T) {
+	h, c := start(t)
+	ctx := grpcapi.WithToken(context.Background(), h.Signup(t, "alice"))
+
+	uploaded := upload(t, c, ctx, "notes.txt", notes)
+	if uploaded.Chunks != 3 || uploaded.DocumentId == "" {
+		t.Fatalf("upload response = %+v", uploaded)
+	}
+
+	resp, err := c.Search(ctx, &nexusv1.SearchRequest{Query: "sourdough bread starter", Pkb: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) == 0 || !strings.Contains(resp.Results[0].Snippet, "Sourdough") {
+		t.Fatalf("results = %+v, want the sourdough chunk first", resp.Results)
+	}
+
+	list, err := c.ListDocuments(ctx, &nexusv1.ListDocumentsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Documents) != 1 || list.Documents[0].Id != uploaded.DocumentId {
+		t.Fatalf("documents = %+v", list.Documents)
+	}
+
+	if _, err := c.DeleteDocument(ctx, &nexusv1.DeleteDocumentRequest{Id: uploaded.DocumentId}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.DeleteDocument(ctx, &nexusv1.DeleteDocumentRequest{Id: uploaded.DocumentId})
+	expectCode(t, err, codes.NotFound)
+	_, err = c.DeleteDocument(ctx, &nexusv1.DeleteDocumentRequest{Id: "nope"})
+	expectCode(t, err, codes.InvalidArgument)
+
+	list, err = c.ListDocuments(ctx, &nexusv1.ListDocumentsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Documents) != 0 {
+		t.Errorf("documents after delete = %+v", list.Documents)
+	}
+}
+
+func TestSearchStream(t *testing.T) {
+	h, c := start(t)
+	ctx := grpcapi.WithToken(context.Background(), h.Signup(t, "alice"))
+	h.Providers.SetDown("duckduckgo", true)
+
+	stream, err := c.SearchStream(ctx, &nexusv1.SearchRequest{Query: "gophers", Web: true, Wiki: true, Ddg: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var providers []string
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		providers = append(providers, batch.Provider)
+		if batch.Provider == "ddg" {
+			if !batch.Failed || len(batch.Results) != 0 {
+				t.Errorf("ddg batch = %+v, want failed", batch)
+			}
+		} else if batch.Failed || len(batch.Results) == 0 {
+			t.Errorf("%s batch = %+v, want results", batch.Provider, batch)
+		}
+	}
+	sort.Strings(providers)
+	if strings.Join(providers, ",") != "ddg,serpapi,wikipedia" {
+		t.Errorf("providers = %v, want one batch each", providers)
+	}
+
+	_, err = c.Search(ctx, &nexusv1.SearchRequest{Query: " "})
+	expectCode(t, err, codes.InvalidArgument)
+}
+
+// errorInfo returns the ErrorInfo detail of err
+func errorInfo(err error) *errdetails.ErrorInfo {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	return &errdetails.ErrorInfo{}
+}
+
+func TestRateLimit(t *testing.T) {
+	h, c := start(t, func(cfg *config.Config) {
+		cfg.RateLimit.Budgets[middleware.ClassSerp] = config.Budget{Rate: 0.001, Burst: 1}
+		cfg.RateLimit.Budgets[middleware.ClassDefault] = config.Budget{Rate: 0.001, Burst: 1}
+	})
+	ctx := grpcapi.WithToken(context.Background(), h.Signup(t, "alice"))
+
+	// Web searches are charged to the serp budget, the rest to search
+	web := &nexusv1.SearchRequest{Query: "gophers", Web: true}
+	if _, err := c.Search(ctx, web); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Search(ctx, web)
+	expectCode(t, err, codes.ResourceExhausted)
+	if info := errorInfo(err); info.Reason != apierror.CodeRateLimited || info.Metadata["class"] != middleware.ClassSerp {
+		t.Errorf("ErrorInfo = %+v", info)
+	}
+	stream, err := c.SearchStream(ctx, web)
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	expectCode(t, err, codes.ResourceExhausted)
+	if _, err := c.Search(ctx, &nexusv1.SearchRequest{Query: "gophers", Wiki: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bad tokens use up the client's default budget
+	bad := grpcapi.WithToken(context.Background(), "not-a-token")
+	_, err = c.ListDocuments(bad, &nexusv1.ListDocumentsRequest{})
+	expectCode(t, err, codes.Unauthenticated)
+	_, err = c.ListDocuments(bad, &nexusv1.ListDocumentsRequest{})
+	expectCode(t, err, codes.ResourceExhausted)
+}
+
+func TestErrorInfo(t *testing.T) {
+	h, c := start(t)
+	ctx := grpcapi.WithToken(context.Background(), h.Signup(t, "alice"))
+	h.Worker.Fail("process", 403)
+
+	stream, err := c.Upload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&nexusv1.UploadChunk{Filename: "big.txt", Data: []byte(notes)}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.CloseAndRecv()
+	expectCode(t, err, codes.ResourceExhausted)
+
+	if reason := errorInfo(err).Reason; reason != apierror.CodeQuotaExceeded {
+		t.Errorf("ErrorInfo reason = %q, want %q", reason, apierror.CodeQuotaExceeded)
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	h, c := start(t)
+	ctx := grpcapi.WithToken(context.Background(), h.Signup(t, "alice"))
+	setQuota := func(n int64) {
+		t.Helper()
+		if _, err := h.Store.UpdateUser(context.Background(), "alice", store.UserUpdate{QuotaBytes: &n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send := func(chunks int, size int) error {
+		t.Helper()
+		stream, err := c.Upload(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, size)
+		for i := 0; i < chunks; i++ {
+			if err := stream.Send(&nexusv1.UploadChunk{Filename: "big.txt", Data: data}); err != nil {
+				break // the server gave up; CloseAndRecv has its status
+			}
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	// Going over the quota stops the upload before the worker sees it
+	setQuota(100)
+	err := send(4, 40)
+	expectCode(t, err, codes.ResourceExhausted)
+	if reason := errorInfo(err).Reason; reason != apierror.CodeQuotaExceeded {
+		t.Errorf("over quota: reason = %q", reason)
+	}
+	if n := h.Worker.Requests("process"); n != 0 {
+		t.Errorf("worker got %d uploads", n)
+	}
+
+	// So does going over the size limit, whatever the quota
+	setQuota(1 << 40)
+	err = send(middleware.MaxUploadBytes/(1<<20)+1, 1<<20)
+	expectCode(t, err, codes.ResourceExhausted)
+	if reason := errorInfo(err).Reason; reason != apierror.CodePayloadTooLarge {
+		t.Errorf("too large: reason = %q", reason)
+	}
+
+	// A full account is turned away before anything is read
+	upload(t, c, ctx, "notes.txt", notes)
+	setQuota(int64(len(notes)))
+	err = send(1, 1)
+	expectCode(t, err, codes.ResourceExhausted)
+	if reason := errorInfo(err).Reason; reason != apierror.CodeQuotaExceeded {
+		t.Errorf("full account: reason = %q", reason)
+	}
+}
@@ -0,0 +1,422 @@
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/audit"
+	"nexus-gateway/auth"
+	"nexus-gateway/documents"
+	"nexus-gateway/logging"
+	"nexus-gateway/middleware"
+	nexusv1 "nexus-gateway/proto/nexus/v1"
+	"nexus-gateway/search"
+	"nexus-gateway/store"
+	"nexus-gateway/workspace"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Options is what the service is built from; see server.Options
+type Options struct {
+	Store     store.Store
+	JWTSecret string
+	Search    search.Config
+	// Limiter applies the REST API's budgets, see server.RateLimiter
+	Limiter *middleware.RateLimiter
+}
+
+// Server implements the Nexus service with the same code as the HTTP
+// handlers
+type Server struct {
+	nexusv1.UnimplementedNexusServer
+
+	db        store.Store
+	jwtSecret string
+	cfg       search.Config
+	limiter   *middleware.RateLimiter
+}
+
+// New returns a gRPC server with the service registered, ready to Serve
+func New(o Options) *grpc.Server {
+	s := &Server{db: o.Store, jwtSecret: o.JWTSecret, cfg: o.Search, limiter: o.Limiter}
+	gs := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+	)
+	nexusv1.RegisterNexusServer(gs, s)
+	return gs
+}
+
+func (s *Server) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err == nil {
+		err = s.limit(ctx, req)
+	}
+	var resp any
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	logCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// authedStream carries the context with the caller into a stream handler,
+// and charges the call to the rate limits when the first message arrives
+type authedStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	limit   func(ctx context.Context, msg any) error
+	charged bool
+}
+
+func (s *authedStream) Context() context.Context { return s.ctx }
+
+func (s *authedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if !s.charged {
+		s.charged = true
+		return s.limit(s.ctx, m)
+	}
+	return nil
+}
+
+func (s *Server) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := s.authenticate(ss.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &authedStream{ServerStream: ss, ctx: ctx, limit: s.limit})
+	}
+	logCall(ctx, info.FullMethod, start, err)
+	return err
+}
+
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	slog.InfoContext(ctx, "grpc call",
+		"method", method,
+		"code", status.Code(err).String(),
+		"duration_ms", time.Since(start).Milliseconds())
+}
+
+// authenticate checks the bearer token in the metadata like middleware.Auth
+// does for HTTP and puts the username and claims in the context
+func (s *Server) authenticate(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := ""
+	for _, v := range md.Get("authorization") {
+		if strings.HasPrefix(v, "Bearer ") {
+			token = strings.TrimPrefix(v, "Bearer ")
+		}
+	}
+
+	reject := func(username, reason string) error {
+		who := caller(ctx)
+		// Failed attempts count against the client's IP, as over HTTP
+		if d := s.limiter.Allow(ctx, middleware.ClassDefault, "", "", who.IP); !d.Allowed {
+			return rateLimited(middleware.ClassDefault, d)
+		}
+		who.Actor, who.Action, who.Target, who.Outcome = username, "auth.token", method, audit.OutcomeDenied
+		who.Details = map[string]interface{}{"reason": reason}
+		audit.Record(ctx, s.db, who)
+		return status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	if token == "" {
+		return ctx, reject("", "missing token")
+	}
+	claims, err := auth.ParseToken(token, s.jwtSecret)
+	if err != nil {
+		return ctx, reject("", "invalid token")
+	}
+	if err := auth.ValidateSession(s.db)(ctx, claims); err != nil {
+		slog.WarnContext(ctx, "session rejected", "user", logging.Redact(claims.Username), "error", err)
+		return ctx, reject(claims.Username, err.Error())
+	}
+
+	ctx = context.WithValue(ctx, "user", claims.Username)
+	ctx = context.WithValue(ctx, "claims", claims)
+	return ctx, nil
+}
+
+// classOf picks the REST route class a call is charged to from its (first)
+// request message
+func classOf(msg any) string {
+	switch m := msg.(type) {
+	case *nexusv1.SearchRequest:
+		// Web searches call SerpApi, see middleware.SearchClass
+		if m.Web {
+			return middleware.ClassSerp
+		}
+		return middleware.ClassSearch
+	case *nexusv1.UploadChunk:
+		return middleware.ClassUpload
+	}
+	return middleware.ClassDefault
+}
+
+// limit charges an authenticated call to the budget of its class, sharing
+// the counters of the REST API
+func (s *Server) limit(ctx context.Context, msg any) error {
+	username, _ := ctx.Value("user").(string)
+	claims, _ := ctx.Value("claims").(*auth.Claims)
+	plan := ""
+	if claims != nil {
+		plan = claims.Plan
+	}
+	class := classOf(msg)
+	if d := s.limiter.Allow(ctx, class, username, plan, caller(ctx).IP); !d.Allowed {
+		return rateLimited(class, d)
+	}
+	return nil
+}
+
+// rateLimited is the status of a rejected call; RetryInfo takes the place
+// of Retry-After
+func rateLimited(class string, d middleware.Decision) error {
+	st, err := status.New(codes.ResourceExhausted, "Too many requests").WithDetails(
+		&errdetails.ErrorInfo{Reason: apierror.CodeRateLimited, Domain: "nexus", Metadata: map[string]string{"class": class}},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(max(d.RetryAfter, time.Second))},
+	)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, "Too many requests")
+	}
+	return st.Err()
+}
+
+// caller is the audit log's view of who made a call
+func caller(ctx context.Context) audit.Event {
+	e := audit.Event{}
+	e.Actor, _ = ctx.Value("user").(string)
+	if p, ok := peer.FromContext(ctx); ok {
+		e.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(e.IP); err == nil {
+			e.IP = host
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			e.UserAgent = ua[0]
+		}
+	}
+	return e
+}
+
+// codeOf maps the REST API's error codes to gRPC status codes
+var codeOf = map[string]codes.Code{
+	apierror.CodeInvalidRequest:     codes.InvalidArgument,
+	apierror.CodeUnauthorized:       codes.Unauthenticated,
+	apierror.CodeInvalidCredentials: codes.Unauthenticated,
+	apierror.CodeForbidden:          codes.PermissionDenied,
+	apierror.CodeAccountDisabled:    codes.PermissionDenied,
+	apierror.CodeNotFound:           codes.NotFound,
+	apierror.CodeAlreadyExists:      codes.AlreadyExists,
+	apierror.CodeGone:               codes.NotFound,
+	apierror.CodePayloadTooLarge:    codes.ResourceExhausted,
+	apierror.CodeQuotaExceeded:      codes.ResourceExhausted,
+	apierror.CodeRateLimited:        codes.ResourceExhausted,
+	apierror.CodeUpstream:           codes.Unavailable,
+	apierror.CodeUnavailable:        codes.Unavailable,
+	apierror.CodeInternal:           codes.Internal,
+}
+
+// statusError turns err into a gRPC status. The REST error code goes into an
+// ErrorInfo detail so clients can branch on it as they would on
+// error.code; anything else is logged and reported as internal.
+func statusError(ctx context.Context, err error, msg string) error {
+	var e *apierror.Error
+	if !errors.As(err, &e) {
+		slog.ErrorContext(ctx, msg, "error", err)
+		e = apierror.New(0, apierror.CodeInternal, msg)
+	}
+	code, ok := codeOf[e.Code]
+	if !ok {
+		code = codes.Unknown
+	}
+	st, detailErr := status.New(code, e.Message).WithDetails(&errdetails.ErrorInfo{Reason: e.Code, Domain: "nexus"})
+	if detailErr != nil {
+		return status.Error(code, e.Message)
+	}
+	return st.Err()
+}
+
+// user loads the caller's account
+func (s *Server) user(ctx context.Context) (*store.User, error) {
+	username, _ := ctx.Value("user").(string)
+	user, err := s.db.UserByName(ctx, username)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "User not found")
+	}
+	return user, nil
+}
+
+// scope checks a search request and returns the PKB scope of the caller
+func (s *Server) scope(ctx context.Context, req *nexusv1.SearchRequest) (search.Scope, error) {
+	if strings.TrimSpace(req.Query) == "" {
+		return search.Scope{}, status.Error(codes.InvalidArgument, "Query required")
+	}
+	username, _ := ctx.Value("user").(string)
+	scope, _, err := search.UserScope(ctx, s.db, username, req.Workspace, req.Pkb)
+	if err == workspace.ErrNotMember {
+		return scope, status.Error(codes.NotFound, "Workspace not found")
+	}
+	return scope, err
+}
+
+func (s *Server) Search(ctx context.Context, req *nexusv1.SearchRequest) (*nexusv1.SearchResponse, error) {
+	start := time.Now()
+	scope, err := s.scope(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	found, err := search.Orchestrator(ctx, s.db, s.cfg, scope, req.Query, req.Web, req.Wiki, req.Ddg, req.Pkb)
+	if err != nil {
+		return nil, statusError(ctx, err, "Search failed")
+	}
+	return &nexusv1.SearchResponse{Results: results(found), TimeTakenMs: time.Since(start).Milliseconds()}, nil
+}
+
+func (s *Server) SearchStream(req *nexusv1.SearchRequest, stream grpc.ServerStreamingServer[nexusv1.SearchBatch]) error {
+	ctx := stream.Context()
+	start := time.Now()
+	scope, err := s.scope(ctx, req)
+	if err != nil {
+		return err
+	}
+	for b := range search.Each(ctx, s.db, s.cfg, scope, req.Query, req.Web, req.Wiki, req.Ddg, req.Pkb) {
+		batch := &nexusv1.SearchBatch{Provider: b.Provider, TimeTakenMs: time.Since(start).Milliseconds()}
+		if b.Err != nil {
+			slog.WarnContext(ctx, "search provider failed", "provider", b.Provider, "error", b.Err)
+			batch.Failed = true
+		} else {
+			batch.Results = results(b.Results)
+		}
+		if err := stream.Send(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Upload collects the streamed file and processes it like POST
+// /api/v1/upload
+func (s *Server) Upload(stream grpc.ClientStreamingServer[nexusv1.UploadChunk, nexusv1.UploadResponse]) error {
+	ctx := stream.Context()
+	user, err := s.user(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The worker checks the quota too, but the file is held in memory until
+	// then, so neither it nor MaxUploadBytes may be exceeded while receiving
+	user.Normalize()
+	remaining := user.QuotaBytes - user.TotalStorageBytes
+	quotaExceeded := apierror.New(http.StatusForbidden, apierror.CodeQuotaExceeded, "Storage quota exceeded")
+	if remaining <= 0 {
+		return statusError(ctx, quotaExceeded, "")
+	}
+	var file bytes.Buffer
+	add := func(data []byte) error {
+		switch size := int64(file.Len() + len(data)); {
+		case size > middleware.MaxUploadBytes:
+			return statusError(ctx, apierror.New(http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, "Request entity too large"), "")
+		case size > remaining:
+			return statusError(ctx, quotaExceeded, "")
+		}
+		file.Write(data)
+		return nil
+	}
+
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "No file sent")
+	}
+	if err != nil {
+		return err
+	}
+	if first.Filename == "" {
+		return status.Error(codes.InvalidArgument, "The first message must name the file")
+	}
+	if err := add(first.Data); err != nil {
+		return err
+	}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := add(chunk.Data); err != nil {
+			return err
+		}
+	}
+
+	resp, err := search.Process(ctx, s.db, s.cfg, search.Upload{
+		UserID:      user.ID.Hex(),
+		Filename:    first.Filename,
+		File:        &file,
+		WorkspaceID: first.Workspace,
+	}, caller(ctx))
+	if err != nil {
+		return statusError(ctx, err, "Processing error")
+	}
+	return stream.SendAndClose(&nexusv1.UploadResponse{
+		DocumentId: resp.DocumentID,
+		Chunks:     int32(resp.Chunks),
+		Size:       resp.Size,
+		Status:     resp.Status,
+	})
+}
+
+func (s *Server) ListDocuments(ctx context.Context, req *nexusv1.ListDocumentsRequest) (*nexusv1.ListDocumentsResponse, error) {
+	user, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := documents.List(ctx, s.db, user.ID)
+	if err != nil {
+		return nil, statusError(ctx, err, "Failed to list documents")
+	}
+	resp := &nexusv1.ListDocumentsResponse{Documents: make([]*nexusv1.Document, 0, len(docs))}
+	for _, d := range docs {
+		resp.Documents = append(resp.Documents, document(d))
+	}
+	return resp, nil
+}
+
+func (s *Server) DeleteDocument(ctx context.Context, req *nexusv1.DeleteDocumentRequest) (*nexusv1.DeleteDocumentResponse, error) {
+	user, err := s.user(ctx)
+	if err != nil {
+		return nil, err
+	}
+	docID, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid document")
+	}
+	err = documents.Delete(ctx, s.db, user, docID, caller(ctx))
+	if err == store.ErrNotFound {
+		return nil, status.Error(codes.NotFound, "Document not found")
+	}
+	if err != nil {
+		return nil, statusError(ctx, err, "Failed to delete document")
+	}
+	return &nexusv1.DeleteDocumentResponse{}, nil
+}
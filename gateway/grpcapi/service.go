@@ -0,0 +1,61 @@
+// Package grpcapi serves the search and document API over gRPC for
+// backend services, next to the REST API and on the same stores.
+//
+// The service is nexus.v1.Nexus, defined in proto/nexus/v1/nexus.proto
+// with its generated Go code next to it. Calls authenticate with the token
+// from POST /api/v1/login in the "authorization: Bearer <token>" metadata
+// (see WithToken) and share the REST API's rate limits.
+package grpcapi
+
+//go:generate protoc -I ../proto --go_out=../proto --go_opt=paths=source_relative --go-grpc_out=../proto --go-grpc_opt=paths=source_relative nexus/v1/nexus.proto
+
+import (
+	"context"
+
+	"nexus-gateway/documents"
+	nexusv1 "nexus-gateway/proto/nexus/v1"
+	"nexus-gateway/search"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// WithToken returns a context whose calls authenticate with token
+func WithToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+func results(rs []search.SearchResult) []*nexusv1.SearchResult {
+	out := make([]*nexusv1.SearchResult, 0, len(rs))
+	for _, r := range rs {
+		out = append(out, &nexusv1.SearchResult{Source: r.Source, Title: r.Title, Snippet: r.Snippet, Url: r.URL})
+	}
+	return out
+}
+
+func document(l documents.Listing) *nexusv1.Document {
+	d := &nexusv1.Document{
+		Id:        l.ID.Hex(),
+		Filename:  l.Filename,
+		SizeBytes: l.SizeBytes,
+		Chunks:    int32(l.Chunks),
+		CreatedAt: timestamppb.New(l.CreatedAt),
+		Shares:    make([]*nexusv1.Share, 0, len(l.Shares)),
+	}
+	if l.WorkspaceID != nil {
+		d.WorkspaceId = l.WorkspaceID.Hex()
+	}
+	for _, sh := range l.Shares {
+		s := &nexusv1.Share{
+			Id:        sh.ID.Hex(),
+			CreatedAt: timestamppb.New(sh.CreatedAt),
+			MaxUses:   int32(sh.MaxUses),
+			Uses:      int32(sh.Uses),
+		}
+		if sh.ExpiresAt != nil {
+			s.ExpiresAt = timestamppb.New(*sh.ExpiresAt)
+		}
+		d.Shares = append(d.Shares, s)
+	}
+	return d
+}
@@ -0,0 +1,194 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"nexus-gateway/apierror"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Check is one dependency the gateway needs to serve traffic
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Status is the result of running a Check
+type Status struct {
+	Name      string `json:"name"`
+	Up        bool   `json:"up"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Run runs checks in parallel, giving each at most timeout
+func Run(ctx context.Context, checks []Check, timeout time.Duration) []Status {
+	statuses := make([]Status, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.Check(ctx)
+			statuses[i] = Status{
+				Name:      c.Name,
+				Up:        err == nil,
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				statuses[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+func allUp(statuses []Status) bool {
+	for _, s := range statuses {
+		if !s.Up {
+			return false
+		}
+	}
+	return true
+}
+
+// Mongo pings the primary
+func Mongo(client *mongo.Client) Check {
+	return Check{Name: "mongodb", Check: func(ctx context.Context) error {
+		return client.Ping(ctx, nil)
+	}}
+}
+
+// Worker calls the Python worker's /health endpoint
+func Worker(baseURL string) Check {
+	httpClient := &http.Client{}
+	return Check{Name: "worker", Check: func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/health", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("worker health returned %s", resp.Status)
+		}
+		return nil
+	}}
+}
+
+// VectorIndex checks that the Atlas Search index name exists on the docs
+// collection and is queryable
+func VectorIndex(client *mongo.Client, name string) Check {
+	return Check{Name: "vector_index", Check: func(ctx context.Context) error {
+		collection := client.Database("nexus_search").Collection("docs")
+		cursor, err := collection.SearchIndexes().List(ctx, options.SearchIndexes().SetName(name))
+		if err != nil {
+			return err
+		}
+		var indexes []bson.M
+		if err := cursor.All(ctx, &indexes); err != nil {
+			return err
+		}
+		if len(indexes) == 0 {
+			return errors.New("index " + name + " not found")
+		}
+		if queryable, ok := indexes[0]["queryable"].(bool); ok && !queryable {
+			return errors.New("index " + name + " is not queryable yet")
+		}
+		return nil
+	}}
+}
+
+// LivenessHandler reports that the process is up (GET /healthz). It does not
+// look at dependencies so a database outage doesn't get the gateway restarted.
+func LivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}
+}
+
+// ReadinessResponse is the body of ReadinessHandler. Status is "ok" or
+// "unavailable".
+type ReadinessResponse struct {
+	Status string   `json:"status"`
+	Failed []string `json:"failed,omitempty"`
+}
+
+// ReadinessHandler returns 200 when every check passes and 503 otherwise
+// (GET /readyz). The body only names the failing dependencies since the
+// endpoint is public.
+func ReadinessHandler(checks []Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses := Run(r.Context(), checks, 3*time.Second)
+
+		resp := ReadinessResponse{Status: "ok"}
+		code := http.StatusOK
+		for _, s := range statuses {
+			if !s.Up {
+				resp.Failed = append(resp.Failed, s.Name)
+			}
+		}
+		if len(resp.Failed) > 0 {
+			resp.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// StatusResponse is the body of StatusHandler. Status is "ok" or
+// "degraded".
+type StatusResponse struct {
+	Status       string   `json:"status"`
+	Dependencies []Status `json:"dependencies"`
+}
+
+// StatusHandler lists the state and latency of every dependency
+// (GET /api/v1/status). Error details are only shown to admins.
+func StatusHandler(checks []Check, isAdmin func(r *http.Request) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+
+		statuses := Run(r.Context(), checks, 5*time.Second)
+		if !isAdmin(r) {
+			for i := range statuses {
+				statuses[i].Error = ""
+			}
+		}
+
+		status := "ok"
+		if !allUp(statuses) {
+			status = "degraded"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(StatusResponse{Status: status, Dependencies: statuses})
+	}
+}
@@ -0,0 +1,131 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"nexus-gateway/apierror"
+)
+
+// RequestIDHeader carries the request ID from clients to the gateway and
+// from the gateway to the worker and search providers
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// level is shared by the installed handler so it can change at runtime
+var level = new(slog.LevelVar)
+
+// Setup installs a JSON slog logger as the process default (which also
+// redirects the standard log package) at the level named by levelName:
+// debug, info, warn or error.
+func Setup(levelName string) {
+	if err := SetLevel(levelName); err != nil {
+		level.Set(slog.LevelInfo)
+	}
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(contextHandler{handler}))
+}
+
+// SetLevel changes the log level of the default logger
+func SetLevel(name string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// Level returns the current log level
+func Level() slog.Level {
+	return level.Level()
+}
+
+// DebugEnabled reports whether debug logging has been switched on, which
+// is also what allows queries and user identifiers into the logs
+func DebugEnabled() bool {
+	return level.Level() <= slog.LevelDebug
+}
+
+// Redact hides s (a search query, username, ...) unless debug logging is on
+func Redact(s string) string {
+	if DebugEnabled() || s == "" {
+		return s
+	}
+	return "[redacted]"
+}
+
+// WithRequestID stores the request ID in ctx
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Propagate copies the request ID of req's context onto the outbound request
+func Propagate(req *http.Request) {
+	if id := RequestID(req.Context()); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+}
+
+// contextHandler adds the request ID of the logging context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// LevelSetting is the body of LevelHandler's requests and responses
+type LevelSetting struct {
+	Level string `json:"level"`
+}
+
+// LevelHandler lets admins read (GET) and change (PUT {"level": "debug"})
+// the log level without a restart
+func LevelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var req LevelSetting
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				apierror.BadRequest(w, r, "Invalid request")
+				return
+			}
+			if err := SetLevel(req.Level); err != nil {
+				apierror.BadRequest(w, r, "Unknown level")
+				return
+			}
+			slog.InfoContext(r.Context(), "log level changed", "level", Level().String())
+		default:
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LevelSetting{Level: strings.ToLower(Level().String())})
+	}
+}
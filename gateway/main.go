@@ -2,16 +2,23 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
-	"nexus-gateway/auth"
+	"nexus-gateway/config"
+	"nexus-gateway/grpcapi"
+	"nexus-gateway/health"
+	"nexus-gateway/logging"
 	"nexus-gateway/middleware"
-	"nexus-gateway/search"
+	"nexus-gateway/server"
+	"nexus-gateway/store/mongostore"
+	"nexus-gateway/tracing"
 
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -20,134 +27,201 @@ import (
 
 func main() {
 	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using system environment variables")
+	envErr := godotenv.Load()
+
+	// "gateway migrate [status]" manages the database instead of serving
+	args, command := os.Args[1:], ""
+	if len(args) > 0 && args[0] == "migrate" {
+		args, command = args[1:], "migrate"
+		if len(args) > 0 && args[0] == "status" {
+			args, command = args[1:], "status"
+		}
 	}
 
-	mongoURI := os.Getenv("MONGODB_URI")
-	if mongoURI == "" {
-		log.Fatal("MONGODB_URI environment variable is required")
+	cfg, err := config.Load(args, os.Getenv)
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(2)
+	}
+
+	// JSON logs on stdout; LOG_LEVEL=debug also logs queries and usernames
+	logging.Setup(cfg.LogLevel)
+	if envErr != nil {
+		slog.Info("No .env file found, using system environment variables")
 	}
 
 	// Connect to MongoDB
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURI))
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+		slog.Error("Failed to connect to MongoDB", "error", err)
+		os.Exit(1)
 	}
-	defer func() {
-		if err = client.Disconnect(context.Background()); err != nil {
-			log.Printf("Failed to disconnect MongoDB: %v", err)
-		}
-	}()
-
-	log.Println("Connected to MongoDB")
-
-	// Setup Router
-	mux := http.NewServeMux()
+	slog.Info("Connected to MongoDB")
+	db := mongostore.New(client.Database("nexus_search"))
 
-	// Public Routes
-	mux.HandleFunc("/api/login", auth.LoginHandler(client))
-	mux.HandleFunc("/api/register", auth.RegisterHandler(client))
-
-	// Search Handler
-	searchHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query().Get("q")
-		if query == "" {
-			http.Error(w, "Query required", http.StatusBadRequest)
-			return
-		}
-
-		// Toggles
-		web := r.URL.Query().Get("web") == "true"
-		wiki := r.URL.Query().Get("wiki") == "true"
-		ddg := r.URL.Query().Get("ddg") == "true"
-		pkb := r.URL.Query().Get("pkb") == "true"
-
-		// Get User ID from Context (set by Auth middleware)
-		userID, ok := r.Context().Value("user").(string)
-		if !ok {
-			// Should not happen if Auth middleware is there, but handle safety
-			userID = ""
+	if command != "" {
+		code := runMigrate(db, command == "status")
+		client.Disconnect(context.Background())
+		os.Exit(code)
+	}
+	if cfg.MigrateOnStart {
+		migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), time.Minute)
+		_, err := db.Migrate(migrateCtx)
+		cancelMigrate()
+		if err != nil {
+			slog.Error("Failed to migrate database", "error", err)
+			os.Exit(1)
 		}
+	}
 
-		// We need the ObjectId for the PKB search, but 'user' in context is username!
-		// Wait, Auth middleware sets "user" to username string.
-		// But pkb.go expects userID hex string to convert to ObjectId.
-		// We need to resolve username -> ObjectId here or inside Orchestrator.
-		// Orchestrator takes "userID string".
-		// pkb.go converts it to ObjectID.
-		// So we need to look up the ID from the username.
-		// We have search.getUserID (helper in upload.go) but it's private.
-		// We should probably export it or duplicate logic?
-		// Let's export 'GetUserID' in upload.go if possible or just make it public.
-		// Or better yet, we can do the lookup in Orchestrator? No, Orchestrator should correspond to IDs.
-
-		// Actually, let's fix Auth middleware to put the ID in context?
-		// But Auth middleware only has username from JWT claims.
-		// JWT claims *should* have ID.
-		// If JWT only has username, we MUST look up ID.
-		// Let's assume for now we look it up.
-		// Since I can't easily export the function from `upload.go` without editing it again (I can),
-		// I'll edit `upload.go` to export `GetUserID`.
-
-		start := time.Now()
-
-		// Resolve ID
-		realID := ""
-		if userID != "" {
-			id, err := search.GetUserID(client, userID) // access exported function
-			if err == nil {
-				realID = id
-			} else {
-				fmt.Printf("Error resolving UserID for username %s: %v\n", userID, err)
-			}
-		} else {
-			fmt.Println("UserID in context is empty")
-		}
+	// OpenTelemetry: OTEL_TRACES_EXPORTER=otlp (see OTEL_EXPORTER_OTLP_ENDPOINT) or stdout
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracesExporter)
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
 
-		fmt.Printf("Search Params - Query: %s, Web: %v, Wiki: %v, DDG: %v, PKB: %v, UserID: %s\n", query, web, wiki, ddg, pkb, realID)
+	// Background jobs stop when jobsCtx is cancelled at shutdown
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	runJob := func(job func(ctx context.Context)) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job(jobsCtx)
+		}()
+	}
 
-		results, err := search.Orchestrator(r.Context(), client, realID, query, web, wiki, ddg, pkb)
+	// Budgets are per route class, keyed on the user when logged in. Use the
+	// MongoDB store when running more than one replica.
+	var limiterStore middleware.LimiterStore
+	if cfg.RateLimit.Store == "mongo" {
+		// Not the connect ctx, which may have run out during the migrations;
+		// NewMongoStore has its own timeout
+		store, err := middleware.NewMongoStore(context.Background(), client.Database("nexus_search"))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			slog.Error("Failed to set up rate limit store", "error", err)
+			os.Exit(1)
 		}
+		limiterStore = store
+	} else {
+		store := middleware.NewMemoryStore(10 * time.Minute)
+		runJob(func(ctx context.Context) { store.RunEviction(ctx, time.Minute) })
+		limiterStore = store
+	}
 
-		resp := search.SearchResponse{
-			Results:     results,
-			TimeTakenMs: time.Since(start).Milliseconds(),
-		}
+	// Dependencies checked by /readyz and /api/v1/status
+	checks := []health.Check{
+		health.Mongo(client),
+		health.Worker(cfg.WorkerURL),
+		health.VectorIndex(client, mongostore.VectorIndexName),
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
+	handler, err := server.New(server.Options{
+		Config:  cfg,
+		Store:   db,
+		Limiter: limiterStore,
+		Checks:  checks,
+		RunJob:  runJob,
 	})
+	if err != nil {
+		slog.Error("Failed to set up routes", "error", err)
+		os.Exit(1)
+	}
 
-	finalMux := http.NewServeMux()
-	finalMux.HandleFunc("/api/login", auth.LoginHandler(client))
-	finalMux.HandleFunc("/api/register", auth.RegisterHandler(client))
+	// Uploads wait up to 120s on the worker, so writes get more room than that
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       2 * time.Minute,
+		WriteTimeout:      3 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
 
-	// User Profile
-	finalMux.Handle("/api/user", middleware.Auth(auth.GetProfileHandler(client), os.Getenv("JWT_SECRET")))
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	serveErr := make(chan error, 2)
+	go func() {
+		slog.Info("Gateway running", "port", cfg.Port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	// The gRPC API is for backend services and only runs when GRPC_PORT is set
+	grpcSrv := grpcapi.New(grpcapi.Options{
+		Store:     db,
+		JWTSecret: cfg.JWTSecret,
+		Search:    server.SearchConfig(cfg),
+		Limiter:   server.RateLimiter(cfg, limiterStore),
+	})
+	if cfg.GRPCPort != "" {
+		lis, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+		if err != nil {
+			slog.Error("Failed to listen for gRPC", "error", err)
+			os.Exit(1)
+		}
+		go func() {
+			slog.Info("gRPC API running", "port", cfg.GRPCPort)
+			serveErr <- grpcSrv.Serve(lis)
+		}()
+	}
 
-	// Search and Upload are protected
-	finalMux.Handle("/api/search", middleware.Auth(searchHandler, os.Getenv("JWT_SECRET")))
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		slog.Error("Server failed", "error", err)
+		exitCode = 1
+	case <-signalCtx.Done():
+		slog.Info("Shutting down", "timeout", cfg.ShutdownTimeout.String())
+	}
+	// A second signal kills the process straight away
+	stopSignals()
 
-	// Upload with content-length check
-	finalMux.Handle("/api/upload", middleware.Auth(
-		middleware.StorageCheck(search.UploadProxyHandler(client)), os.Getenv("JWT_SECRET")))
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
 
-	// Global Middleware (CORS, RateLimit, Logging)
-	globalHandler := middleware.Logging(middleware.CORS(middleware.RateLimit(finalMux)))
+	// Stop accepting connections and wait for in-flight requests
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to drain requests", "error", err)
+		exitCode = 1
+	}
+	grpcDone := make(chan struct{})
+	go func() {
+		grpcSrv.GracefulStop()
+		close(grpcDone)
+	}()
+	select {
+	case <-grpcDone:
+	case <-shutdownCtx.Done():
+		slog.Error("Failed to drain gRPC calls")
+		grpcSrv.Stop()
+		exitCode = 1
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Let a running purge finish, then stop the jobs
+	stopJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		slog.Error("Background jobs did not stop in time")
+		exitCode = 1
 	}
 
-	log.Printf("Gateway running on port %s", port)
-	if err := http.ListenAndServe(":"+port, globalHandler); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	if err := client.Disconnect(shutdownCtx); err != nil {
+		slog.Error("Failed to disconnect MongoDB", "error", err)
+		exitCode = 1
 	}
+	slog.Info("Gateway stopped")
+	os.Exit(exitCode)
 }
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus-gateway/apierror"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "nexus"
+
+var (
+	// HTTPRequests counts requests by route pattern, method and status code
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled by the gateway.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPDuration is the request latency by route pattern and method
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests handled by the gateway.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// ProviderCalls counts Orchestrator calls to each search provider
+	// (serpapi, ddg, wikipedia, pkb)
+	ProviderCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "search_provider_calls_total",
+		Help:      "Calls made by the search orchestrator to each provider.",
+	}, []string{"provider"})
+
+	// ProviderErrors counts failed provider calls
+	ProviderErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "search_provider_errors_total",
+		Help:      "Failed calls from the search orchestrator to each provider.",
+	}, []string{"provider"})
+
+	// ProviderDuration is the latency of provider calls, failed ones included
+	ProviderDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "search_provider_duration_seconds",
+		Help:      "Latency of calls from the search orchestrator to each provider.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider"})
+
+	// WorkerDuration is the latency of calls to the Python worker by
+	// endpoint (embed, process) and outcome (ok, error)
+	WorkerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "worker_request_duration_seconds",
+		Help:      "Latency of calls to the Python worker.",
+		// Uploads are parsed and embedded synchronously, so allow for long calls
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"endpoint", "outcome"})
+
+	// RateLimitRejections counts requests rejected with 429 by route class
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ratelimit_rejections_total",
+		Help:      "Requests rejected by the rate limiter.",
+	}, []string{"class"})
+
+	// UploadBytes counts the bytes of successfully processed uploads
+	UploadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Bytes of documents successfully uploaded.",
+	})
+)
+
+// ObserveProvider records one provider call that started at start
+func ObserveProvider(provider string, start time.Time, err error) {
+	ProviderCalls.WithLabelValues(provider).Inc()
+	ProviderDuration.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ProviderErrors.WithLabelValues(provider).Inc()
+	}
+}
+
+// ObserveWorker records one worker call that started at start
+func ObserveWorker(endpoint string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	WorkerDuration.WithLabelValues(endpoint, outcome).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRequest records one handled HTTP request
+func ObserveRequest(route, method string, status int, elapsed time.Duration) {
+	HTTPRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	HTTPDuration.WithLabelValues(route, method).Observe(elapsed.Seconds())
+}
+
+// Handler serves the metrics in the Prometheus text format. If token is
+// set scrapers must send it as a bearer token.
+func Handler(token string) http.Handler {
+	h := promhttp.Handler()
+	if token == "" {
+		return h
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			apierror.Unauthorized(w, r, "Unauthorized")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
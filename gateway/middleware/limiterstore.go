@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/time/rate"
+)
+
+// Decision is the outcome of charging one request against a budget
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the full budget is available again
+	Reset time.Duration
+	// RetryAfter is how long to wait before the next request can succeed;
+	// only meaningful when Allowed is false
+	RetryAfter time.Duration
+}
+
+// LimiterStore keeps rate-limit counters. Take charges one request for key
+// against budget b.
+type LimiterStore interface {
+	Take(ctx context.Context, key string, b Budget) (Decision, error)
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// MemoryStore keeps token buckets in process memory. Limits are per replica;
+// use MongoStore when running more than one gateway.
+type MemoryStore struct {
+	idleTTL time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewMemoryStore returns a store whose buckets are dropped by Evict after
+// idleTTL without requests
+func NewMemoryStore(idleTTL time.Duration) *MemoryStore {
+	return &MemoryStore{
+		idleTTL: idleTTL,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, b Budget) (Decision, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	client, exists := s.buckets[key]
+	if !exists || client.limiter.Burst() != b.Burst || client.limiter.Limit() != rate.Limit(b.Rate) {
+		// New caller, or their plan changed since we last saw them
+		client = &bucket{limiter: rate.NewLimiter(rate.Limit(b.Rate), b.Burst)}
+		s.buckets[key] = client
+	}
+	client.lastSeen = now
+	s.mu.Unlock()
+
+	allowed := client.limiter.AllowN(now, 1)
+	tokens := client.limiter.TokensAt(now)
+
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     b.Burst,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     secondsToDuration((float64(b.Burst) - tokens) / b.Rate),
+	}
+	if !allowed {
+		d.RetryAfter = secondsToDuration((1 - tokens) / b.Rate)
+	}
+	return d, nil
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+// Evict drops buckets that have been idle for longer than the store's idle
+// TTL and returns how many were removed. An idle bucket is full again, so
+// dropping it does not change any caller's budget.
+func (s *MemoryStore) Evict(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for key, b := range s.buckets {
+		if now.Sub(b.lastSeen) > s.idleTTL {
+			delete(s.buckets, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Len returns the number of tracked buckets
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+// RunEviction calls Evict every interval until ctx is cancelled
+func (s *MemoryStore) RunEviction(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.Evict(now)
+		}
+	}
+}
+
+// MongoStore keeps fixed-window counters in MongoDB so every replica shares
+// the same limits. A budget of Burst requests per Rate becomes a window of
+// Burst/Rate seconds allowing Burst requests. Counter documents expire via
+// a TTL index on expires_at.
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoStore uses the ratelimits collection of db and makes sure its TTL
+// index exists
+func NewMongoStore(ctx context.Context, db *mongo.Database) (*MongoStore, error) {
+	collection := db.Collection("ratelimits")
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MongoStore{collection: collection}, nil
+}
+
+func (s *MongoStore) Take(ctx context.Context, key string, b Budget) (Decision, error) {
+	window := secondsToDuration(float64(b.Burst) / b.Rate)
+	if window < time.Second {
+		window = time.Second
+	}
+	now := time.Now()
+	windowStart := now.Truncate(window)
+	windowEnd := windowStart.Add(window)
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	id := key + "|" + windowStart.UTC().Format(time.RFC3339Nano)
+	update := bson.M{
+		"$inc": bson.M{"count": 1},
+		// Keep the counter around a little longer than its window so clock
+		// skew between replicas cannot resurrect a fresh one early
+		"$setOnInsert": bson.M{"expires_at": windowEnd.Add(time.Minute)},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Count int `bson:"count"`
+	}
+	if err := s.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&counter); err != nil {
+		return Decision{}, err
+	}
+
+	d := Decision{
+		Allowed:   counter.Count <= b.Burst,
+		Limit:     b.Burst,
+		Remaining: max(b.Burst-counter.Count, 0),
+		Reset:     windowEnd.Sub(now),
+	}
+	if !d.Allowed {
+		d.RetryAfter = d.Reset
+	}
+	return d, nil
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testLimiterStore checks the behaviour every LimiterStore must share
+func testLimiterStore(t *testing.T, store LimiterStore, key string) {
+	t.Helper()
+	ctx := context.Background()
+	b := Budget{Rate: 3.0 / 60, Burst: 3}
+
+	for i := 0; i < b.Burst; i++ {
+		d, err := store.Take(ctx, key, b)
+		if err != nil {
+			t.Fatalf("Take %d: %v", i, err)
+		}
+		if !d.Allowed {
+			t.Fatalf("Take %d: denied within burst", i)
+		}
+		if d.Limit != b.Burst {
+			t.Errorf("Take %d: Limit = %d, want %d", i, d.Limit, b.Burst)
+		}
+		if want := b.Burst - i - 1; d.Remaining != want {
+			t.Errorf("Take %d: Remaining = %d, want %d", i, d.Remaining, want)
+		}
+	}
+
+	d, err := store.Take(ctx, key, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Allowed {
+		t.Fatal("request beyond burst was allowed")
+	}
+	if d.RetryAfter <= 0 {
+		t.Errorf("RetryAfter = %v, want > 0", d.RetryAfter)
+	}
+
+	// Other keys have their own budget
+	d, err = store.Take(ctx, key+"-other", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Allowed {
+		t.Error("independent key was denied")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testLimiterStore(t, NewMemoryStore(time.Minute), "user:alice")
+}
+
+func TestMemoryStoreEvictsIdleBuckets(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	b := Budget{Rate: 1, Burst: 1}
+	store.Take(context.Background(), "ip:10.0.0.1", b)
+	store.Take(context.Background(), "ip:10.0.0.2", b)
+
+	if n := store.Evict(time.Now()); n != 0 {
+		t.Errorf("Evict removed %d fresh buckets", n)
+	}
+	if n := store.Evict(time.Now().Add(2 * time.Minute)); n != 2 {
+		t.Errorf("Evict removed %d buckets, want 2", n)
+	}
+	if store.Len() != 0 {
+		t.Errorf("Len = %d after eviction", store.Len())
+	}
+}
+
+// TestMongoStore runs against a local MongoDB, e.g.
+// MONGODB_TEST_URI=mongodb://localhost:27017 go test ./middleware
+func TestMongoStore(t *testing.T) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database("nexus_search_test")
+	defer db.Collection("ratelimits").Drop(context.Background())
+
+	store, err := NewMongoStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLimiterStore(t, store, fmt.Sprintf("user:test-%d", time.Now().UnixNano()))
+}
+
+func TestRateLimiterHeaders(t *testing.T) {
+	limiter := NewRateLimiter("secret", NewMemoryStore(time.Minute), nil, nil)
+	limiter.budgets[ClassLogin] = Budget{Rate: 1.0 / 60, Burst: 2}
+
+	handler := limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), Class(ClassLogin))
+
+	do := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/login", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Different source ports of the same client share one budget
+	if rec := do("192.0.2.1:1000"); rec.Code != http.StatusNoContent || rec.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("first request: code %d, remaining %q", rec.Code, rec.Header().Get("RateLimit-Remaining"))
+	}
+	do("192.0.2.1:1001")
+	rec := do("192.0.2.1:1002")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request: code %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+	if rec.Header().Get("RateLimit-Limit") != "2" {
+		t.Errorf("RateLimit-Limit = %q, want 2", rec.Header().Get("RateLimit-Limit"))
+	}
+
+	if rec := do("192.0.2.2:1000"); rec.Code != http.StatusNoContent {
+		t.Errorf("other client: code %d", rec.Code)
+	}
+}
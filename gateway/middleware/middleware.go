@@ -2,129 +2,325 @@ package middleware
 
 import (
 	"context"
-	"log"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"nexus-gateway/apierror"
+	"nexus-gateway/auth"
+	"nexus-gateway/clientip"
+	"nexus-gateway/logging"
+	"nexus-gateway/metrics"
+	"nexus-gateway/tracing"
+
 	"github.com/rs/cors"
-	"golang.org/x/time/rate"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
-// RateLimit implements a token bucket limiter per IP
-// In a real distributed system, use Redis. For now, in-memory map.
-type IPMetrics struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
-}
+// CORS allows the local development origins plus origins
+func CORS(next http.Handler, origins []string) http.Handler {
+	allowed := []string{"http://localhost:5173", "http://localhost:3000", "http://127.0.0.1:5173", "http://localhost:5174", "http://localhost:5175"}
 
-var (
-	clients = make(map[string]*IPMetrics)
-	mu      sync.Mutex
-)
+	for _, origin := range origins {
+		allowed = append(allowed, strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+	}
 
-func getLimiter(ip string) *rate.Limiter {
-	mu.Lock()
-	defer mu.Unlock()
+	c := cors.New(cors.Options{
+		AllowedOrigins:   allowed,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Origin", "Accept", auth.CSRFHeader, logging.RequestIDHeader},
+		ExposedHeaders:   []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "Deprecation", "Link", logging.RequestIDHeader},
+		AllowCredentials: true,
+		Debug:            true, // Enable Debugging
+	})
+	return c.Handler(next)
+}
 
-	client, exists := clients[ip]
-	if !exists {
-		// Allow 5 requests per second with burst of 10
-		limiter := rate.NewLimiter(5, 10)
-		clients[ip] = &IPMetrics{limiter, time.Now()}
-		return limiter
-	}
-	client.lastSeen = time.Now()
-	return client.limiter
+// RealIP resolves the client IP (honouring forwarding headers from trusted
+// proxies only) and stores it in the request context for clientip.FromRequest.
+// It must run before anything that looks at the client address.
+func RealIP(next http.Handler, resolver *clientip.Resolver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := clientip.WithIP(r.Context(), resolver.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
 
-func RateLimit(next http.Handler) http.Handler {
-	// Cleanup background routine (simplified)
+// RequestID accepts a well-formed X-Request-ID from the client or generates
+// one, stores it in the request context and echoes it in the response
+func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr // Simplified IP extraction
-		limiter := getLimiter(ip)
-		if !limiter.Allow() {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
 		}
-		next.ServeHTTP(w, r)
+		w.Header().Set(logging.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
 	})
 }
 
-func CORS(next http.Handler) http.Handler {
-	allowed := []string{"http://localhost:5173", "http://localhost:3000", "http://127.0.0.1:5173", "http://localhost:5174", "http://localhost:5175"}
-
-	if envOrigins := os.Getenv("ALLOWED_ORIGINS"); envOrigins != "" {
-		for _, origin := range strings.Split(envOrigins, ",") {
-			allowed = append(allowed, strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+// validRequestID only lets through short IDs made of safe characters, so
+// clients cannot inject arbitrary text into logs or upstream headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
 		}
 	}
+	return true
+}
 
-	c := cors.New(cors.Options{
-		AllowedOrigins:   allowed,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "Origin", "Accept"},
-		AllowCredentials: true,
-		Debug:            true, // Enable Debugging
-	})
-	return c.Handler(next)
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
 }
 
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Logging writes one structured line per request. Query strings are left
+// out since they contain search terms.
 func Logging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		log.Printf("Request: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		slog.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"ip", clientip.FromRequest(r),
+		)
+	})
+}
+
+// Deprecated marks responses from next as coming from a deprecated route.
+// successor, if not empty, is linked as the route to use instead.
+func Deprecated(next http.Handler, successor string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		if successor != "" {
+			w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		}
 		next.ServeHTTP(w, r)
-		log.Printf("Completed %s %s in %v", r.Method, r.URL.Path, time.Since(start))
 	})
 }
 
-func Auth(next http.Handler, jwtSecret string) http.Handler {
+// Metrics counts requests to next and their latency under route, which
+// should be the pattern next is registered with so that path parameters
+// and unknown paths don't blow up the number of series
+func Metrics(next http.Handler, route string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		metrics.ObserveRequest(route, r.Method, rec.status, time.Since(start))
+	})
+}
+
+// Trace starts a server span for the request, continuing the trace of an
+// incoming traceparent header if there is one
+func Trace(next http.Handler, route string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.Extract(r.Context(), r)
+		ctx, span := tracing.StartServer(ctx, r.Method+" "+route,
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("request_id", logging.RequestID(r.Context())))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// AuthOption customises the Auth middleware
+type AuthOption func(*authConfig)
+
+type authConfig struct {
+	sessionCheck func(context.Context, *auth.Claims) error
+	onFailure    func(r *http.Request, username, reason string)
+}
+
+// WithSessionCheck runs check against the parsed claims of every request,
+// e.g. to reject disabled accounts or revoked sessions.
+func WithSessionCheck(check func(context.Context, *auth.Claims) error) AuthOption {
+	return func(c *authConfig) {
+		c.sessionCheck = check
+	}
+}
+
+// WithFailureHook calls hook for every rejected request, e.g. to write it to
+// the audit log. username is empty when no valid token was presented.
+func WithFailureHook(hook func(r *http.Request, username, reason string)) AuthOption {
+	return func(c *authConfig) {
+		c.onFailure = hook
+	}
+}
+
+// tokenFromRequest returns the session JWT from the Authorization header or,
+// failing that, the session cookie
+func tokenFromRequest(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	if cookie, err := r.Cookie(auth.TokenCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func Auth(next http.Handler, jwtSecret string, opts ...AuthOption) http.Handler {
+	cfg := &authConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Prepare to check cookie or header
-		tokenString := ""
-
-		// Check Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if strings.HasPrefix(authHeader, "Bearer ") {
-			tokenString = strings.TrimPrefix(authHeader, "Bearer ")
-		} else {
-			// Check Cookie
-			cookie, err := r.Cookie("token")
-			if err == nil {
-				tokenString = cookie.Value
+		tokenString := tokenFromRequest(r)
+
+		reject := func(username, reason string) {
+			if cfg.onFailure != nil {
+				cfg.onFailure(r, username, reason)
 			}
+			apierror.Unauthorized(w, r, "Unauthorized")
 		}
 
 		if tokenString == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			reject("", "missing token")
 			return
 		}
 
-		claims := &jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(jwtSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		claims, err := auth.ParseToken(tokenString, jwtSecret)
+		if err != nil {
+			reject("", "invalid token")
 			return
 		}
 
+		if cfg.sessionCheck != nil {
+			if err := cfg.sessionCheck(r.Context(), claims); err != nil {
+				slog.WarnContext(r.Context(), "session rejected", "user", logging.Redact(claims.Username), "error", err)
+				reject(claims.Username, err.Error())
+				return
+			}
+		}
+
 		// Add user to context
-		username := (*claims)["username"].(string)
-		ctx := context.WithValue(r.Context(), "user", username)
+		ctx := context.WithValue(r.Context(), "user", claims.Username)
+		ctx = context.WithValue(ctx, "claims", claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequireRole only lets through requests whose token carries one of roles.
+// It must run after Auth.
+func RequireRole(next http.Handler, roles ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value("claims").(*auth.Claims)
+		if !ok {
+			apierror.Unauthorized(w, r, "Unauthorized")
+			return
+		}
+
+		role := claims.Role
+		if role == "" {
+			role = auth.RoleUser
+		}
+		for _, allowed := range roles {
+			if role == allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		apierror.Forbidden(w, r, "Forbidden")
+	})
+}
+
+// CSRF rejects state-changing requests that authenticate with the session
+// cookie unless they carry the matching X-CSRF-Token header. Requests with a
+// bearer token are exempt since browsers never attach those automatically.
+func CSRF(next http.Handler, jwtSecret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(auth.TokenCookie)
+		if err != nil || cookie.Value == "" {
+			// Not cookie-authenticated; Auth decides what happens next
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !auth.ValidCSRFToken(jwtSecret, cookie.Value, r.Header.Get(auth.CSRFHeader)) {
+			apierror.Respond(w, r, http.StatusForbidden, apierror.CodeInvalidCSRFToken, "Invalid CSRF token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MaxUploadBytes is the largest upload the gateway accepts
+const MaxUploadBytes = 50 * 1024 * 1024 // 50MB
+
 func StorageCheck(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.ContentLength > 50*1024*1024 { // 50MB
-			http.Error(w, "Request entity too large", http.StatusRequestEntityTooLarge)
+		if r.ContentLength > MaxUploadBytes {
+			apierror.Respond(w, r, http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, "Request entity too large")
 			return
 		}
 		next.ServeHTTP(w, r)
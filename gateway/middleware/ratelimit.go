@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/auth"
+	"nexus-gateway/clientip"
+	"nexus-gateway/metrics"
+)
+
+// Route classes with separate rate-limit budgets
+const (
+	ClassDefault = "default"
+	ClassLogin   = "login"
+	ClassSearch  = "search"
+	ClassUpload  = "upload"
+	// ClassSerp is for searches that hit SerpApi, which has a paid quota
+	ClassSerp = "serp"
+)
+
+// Budget is a token bucket: Rate requests per second on average, with
+// bursts of up to Burst requests
+type Budget struct {
+	Rate  float64
+	Burst int
+}
+
+// DefaultBudgets apply to the free plan unless overridden per class
+var DefaultBudgets = map[string]Budget{
+	ClassDefault: {Rate: 5, Burst: 10},
+	ClassLogin:   {Rate: 5.0 / 60, Burst: 5},
+	ClassSearch:  {Rate: 2, Burst: 10},
+	ClassUpload:  {Rate: 6.0 / 60, Burst: 3},
+	ClassSerp:    {Rate: 12.0 / 60, Burst: 5},
+}
+
+// DefaultPlanMultipliers scale every budget by the caller's plan unless
+// overridden. Unknown plans get 1.
+var DefaultPlanMultipliers = map[string]float64{
+	"free": 1,
+	"pro":  5,
+}
+
+// Classifier picks the budget class for a request
+type Classifier func(r *http.Request) string
+
+// Class always returns name
+func Class(name string) Classifier {
+	return func(r *http.Request) string { return name }
+}
+
+// SearchClass charges web searches (which call SerpApi) to ClassSerp and
+// every other search to ClassSearch
+func SearchClass(r *http.Request) string {
+	if r.URL.Query().Get("web") == "true" {
+		return ClassSerp
+	}
+	return ClassSearch
+}
+
+// RateLimiter enforces per-class budgets keyed on the authenticated user when
+// the request carries a valid token, and on the client IP otherwise. Counters
+// live in a LimiterStore so that replicas can share them.
+type RateLimiter struct {
+	jwtSecret string
+	budgets   map[string]Budget
+	plans     map[string]float64
+	store     LimiterStore
+}
+
+// NewRateLimiter builds a limiter from the defaults, overridden by budgets
+// and plans. jwtSecret is used to identify the caller; it does not reject
+// invalid tokens, that is Auth's job.
+func NewRateLimiter(jwtSecret string, store LimiterStore, budgets map[string]Budget, plans map[string]float64) *RateLimiter {
+	l := &RateLimiter{
+		jwtSecret: jwtSecret,
+		budgets:   make(map[string]Budget),
+		plans:     make(map[string]float64),
+		store:     store,
+	}
+	for class, b := range DefaultBudgets {
+		l.budgets[class] = b
+	}
+	for plan, m := range DefaultPlanMultipliers {
+		l.plans[plan] = m
+	}
+	for class, b := range budgets {
+		l.budgets[class] = b
+	}
+	for plan, m := range plans {
+		l.plans[plan] = m
+	}
+	return l
+}
+
+// KnownPlan reports whether plan has a multiplier, either by default or
+// from the configuration
+func (l *RateLimiter) KnownPlan(plan string) bool {
+	_, ok := l.plans[plan]
+	return ok
+}
+
+// budget returns the class budget scaled for plan
+func (l *RateLimiter) budget(class, plan string) Budget {
+	b, ok := l.budgets[class]
+	if !ok {
+		b = l.budgets[ClassDefault]
+	}
+	m, ok := l.plans[plan]
+	if !ok {
+		m = 1
+	}
+	return Budget{Rate: b.Rate * m, Burst: int(math.Ceil(float64(b.Burst) * m))}
+}
+
+// principal identifies the caller and their plan
+func (l *RateLimiter) principal(r *http.Request) (key, plan string) {
+	if tokenString := tokenFromRequest(r); tokenString != "" {
+		if claims, err := auth.ParseToken(tokenString, l.jwtSecret); err == nil {
+			return "user:" + claims.Username, claims.Plan
+		}
+	}
+	return "ip:" + clientip.FromRequest(r), ""
+}
+
+// take charges one request by key to class. If the store fails the request
+// is let through rather than taking the API down with it, and ok is false.
+func (l *RateLimiter) take(ctx context.Context, class, key, plan string) (d Decision, ok bool) {
+	d, err := l.store.Take(ctx, class+"|"+key, l.budget(class, plan))
+	if err != nil {
+		slog.ErrorContext(ctx, "rate limit store error", "class", class, "error", err)
+		return Decision{Allowed: true}, false
+	}
+	if !d.Allowed {
+		metrics.RateLimitRejections.WithLabelValues(class).Inc()
+	}
+	return d, true
+}
+
+// Allow charges a call that doesn't come over HTTP (the gRPC API) to class,
+// sharing the counters of Limit: username's on their plan, or those of ip
+// when username is empty
+func (l *RateLimiter) Allow(ctx context.Context, class, username, plan, ip string) Decision {
+	key := "ip:" + ip
+	if username != "" {
+		key = "user:" + username
+	} else {
+		plan = ""
+	}
+	d, _ := l.take(ctx, class, key, plan)
+	return d
+}
+
+// Limit rate-limits next using the budget of the class chosen by classify.
+// Responses carry RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, and rejected requests get Retry-After. If the store fails the
+// request is let through rather than taking the API down with it.
+func (l *RateLimiter) Limit(next http.Handler, classify Classifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := classify(r)
+		key, plan := l.principal(r)
+
+		d, ok := l.take(r.Context(), class, key, plan)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+
+		if !d.Allowed {
+			retryAfter := max(ceilSeconds(d.RetryAfter), 1)
+			h.Set("Retry-After", strconv.Itoa(retryAfter))
+			apierror.Write(w, r, apierror.New(http.StatusTooManyRequests, apierror.CodeRateLimited, "Too many requests").
+				WithDetails(map[string]interface{}{"class": class, "retry_after_seconds": retryAfter}))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
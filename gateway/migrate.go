@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"nexus-gateway/store/mongostore"
+)
+
+// runMigrate applies pending migrations, or with status only lists them,
+// and returns the exit code
+func runMigrate(db *mongostore.Store, status bool) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if !status {
+		if _, err := db.Migrate(ctx); err != nil {
+			slog.Error("Migration failed", "error", err)
+			return 1
+		}
+	}
+
+	list, err := db.MigrationStatus(ctx)
+	if err != nil {
+		slog.Error("Failed to read migrations", "error", err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, m := range list {
+		applied := "pending"
+		if m.AppliedAt != nil {
+			applied = m.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, applied)
+	}
+	w.Flush()
+	return 0
+}
@@ -0,0 +1,314 @@
+// Package openapi builds the gateway's OpenAPI 3 document from the routes
+// as they are registered, with request and response schemas generated from
+// the Go types the handlers decode and encode. The same document validates
+// incoming requests, so the spec cannot describe parameters the gateway
+// does not check.
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"nexus-gateway/apierror"
+)
+
+const Version = "3.0.3"
+
+// Document is an OpenAPI document. It round-trips through JSON, so a client
+// can load the served spec and validate against it.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+
+	// security is required by every operation that is not public
+	security []map[string][]string
+	// types tracks which Go type each component schema was generated from
+	types   map[string]reflect.Type
+	once    sync.Once
+	encoded []byte
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps lower case HTTP methods to operations
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+const (
+	jsonType = "application/json"
+	formType = "multipart/form-data"
+)
+
+// New returns a document without operations
+func New(info Info) *Document {
+	return &Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      make(map[string]PathItem),
+		Components: Components{Schemas: make(map[string]*Schema)},
+		types:      make(map[string]reflect.Type),
+	}
+}
+
+// SetAuth declares the ways to authenticate. Operations that are not public
+// accept any one of them.
+func (d *Document) SetAuth(schemes map[string]SecurityScheme) {
+	d.Components.SecuritySchemes = schemes
+	d.security = nil
+	names := make([]string, 0, len(schemes))
+	for name := range schemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d.security = append(d.security, map[string][]string{name: {}})
+	}
+}
+
+// Op describes an operation for Add. Bodies are given as a value of the Go
+// type the handler decodes or encodes, or as a *Schema.
+type Op struct {
+	ID          string
+	Summary     string
+	Description string
+	Tag         string
+	// Public operations work without a session
+	Public bool
+	// Params are the query parameters. Path parameters are taken from the
+	// path and only need listing to describe or constrain them.
+	Params []Param
+	// Body is the JSON request body, required unless BodyOptional
+	Body         interface{}
+	BodyOptional bool
+	// Form is a multipart/form-data request body instead
+	Form *Schema
+	// Status is the success status, 200 if zero
+	Status int
+	// Response is the body of the success response, nil for none.
+	// ResponseType is its media type, JSON by default.
+	Response     interface{}
+	ResponseType string
+	// Also documents responses with other statuses that are not errors
+	Also map[int]interface{}
+}
+
+type Param struct {
+	Name        string
+	Description string
+	Required    bool
+	Schema      *Schema
+}
+
+// Add documents op at method path. Paths use the same {name} wildcards as
+// http.ServeMux.
+func (d *Document) Add(method, path string, op Op) {
+	o := &Operation{
+		OperationID: op.ID,
+		Summary:     op.Summary,
+		Description: op.Description,
+		Responses:   make(map[string]*Response),
+	}
+	if op.Tag != "" {
+		o.Tags = []string{op.Tag}
+	}
+	if !op.Public {
+		o.Security = d.security
+	}
+
+	inPath := make(map[string]bool)
+	for _, name := range pathParams(path) {
+		inPath[name] = true
+		p := Parameter{Name: name, In: "path", Required: true, Schema: String()}
+		for _, q := range op.Params {
+			if q.Name == name {
+				p.Description = q.Description
+				if q.Schema != nil {
+					p.Schema = q.Schema
+				}
+			}
+		}
+		o.Parameters = append(o.Parameters, p)
+	}
+	for _, q := range op.Params {
+		if inPath[q.Name] {
+			continue
+		}
+		schema := q.Schema
+		if schema == nil {
+			schema = String()
+		}
+		o.Parameters = append(o.Parameters, Parameter{Name: q.Name, In: "query", Description: q.Description, Required: q.Required, Schema: schema})
+	}
+
+	switch {
+	case op.Form != nil:
+		o.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{formType: {Schema: op.Form}}}
+	case op.Body != nil:
+		o.RequestBody = &RequestBody{Required: !op.BodyOptional, Content: map[string]MediaType{jsonType: {Schema: d.schemaFor(op.Body)}}}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	mediaType := op.ResponseType
+	if mediaType == "" {
+		mediaType = jsonType
+	}
+	o.Responses[statusKey(status)] = d.response(status, mediaType, op.Response)
+	for status, body := range op.Also {
+		o.Responses[statusKey(status)] = d.response(status, mediaType, body)
+	}
+	o.Responses["default"] = &Response{
+		Description: "Error",
+		Content:     map[string]MediaType{jsonType: {Schema: d.schemaFor(apierror.Envelope{})}},
+	}
+
+	item := d.Paths[path]
+	if item == nil {
+		item = make(PathItem)
+		d.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = o
+}
+
+func (d *Document) response(status int, mediaType string, body interface{}) *Response {
+	resp := &Response{Description: http.StatusText(status)}
+	if body != nil {
+		resp.Content = map[string]MediaType{mediaType: {Schema: d.schemaFor(body)}}
+	}
+	return resp
+}
+
+// Handler serves the document as JSON. The document must not change once
+// it is being served.
+func (d *Document) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.once.Do(func() { d.encoded, _ = json.Marshal(d) })
+		w.Header().Set("Content-Type", jsonType)
+		w.Write(d.encoded)
+	}
+}
+
+// ErrNoOperation is returned for requests the document does not describe
+var ErrNoOperation = errors.New("openapi: no operation")
+
+// Find returns the operation serving method and the concrete path, and
+// the path template it is documented under
+func (d *Document) Find(method, path string) (*Operation, string, error) {
+	method = strings.ToLower(method)
+	segments := strings.Split(path, "/")
+	var found *Operation
+	template, best := "", -1
+	for tmpl, item := range d.Paths {
+		op := item[method]
+		if op == nil {
+			continue
+		}
+		if n, ok := match(strings.Split(tmpl, "/"), segments); ok && n > best {
+			found, template, best = op, tmpl, n
+		}
+	}
+	if found == nil {
+		return nil, "", ErrNoOperation
+	}
+	return found, template, nil
+}
+
+// match reports whether path matches template and how many of its segments
+// matched literally, so the most specific template can win
+func match(template, path []string) (int, bool) {
+	if len(template) != len(path) {
+		return 0, false
+	}
+	literal := 0
+	for i, t := range template {
+		if isWildcard(t) {
+			if path[i] == "" {
+				return 0, false
+			}
+			continue
+		}
+		if t != path[i] {
+			return 0, false
+		}
+		literal++
+	}
+	return literal, true
+}
+
+func isWildcard(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+func pathParams(path string) []string {
+	var names []string
+	for _, s := range strings.Split(path, "/") {
+		if isWildcard(s) {
+			names = append(names, strings.TrimSuffix(strings.Trim(s, "{}"), "..."))
+		}
+	}
+	return names
+}
+
+func statusKey(status int) string {
+	return strconv.Itoa(status)
+}
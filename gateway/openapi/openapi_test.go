@@ -0,0 +1,144 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type base struct {
+	ID      primitive.ObjectID `json:"id"`
+	Created time.Time          `json:"created_at"`
+}
+
+type item struct {
+	base
+	Name    string            `json:"name"`
+	Tags    []string          `json:"tags,omitempty"`
+	Parent  *item             `json:"parent,omitempty"`
+	Expires *time.Time        `json:"expires"`
+	Extra   map[string]string `json:"extra,omitempty"`
+	Secret  string            `json:"-"`
+	hidden  int
+}
+
+func TestSchemaOf(t *testing.T) {
+	d := New(Info{Title: "test", Version: "1"})
+	ref := d.schemaFor([]item{})
+	if ref.Type != "array" || ref.Items.Ref != refPrefix+"item" {
+		t.Fatalf("[]item = %+v", ref)
+	}
+
+	s := d.Components.Schemas["item"]
+	var names []string
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	if len(names) != 7 {
+		t.Errorf("properties = %v, want the embedded and exported JSON fields", names)
+	}
+	if got := strings.Join(s.Required, ","); got != "id,created_at,name,expires" {
+		t.Errorf("required = %s", got)
+	}
+	if p := s.Properties["id"]; p.Pattern != ObjectIDPattern {
+		t.Errorf("id = %+v", p)
+	}
+	if p := s.Properties["expires"]; p.Format != "date-time" || !p.Nullable {
+		t.Errorf("expires = %+v, want a nullable date-time", p)
+	}
+	if p := s.Properties["parent"]; p.Ref != refPrefix+"item" {
+		t.Errorf("parent = %+v, want a reference to item", p)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	d := New(Info{Title: "test", Version: "1"})
+	d.Add("POST", "/things/{id}", Op{
+		Params: []Param{
+			{Name: "id", Schema: ObjectID()},
+			{Name: "limit", Schema: Integer().Min(1)},
+		},
+		Body: struct {
+			Name string `json:"name"`
+			Size int    `json:"size,omitempty"`
+		}{},
+	})
+	mux := http.NewServeMux()
+	mux.Handle("POST /things/{id}", d.Validate("POST", "/things/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error("body not passed on:", err)
+		}
+	})))
+
+	tests := []struct {
+		path, body string
+		problems   []string
+	}{
+		{"/things/5f1d7a3b9c8e4a2b1c0d9e8f?limit=2", `{"name": "a", "size": 3}`, nil},
+		{"/things/x?limit=0", `{"size": 1.5, "color": "red"}`, []string{
+			"path parameter id is malformed",
+			"query parameter limit must be at least 1",
+			"body.name is required",
+			"body.color is not allowed",
+			"body.size must be an integer",
+		}},
+		{"/things/5f1d7a3b9c8e4a2b1c0d9e8f", ``, []string{"body is required"}},
+		{"/things/5f1d7a3b9c8e4a2b1c0d9e8f", `{"name": "a"`, []string{"body is not valid JSON"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body)))
+		if tt.problems == nil {
+			if w.Code != http.StatusOK {
+				t.Errorf("%s %s: status %d: %s", tt.path, tt.body, w.Code, w.Body)
+			}
+			continue
+		}
+
+		var env struct {
+			Error struct {
+				Details struct {
+					Errors []string `json:"errors"`
+				} `json:"details"`
+			} `json:"error"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &env)
+		if w.Code != http.StatusBadRequest || strings.Join(env.Error.Details.Errors, "|") != strings.Join(tt.problems, "|") {
+			t.Errorf("%s %s: status %d, errors %q, want %q", tt.path, tt.body, w.Code, env.Error.Details.Errors, tt.problems)
+		}
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	d := New(Info{Title: "test", Version: "1"})
+	d.Add("GET", "/items/{id}", Op{Response: item{}})
+	d.Add("GET", "/items/latest", Op{Response: item{}})
+	d.Add("DELETE", "/items/{id}", Op{Status: 204})
+
+	if _, tmpl, _ := d.Find("GET", "/items/latest"); tmpl != "/items/latest" {
+		t.Errorf("GET /items/latest matched %s", tmpl)
+	}
+
+	good := `{"id": "5f1d7a3b9c8e4a2b1c0d9e8f", "created_at": "2024-01-02T03:04:05Z", "name": "a", "expires": null}`
+	if err := d.CheckResponse("GET", "/items/1", 200, "application/json", []byte(good)); err != nil {
+		t.Error(err)
+	}
+	if err := d.CheckResponse("GET", "/items/1", 200, "application/json", []byte(`{"name": "a"}`)); err == nil {
+		t.Error("missing fields passed")
+	}
+	if err := d.CheckResponse("GET", "/items/1", 404, "application/json", []byte(`{"error": {"code": "not_found", "message": "no"}}`)); err != nil {
+		t.Error(err)
+	}
+	if err := d.CheckResponse("DELETE", "/items/1", 200, "", nil); err == nil {
+		t.Error("undocumented status passed")
+	}
+	if err := d.CheckResponse("PUT", "/items/1", 200, "", nil); err != ErrNoOperation {
+		t.Errorf("PUT: err = %v, want ErrNoOperation", err)
+	}
+}
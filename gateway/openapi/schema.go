@@ -0,0 +1,187 @@
+package openapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Schema is the part of the OpenAPI schema object the gateway uses
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+}
+
+// ObjectIDPattern matches the hex form of a MongoDB ObjectID
+const ObjectIDPattern = "^[0-9a-fA-F]{24}$"
+
+const refPrefix = "#/components/schemas/"
+
+func String() *Schema   { return &Schema{Type: "string"} }
+func Boolean() *Schema  { return &Schema{Type: "boolean"} }
+func DateTime() *Schema { return &Schema{Type: "string", Format: "date-time"} }
+func ObjectID() *Schema { return &Schema{Type: "string", Pattern: ObjectIDPattern} }
+func Binary() *Schema   { return &Schema{Type: "string", Format: "binary"} }
+func Integer() *Schema  { return &Schema{Type: "integer"} }
+
+// Enum returns a string schema that only allows values
+func Enum(values ...string) *Schema {
+	return &Schema{Type: "string", Enum: values}
+}
+
+// Min sets the minimum of a number schema
+func (s *Schema) Min(v float64) *Schema {
+	s.Minimum = &v
+	return s
+}
+
+// Max sets the maximum of a number schema
+func (s *Schema) Max(v float64) *Schema {
+	s.Maximum = &v
+	return s
+}
+
+// Object returns an object schema with the given properties, of which
+// required must be present
+func Object(properties map[string]*Schema, required ...string) *Schema {
+	return &Schema{Type: "object", Properties: properties, Required: required}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// schemaFor returns v as is if it is a *Schema, otherwise the schema of
+// its type
+func (d *Document) schemaFor(v interface{}) *Schema {
+	if s, ok := v.(*Schema); ok {
+		return s
+	}
+	return d.schemaOf(reflect.TypeOf(v))
+}
+
+// schemaOf follows encoding/json: named structs become components that
+// are referenced by name, fields without omitempty are required and nothing
+// outside the struct's fields is allowed
+func (d *Document) schemaOf(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return DateTime()
+	case objectIDType:
+		return ObjectID()
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return d.schemaOf(t.Elem())
+	case reflect.Bool:
+		return Boolean()
+	case reflect.String:
+		return String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return Integer()
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schemaOf(t.Elem())}
+	case reflect.Map:
+		allow := true
+		return &Schema{Type: "object", AdditionalProperties: &allow}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		name := t.Name()
+		if prev, ok := d.types[name]; ok {
+			if prev != t {
+				panic(fmt.Sprintf("openapi: %s and %s are both named %s", prev.PkgPath(), t.PkgPath(), name))
+			}
+			return &Schema{Ref: refPrefix + name}
+		}
+		// Registered before the fields so recursive types terminate
+		d.types[name] = t
+		d.Components.Schemas[name] = nil
+		d.Components.Schemas[name] = d.structSchema(t)
+		return &Schema{Ref: refPrefix + name}
+	}
+	panic("openapi: no schema for " + t.String())
+}
+
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	strict := false
+	s.AdditionalProperties = &strict
+	d.addFields(s, t)
+	return s
+}
+
+// addFields adds the JSON fields of t to s, including those of embedded
+// structs
+func (d *Document) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				d.addFields(s, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := d.schemaOf(f.Type)
+		omitempty := strings.Contains(opts, "omitempty")
+		if f.Type.Kind() == reflect.Pointer && !omitempty && fs.Ref == "" {
+			c := *fs
+			c.Nullable = true
+			fs = &c
+		}
+		s.Properties[name] = fs
+		if !omitempty {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// resolve follows a $ref to its component
+func (d *Document) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, refPrefix)]
+	}
+	return s
+}
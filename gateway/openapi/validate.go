@@ -0,0 +1,283 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nexus-gateway/apierror"
+)
+
+// maxBody caps the JSON request bodies that are validated; none of the
+// gateway's come close
+const maxBody = 1 << 20
+
+// Validate wraps h, the handler of the operation documented at method path,
+// so requests whose parameters or JSON body don't match the operation get
+// a 400 listing every problem before they reach h
+func (d *Document) Validate(method, path string, h http.Handler) http.Handler {
+	op := d.Paths[path][strings.ToLower(method)]
+	if op == nil {
+		panic("openapi: " + method + " " + path + " is not documented")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if problems := d.checkRequest(op, r); len(problems) > 0 {
+			apierror.Write(w, r, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request").
+				WithDetails(map[string]interface{}{"errors": problems}))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (d *Document) checkRequest(op *Operation, r *http.Request) []string {
+	var problems []string
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		at := p.In + " parameter " + p.Name
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value = r.PathValue(p.Name)
+			present = value != ""
+		case "query":
+			value = query.Get(p.Name)
+			present = query.Has(p.Name)
+		}
+		if !present {
+			if p.Required {
+				problems = append(problems, at+" is required")
+			}
+			continue
+		}
+		problems = d.checkParam(p.Schema, value, at, problems)
+	}
+
+	media, ok := op.RequestBody.json()
+	if !ok {
+		return problems
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	switch {
+	case err != nil:
+		return append(problems, "body could not be read")
+	case len(body) > maxBody:
+		return append(problems, "body is too large")
+	case len(bytes.TrimSpace(body)) == 0:
+		if op.RequestBody.Required {
+			problems = append(problems, "body is required")
+		}
+		return problems
+	}
+	v, err := decode(body)
+	if err != nil {
+		return append(problems, "body is not valid JSON")
+	}
+	return d.check(media.Schema, v, "body", problems)
+}
+
+// json returns the JSON media type of a request body, if it has one
+func (b *RequestBody) json() (MediaType, bool) {
+	if b == nil {
+		return MediaType{}, false
+	}
+	m, ok := b.Content[jsonType]
+	return m, ok
+}
+
+// checkParam converts a parameter to the type of its schema and checks the
+// result. Booleans must be spelled out since that's all the handlers
+// understand.
+func (d *Document) checkParam(s *Schema, value, at string, problems []string) []string {
+	s = d.resolve(s)
+	var v interface{} = value
+	switch s.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return append(problems, at+" must be a number")
+		}
+		v = json.Number(value)
+	case "boolean":
+		if value != "true" && value != "false" {
+			return append(problems, at+" must be true or false")
+		}
+		v = value == "true"
+	}
+	return d.check(s, v, at, problems)
+}
+
+// check validates a decoded JSON value against s, appending a message for
+// every mismatch
+func (d *Document) check(s *Schema, v interface{}, at string, problems []string) []string {
+	s = d.resolve(s)
+	if s == nil || (s.Type == "" && s.Ref == "") {
+		return problems
+	}
+	if v == nil {
+		if !s.Nullable {
+			problems = append(problems, at+" must not be null")
+		}
+		return problems
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return append(problems, at+" must be an object")
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, at+"."+name+" is required")
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					problems = append(problems, at+"."+name+" is not allowed")
+				}
+				continue
+			}
+			problems = d.check(prop, obj[name], at+"."+name, problems)
+		}
+
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return append(problems, at+" must be an array")
+		}
+		for i, item := range items {
+			problems = d.check(s.Items, item, fmt.Sprintf("%s[%d]", at, i), problems)
+		}
+
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return append(problems, at+" must be a string")
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+			problems = append(problems, at+" must be one of "+strings.Join(s.Enum, ", "))
+		}
+		if s.Pattern != "" && !pattern(s.Pattern).MatchString(str) {
+			problems = append(problems, at+" is malformed")
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				problems = append(problems, at+" must be an RFC 3339 date-time")
+			}
+		}
+
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return append(problems, at+" must be a number")
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return append(problems, at+" must be a number")
+		}
+		if s.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				return append(problems, at+" must be an integer")
+			}
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			problems = append(problems, fmt.Sprintf("%s must be at least %v", at, *s.Minimum))
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			problems = append(problems, fmt.Sprintf("%s must be at most %v", at, *s.Maximum))
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			problems = append(problems, at+" must be a boolean")
+		}
+	}
+	return problems
+}
+
+var patterns sync.Map
+
+// pattern compiles and caches a schema pattern
+func pattern(expr string) *regexp.Regexp {
+	if re, ok := patterns.Load(expr); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(expr)
+	patterns.Store(expr, re)
+	return re
+}
+
+func decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("trailing data")
+	}
+	return v, nil
+}
+
+// CheckResponse reports how a response to method path differs from what
+// the document promises. It returns ErrNoOperation for requests the
+// document does not describe. Only JSON bodies are checked.
+func (d *Document) CheckResponse(method, path string, status int, contentType string, body []byte) error {
+	op, template, err := d.Find(method, path)
+	if err != nil {
+		return err
+	}
+	what := method + " " + template
+	resp := op.Responses[statusKey(status)]
+	if resp == nil {
+		if status < 400 {
+			return fmt.Errorf("%s: status %d is not documented", what, status)
+		}
+		resp = op.Responses["default"]
+	}
+
+	if len(resp.Content) == 0 {
+		if len(bytes.TrimSpace(body)) > 0 {
+			return fmt.Errorf("%s: status %d should have no body", what, status)
+		}
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, ok := resp.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("%s: status %d is not documented as %q", what, status, contentType)
+	}
+	if mediaType != jsonType {
+		return nil
+	}
+	v, err := decode(body)
+	if err != nil {
+		return fmt.Errorf("%s: invalid JSON: %v", what, err)
+	}
+	if problems := d.check(media.Schema, v, "body", nil); len(problems) > 0 {
+		return fmt.Errorf("%s: status %d: %s", what, status, strings.Join(problems, "; "))
+	}
+	return nil
+}
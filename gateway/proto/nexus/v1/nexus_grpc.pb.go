@@ -0,0 +1,300 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: nexus/v1/nexus.proto
+
+package nexusv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Nexus_Search_FullMethodName         = "/nexus.v1.Nexus/Search"
+	Nexus_SearchStream_FullMethodName   = "/nexus.v1.Nexus/SearchStream"
+	Nexus_Upload_FullMethodName         = "/nexus.v1.Nexus/Upload"
+	Nexus_ListDocuments_FullMethodName  = "/nexus.v1.Nexus/ListDocuments"
+	Nexus_DeleteDocument_FullMethodName = "/nexus.v1.Nexus/DeleteDocument"
+)
+
+// NexusClient is the client API for Nexus service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Nexus is the gateway's API for backend services. It searches and manages
+// documents on the same stores as the REST API under /api/v1.
+//
+// Every call needs the token from POST /api/v1/login in the
+// "authorization: Bearer <token>" metadata. Failures carry the REST API's
+// error code (e.g. quota_exceeded) as the reason of a google.rpc.ErrorInfo
+// detail.
+type NexusClient interface {
+	// Search returns the results of every provider at once, like
+	// GET /api/v1/search
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// SearchStream sends the results of each provider as soon as it answers
+	SearchStream(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SearchBatch], error)
+	// Upload adds a file to the caller's knowledge base. The first message
+	// names the file and the contents follow in any number of messages.
+	Upload(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadChunk, UploadResponse], error)
+	// ListDocuments returns the caller's documents, newest first
+	ListDocuments(ctx context.Context, in *ListDocumentsRequest, opts ...grpc.CallOption) (*ListDocumentsResponse, error)
+	// DeleteDocument removes one of the caller's documents and its chunks
+	DeleteDocument(ctx context.Context, in *DeleteDocumentRequest, opts ...grpc.CallOption) (*DeleteDocumentResponse, error)
+}
+
+type nexusClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewNexusClient(cc grpc.ClientConnInterface) NexusClient {
+	return &nexusClient{cc}
+}
+
+func (c *nexusClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, Nexus_Search_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nexusClient) SearchStream(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SearchBatch], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Nexus_ServiceDesc.Streams[0], Nexus_SearchStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SearchRequest, SearchBatch]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Nexus_SearchStreamClient = grpc.ServerStreamingClient[SearchBatch]
+
+func (c *nexusClient) Upload(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadChunk, UploadResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Nexus_ServiceDesc.Streams[1], Nexus_Upload_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UploadChunk, UploadResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Nexus_UploadClient = grpc.ClientStreamingClient[UploadChunk, UploadResponse]
+
+func (c *nexusClient) ListDocuments(ctx context.Context, in *ListDocumentsRequest, opts ...grpc.CallOption) (*ListDocumentsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDocumentsResponse)
+	err := c.cc.Invoke(ctx, Nexus_ListDocuments_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nexusClient) DeleteDocument(ctx context.Context, in *DeleteDocumentRequest, opts ...grpc.CallOption) (*DeleteDocumentResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteDocumentResponse)
+	err := c.cc.Invoke(ctx, Nexus_DeleteDocument_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// NexusServer is the server API for Nexus service.
+// All implementations must embed UnimplementedNexusServer
+// for forward compatibility.
+//
+// Nexus is the gateway's API for backend services. It searches and manages
+// documents on the same stores as the REST API under /api/v1.
+//
+// Every call needs the token from POST /api/v1/login in the
+// "authorization: Bearer <token>" metadata. Failures carry the REST API's
+// error code (e.g. quota_exceeded) as the reason of a google.rpc.ErrorInfo
+// detail.
+type NexusServer interface {
+	// Search returns the results of every provider at once, like
+	// GET /api/v1/search
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// SearchStream sends the results of each provider as soon as it answers
+	SearchStream(*SearchRequest, grpc.ServerStreamingServer[SearchBatch]) error
+	// Upload adds a file to the caller's knowledge base. The first message
+	// names the file and the contents follow in any number of messages.
+	Upload(grpc.ClientStreamingServer[UploadChunk, UploadResponse]) error
+	// ListDocuments returns the caller's documents, newest first
+	ListDocuments(context.Context, *ListDocumentsRequest) (*ListDocumentsResponse, error)
+	// DeleteDocument removes one of the caller's documents and its chunks
+	DeleteDocument(context.Context, *DeleteDocumentRequest) (*DeleteDocumentResponse, error)
+	mustEmbedUnimplementedNexusServer()
+}
+
+// UnimplementedNexusServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedNexusServer struct{}
+
+func (UnimplementedNexusServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedNexusServer) SearchStream(*SearchRequest, grpc.ServerStreamingServer[SearchBatch]) error {
+	return status.Errorf(codes.Unimplemented, "method SearchStream not implemented")
+}
+func (UnimplementedNexusServer) Upload(grpc.ClientStreamingServer[UploadChunk, UploadResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedNexusServer) ListDocuments(context.Context, *ListDocumentsRequest) (*ListDocumentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDocuments not implemented")
+}
+func (UnimplementedNexusServer) DeleteDocument(context.Context, *DeleteDocumentRequest) (*DeleteDocumentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteDocument not implemented")
+}
+func (UnimplementedNexusServer) mustEmbedUnimplementedNexusServer() {}
+func (UnimplementedNexusServer) testEmbeddedByValue()               {}
+
+// UnsafeNexusServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to NexusServer will
+// result in compilation errors.
+type UnsafeNexusServer interface {
+	mustEmbedUnimplementedNexusServer()
+}
+
+func RegisterNexusServer(s grpc.ServiceRegistrar, srv NexusServer) {
+	// If the following call pancis, it indicates UnimplementedNexusServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Nexus_ServiceDesc, srv)
+}
+
+func _Nexus_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NexusServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Nexus_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NexusServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Nexus_SearchStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(NexusServer).SearchStream(m, &grpc.GenericServerStream[SearchRequest, SearchBatch]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Nexus_SearchStreamServer = grpc.ServerStreamingServer[SearchBatch]
+
+func _Nexus_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(NexusServer).Upload(&grpc.GenericServerStream[UploadChunk, UploadResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Nexus_UploadServer = grpc.ClientStreamingServer[UploadChunk, UploadResponse]
+
+func _Nexus_ListDocuments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDocumentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NexusServer).ListDocuments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Nexus_ListDocuments_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NexusServer).ListDocuments(ctx, req.(*ListDocumentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Nexus_DeleteDocument_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NexusServer).DeleteDocument(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Nexus_DeleteDocument_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NexusServer).DeleteDocument(ctx, req.(*DeleteDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Nexus_ServiceDesc is the grpc.ServiceDesc for Nexus service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Nexus_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "nexus.v1.Nexus",
+	HandlerType: (*NexusServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Search",
+			Handler:    _Nexus_Search_Handler,
+		},
+		{
+			MethodName: "ListDocuments",
+			Handler:    _Nexus_ListDocuments_Handler,
+		},
+		{
+			MethodName: "DeleteDocument",
+			Handler:    _Nexus_DeleteDocument_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SearchStream",
+			Handler:       _Nexus_SearchStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Upload",
+			Handler:       _Nexus_Upload_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "nexus/v1/nexus.proto",
+}
@@ -4,13 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 	"sync"
 	"time"
 
-	"go.mongodb.org/mongo-driver/mongo"
+	"nexus-gateway/logging"
+	"nexus-gateway/metrics"
+	"nexus-gateway/store"
+	"nexus-gateway/tracing"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SearchResult struct {
@@ -25,104 +30,135 @@ type SearchResponse struct {
 	TimeTakenMs int64          `json:"time_taken_ms"`
 }
 
-// Orchestrator handles parallel search requests
-func Orchestrator(ctx context.Context, client *mongo.Client, userID string, query string, enableWeb, enableWiki, enableDDG, enablePKB bool) ([]SearchResult, error) {
-	var wg sync.WaitGroup
-	resultsChan := make(chan []SearchResult, 3)
-	errChan := make(chan error, 3)
+// Public endpoints of the web search providers
+const (
+	DefaultSerpAPIURL    = "https://serpapi.com/search.json"
+	DefaultDuckDuckGoURL = "https://api.duckduckgo.com/"
+	DefaultWikipediaURL  = "https://en.wikipedia.org/w/api.php"
+)
 
-	// ctx is passed in now
-	// ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	// defer cancel() => caller handles timeout or we add timeout here
+// Config holds the worker address and provider credentials used by searches
+// and uploads. Empty provider URLs mean the public endpoints.
+type Config struct {
+	WorkerURL  string
+	SerpAPIKey string
 
-	// We should enforce a timeout for search
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+	SerpAPIURL    string
+	DuckDuckGoURL string
+	WikipediaURL  string
+}
 
-	if enableWeb {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			res, err := searchSerpApi(ctx, query)
-			if err != nil {
-				errChan <- fmt.Errorf("SerpApi: %v", err)
-				return
-			}
-			resultsChan <- res
-		}()
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
 	}
+	return v
+}
 
-	if enableDDG {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			res, err := searchDuckDuckGo(ctx, query)
-			if err != nil {
-				errChan <- fmt.Errorf("DDG: %v", err)
-				return
-			}
-			resultsChan <- res
-		}()
+// Providers, as named in metrics and in the results of Each
+const (
+	ProviderSerpAPI    = "serpapi"
+	ProviderDuckDuckGo = "ddg"
+	ProviderWikipedia  = "wikipedia"
+	ProviderPKB        = "pkb"
+)
+
+// Batch is everything one provider returned for a search
+type Batch struct {
+	Provider string
+	Results  []SearchResult
+	Err      error
+}
+
+// Orchestrator handles parallel search requests. PKB results are limited to
+// the chunks in scope. Failed providers are logged and left out.
+func Orchestrator(ctx context.Context, chunks store.ChunkStore, cfg Config, scope Scope, query string, enableWeb, enableWiki, enableDDG, enablePKB bool) ([]SearchResult, error) {
+	// Never nil, so clients get [] rather than null
+	allResults := []SearchResult{}
+	for b := range Each(ctx, chunks, cfg, scope, query, enableWeb, enableWiki, enableDDG, enablePKB) {
+		if b.Err != nil {
+			slog.WarnContext(ctx, "search provider failed", "provider", b.Provider, "error", b.Err)
+			continue
+		}
+		allResults = append(allResults, b.Results...)
 	}
+	return allResults, nil
+}
 
+// Each queries the enabled providers in parallel and sends each one's
+// results as soon as they arrive. The channel is closed once every
+// provider answered or the search timed out, and is buffered so callers
+// may stop reading early.
+func Each(ctx context.Context, chunks store.ChunkStore, cfg Config, scope Scope, query string, enableWeb, enableWiki, enableDDG, enablePKB bool) <-chan Batch {
+	type provider struct {
+		name string
+		// local providers are traced as internal spans, not client calls
+		local  bool
+		search func(ctx context.Context) ([]SearchResult, error)
+	}
+	var providers []provider
+	if enableWeb {
+		providers = append(providers, provider{name: ProviderSerpAPI, search: func(ctx context.Context) ([]SearchResult, error) {
+			return searchSerpApi(ctx, orDefault(cfg.SerpAPIURL, DefaultSerpAPIURL), cfg.SerpAPIKey, query)
+		}})
+	}
+	if enableDDG {
+		providers = append(providers, provider{name: ProviderDuckDuckGo, search: func(ctx context.Context) ([]SearchResult, error) {
+			return searchDuckDuckGo(ctx, orDefault(cfg.DuckDuckGoURL, DefaultDuckDuckGoURL), query)
+		}})
+	}
 	if enableWiki {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			res, err := searchWikipedia(ctx, query)
-			if err != nil {
-				errChan <- fmt.Errorf("Wiki: %v", err)
-				return
-			}
-			resultsChan <- res
-		}()
+		providers = append(providers, provider{name: ProviderWikipedia, search: func(ctx context.Context) ([]SearchResult, error) {
+			return searchWikipedia(ctx, orDefault(cfg.WikipediaURL, DefaultWikipediaURL), query)
+		}})
+	}
+	if enablePKB && !scope.empty() {
+		providers = append(providers, provider{name: ProviderPKB, local: true, search: func(ctx context.Context) ([]SearchResult, error) {
+			return searchPKB(ctx, chunks, cfg, scope, query)
+		}})
 	}
 
-	if enablePKB && userID != "" {
+	// We should enforce a timeout for search
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	out := make(chan Batch, len(providers))
+	var wg sync.WaitGroup
+	for _, p := range providers {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			res, err := searchPKB(ctx, client, userID, query)
-			if err != nil {
-				errChan <- fmt.Errorf("PKB: %v", err)
-				return
+			start := time.Now()
+			var span trace.Span
+			ctx := ctx
+			if p.local {
+				ctx, span = tracing.Start(ctx, "search."+p.name)
+			} else {
+				ctx, span = tracing.StartClient(ctx, "search."+p.name)
 			}
-			resultsChan <- res
+			res, err := p.search(ctx)
+			tracing.End(span, err)
+			metrics.ObserveProvider(p.name, start, err)
+			out <- Batch{Provider: p.name, Results: res, Err: err}
 		}()
 	}
-
-	// Wait in a separate goroutine to close channel
 	go func() {
 		wg.Wait()
-		close(resultsChan)
-		close(errChan)
-	}()
-
-	var allResults []SearchResult
-	for res := range resultsChan {
-		allResults = append(allResults, res...)
-	}
-
-	// Identify if any errors occurred (logging sake)
-	go func() {
-		for err := range errChan {
-			fmt.Printf("Search Error: %v\n", err)
-		}
+		cancel()
+		close(out)
 	}()
-
-	return allResults, nil
+	return out
 }
 
 // searchSerpApi uses the real SerpApi
-func searchSerpApi(ctx context.Context, query string) ([]SearchResult, error) {
-	apiKey := os.Getenv("SERPAPI_KEY")
+func searchSerpApi(ctx context.Context, endpoint, apiKey, query string) ([]SearchResult, error) {
 	if apiKey == "" {
 		return nil, fmt.Errorf("SERPAPI_KEY not set")
 	}
 
-	urlStr := fmt.Sprintf("https://serpapi.com/search.json?q=%s&api_key=%s", url.QueryEscape(query), apiKey)
+	urlStr := fmt.Sprintf("%s?q=%s&api_key=%s", endpoint, url.QueryEscape(query), apiKey)
 
 	req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+	logging.Propagate(req)
+	tracing.Inject(req)
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -131,7 +167,7 @@ func searchSerpApi(ctx context.Context, query string) ([]SearchResult, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		fmt.Printf("[SerpApi] Error Status: %s\n", resp.Status)
+		slog.WarnContext(ctx, "SerpApi error status", "status", resp.StatusCode)
 		return nil, fmt.Errorf("SerpApi failed with status: %s", resp.Status)
 	}
 
@@ -146,16 +182,16 @@ func searchSerpApi(ctx context.Context, query string) ([]SearchResult, error) {
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		fmt.Printf("[SerpApi] Decode Error: %v\n", err)
+		slog.WarnContext(ctx, "SerpApi decode error", "error", err)
 		return nil, err
 	}
 
 	if result.Error != "" {
-		fmt.Printf("[SerpApi] API returned error: %s\n", result.Error)
+		slog.WarnContext(ctx, "SerpApi returned error", "error", result.Error)
 		return nil, fmt.Errorf("SerpApi Error: %s", result.Error)
 	}
 
-	fmt.Printf("[SerpApi] Found %d results\n", len(result.OrganicResults))
+	slog.DebugContext(ctx, "SerpApi results", "count", len(result.OrganicResults))
 
 	var results []SearchResult
 	// Limit to top 3
@@ -173,12 +209,14 @@ func searchSerpApi(ctx context.Context, query string) ([]SearchResult, error) {
 	return results, nil
 }
 
-func searchDuckDuckGo(ctx context.Context, query string) ([]SearchResult, error) {
-	fmt.Printf("[DDG] Starting search for: %s\n", query)
+func searchDuckDuckGo(ctx context.Context, endpoint, query string) ([]SearchResult, error) {
+	slog.DebugContext(ctx, "DDG search", "query", logging.Redact(query))
 	// DDG Instant Answer API (Free)
-	urlStr := fmt.Sprintf("https://api.duckduckgo.com/?q=%s&format=json", url.QueryEscape(query))
+	urlStr := fmt.Sprintf("%s?q=%s&format=json", endpoint, url.QueryEscape(query))
 
 	req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+	logging.Propagate(req)
+	tracing.Inject(req)
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -265,11 +303,13 @@ func searchDuckDuckGo(ctx context.Context, query string) ([]SearchResult, error)
 	return results, nil
 }
 
-func searchWikipedia(ctx context.Context, query string) ([]SearchResult, error) {
+func searchWikipedia(ctx context.Context, endpoint, query string) ([]SearchResult, error) {
 	// Use action=query&list=search for meaningful snippets
-	urlStr := fmt.Sprintf("https://en.wikipedia.org/w/api.php?action=query&list=search&srsearch=%s&utf8=&format=json&srlimit=3", url.QueryEscape(query))
+	urlStr := fmt.Sprintf("%s?action=query&list=search&srsearch=%s&utf8=&format=json&srlimit=3", endpoint, url.QueryEscape(query))
 
 	req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+	logging.Propagate(req)
+	tracing.Inject(req)
 	req.Header.Set("User-Agent", "NexusSearch/1.0 (sanjay@example.com)")
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -5,28 +5,32 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"os"
-	"strings"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
+	"nexus-gateway/logging"
+	"nexus-gateway/metrics"
+	"nexus-gateway/store"
+	"nexus-gateway/tracing"
+	"nexus-gateway/workspace"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // Helper to get embedding from Python Worker
-func getEmbedding(query string) ([]float32, error) {
-	baseWorkerURL := strings.TrimRight(os.Getenv("WORKER_URL"), "/")
-	if baseWorkerURL == "" {
-		baseWorkerURL = "http://127.0.0.1:5000"
-	}
-	workerURL := baseWorkerURL + "/embed"
+func getEmbedding(ctx context.Context, workerURL, query string) (embedding []float32, err error) {
+	defer func(start time.Time) { metrics.ObserveWorker("embed", start, err) }(time.Now())
+	ctx, span := tracing.StartClient(ctx, "worker.embed")
+	defer func() { tracing.End(span, err) }()
+
 	payload := map[string]string{"text": query}
 	body, _ := json.Marshal(payload)
 
-	req, _ := http.NewRequest("POST", workerURL, bytes.NewBuffer(body))
+	req, _ := http.NewRequestWithContext(ctx, "POST", workerURL+"/embed", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
+	logging.Propagate(req)
+	tracing.Inject(req)
 
 	client := &http.Client{Timeout: 60 * time.Second}
 	resp, err := client.Do(req)
@@ -48,89 +52,121 @@ func getEmbedding(query string) ([]float32, error) {
 	return result.Embedding, nil
 }
 
-func searchPKB(ctx context.Context, client *mongo.Client, userID string, query string) ([]SearchResult, error) {
-	// 1. Get Query Vector
-	vector, err := getEmbedding(query)
-	if err != nil {
-		return nil, fmt.Errorf("embedding gen failed: %v", err)
+// Scope describes which PKB chunks a search may read: the user's own uploads
+// and/or the documents of the given workspaces. A DocumentID (used for share
+// links) restricts the search to that single document instead.
+type Scope struct {
+	UserID       string
+	WorkspaceIDs []string
+	DocumentID   string
+}
+
+func (s Scope) empty() bool {
+	return s.UserID == "" && len(s.WorkspaceIDs) == 0 && s.DocumentID == ""
+}
+
+// UserScope returns the PKB scope of a search by username: a single
+// workspace if workspaceID is set, which the user must belong to
+// (workspace.ErrNotMember otherwise), or else their own documents plus,
+// for PKB searches, every workspace they belong to. It also returns the
+// user's ID, empty if the user could not be found.
+func UserScope(ctx context.Context, db store.Store, username, workspaceID string, pkb bool) (Scope, string, error) {
+	userID := ""
+	if username != "" {
+		id, err := GetUserID(ctx, db, username)
+		if err == nil {
+			userID = id
+		} else {
+			slog.WarnContext(ctx, "Error resolving UserID", "username", logging.Redact(username), "error", err)
+		}
+	} else {
+		slog.WarnContext(ctx, "Search without a username")
+	}
+
+	scope := Scope{}
+	if workspaceID != "" {
+		if _, err := workspace.MemberRole(ctx, db, workspaceID, userID); err != nil {
+			return scope, userID, workspace.ErrNotMember
+		}
+		scope.WorkspaceIDs = []string{workspaceID}
+	} else if userID != "" {
+		scope.UserID = userID
+		if pkb {
+			ids, err := workspace.ReadableIDs(ctx, db, userID)
+			if err != nil {
+				slog.WarnContext(ctx, "Error loading workspaces", "user_id", logging.Redact(userID), "error", err)
+			}
+			scope.WorkspaceIDs = ids
+		}
 	}
+	return scope, userID, nil
+}
 
-	// 2. Vector Search in MongoDB
-	collection := client.Database("nexus_search").Collection("docs")
+// query turns the scope into a store query for the chunks nearest to vector
+func (s Scope) query(vector []float32) (store.ChunkQuery, error) {
+	q := store.ChunkQuery{Vector: vector, Limit: 5}
+	if s.DocumentID != "" {
+		docOID, err := primitive.ObjectIDFromHex(s.DocumentID)
+		if err != nil {
+			return q, fmt.Errorf("invalid document id: %v", err)
+		}
+		q.DocumentID = &docOID
+		return q, nil
+	}
 
-	userOID, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		fmt.Printf("[PKB] Invalid UserID: %v\n", err)
-		return nil, fmt.Errorf("invalid user id: %v", err)
+	if s.UserID != "" {
+		userOID, err := primitive.ObjectIDFromHex(s.UserID)
+		if err != nil {
+			return q, fmt.Errorf("invalid user id: %v", err)
+		}
+		q.UserID = &userOID
 	}
+	for _, id := range s.WorkspaceIDs {
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return q, fmt.Errorf("invalid workspace id: %v", err)
+		}
+		q.WorkspaceIDs = append(q.WorkspaceIDs, oid)
+	}
+	if q.UserID == nil && len(q.WorkspaceIDs) == 0 {
+		return q, fmt.Errorf("empty search scope")
+	}
+	return q, nil
+}
 
-	fmt.Printf("[PKB] Searching for UserOID: %s, Query: %s\n", userOID.Hex(), query)
-	fmt.Printf("[PKB] Query Vector Length: %d\n", len(vector))
-
-	// Vector Search Pipeline
-	pipeline := []bson.M{
-		{
-			"$vectorSearch": bson.M{
-				"index":         "vector_index",
-				"path":          "embedding",
-				"queryVector":   vector,
-				"numCandidates": 100,
-				"limit":         5,
-				"filter":        bson.M{"user_id": userOID},
-			},
-		},
-		{
-			"$project": bson.M{
-				"_id":      0,
-				"filename": 1,
-				"content":  1,
-				"score":    bson.M{"$meta": "vectorSearchScore"},
-			},
-		},
+func searchPKB(ctx context.Context, chunks store.ChunkStore, cfg Config, scope Scope, query string) ([]SearchResult, error) {
+	// 1. Get Query Vector
+	vector, err := getEmbedding(ctx, cfg.WorkerURL, query)
+	if err != nil {
+		return nil, fmt.Errorf("embedding gen failed: %v", err)
 	}
 
-	// BUT wait, MongoDB Go driver might treat $vectorSearch differently or use aggregate
-	// Yes, aggregate is correct.
-
-	// Does 'user_id' in filter need ObjectId?
-	// In storage.py we saved it as ObjectId.
-	// We expect userID string here, so we might need to convert?
-	// No, if we passed string in $match or $vectorSearch filter, Atlas Search usually handles types well
-	// BUT standard Mongo strictness applies.
-	// However, for simplicity let's assume the string match works or we need to pass ObjectId.
-	// The previous code in `upload.go` returned `userID` as hex string.
-	// We should probably convert to ObjectID for the filter if we saved as ObjectID.
-	// Let's rely on string for now, but mark TODO if no results.
-	// Actually, `storage.py` does `ObjectId(user_id)`.
-	// So we MUST query with ObjectId if we want to match.
-	// But we don't have the `primitive` package imported easily in this snippet?
-	// Let's try to query without filter first? No, security risk.
-	// We need to import "go.mongodb.org/mongo-driver/bson/primitive"
-
-	// We'll fix imports later. This is logic.
-
-	cursor, err := collection.Aggregate(ctx, pipeline)
+	q, err := scope.query(vector)
 	if err != nil {
+		slog.WarnContext(ctx, "invalid PKB scope", "error", err)
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	var mongoResults []struct {
-		Filename string  `bson:"filename"`
-		Content  string  `bson:"content"`
-		Score    float64 `bson:"score"`
-	}
-	if err := cursor.All(ctx, &mongoResults); err != nil {
+	slog.DebugContext(ctx, "PKB search",
+		"user_id", logging.Redact(scope.UserID),
+		"workspaces", len(scope.WorkspaceIDs),
+		"document_id", scope.DocumentID,
+		"query", logging.Redact(query),
+		"vector_len", len(vector))
+
+	// 2. Vector search over the chunks in scope
+	hits, err := chunks.SearchChunks(ctx, q)
+	if err != nil {
 		return nil, err
 	}
 
 	// 3. Convert to SearchResult
-	var results []SearchResult
-	for _, res := range mongoResults {
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
 		results = append(results, SearchResult{
-			Source:  "PKB (" + res.Filename + ")",
-			Title:   res.Filename,
-			Snippet: res.Content, // Maybe truncate?
+			Source:  "PKB (" + hit.Filename + ")",
+			Title:   hit.Filename,
+			Snippet: hit.Content, // Maybe truncate?
 			URL:     "#",         // No URL for local files
 		})
 	}
@@ -0,0 +1,109 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/documents"
+	"nexus-gateway/store"
+)
+
+// SharedChunk is a chunk of a shared document as shown to the recipient
+type SharedChunk struct {
+	ChunkIndex int    `json:"chunk_index"`
+	Content    string `json:"content"`
+}
+
+type SharedDocumentResponse struct {
+	Document documents.Document `json:"document"`
+	Chunks   []SharedChunk      `json:"chunks"`
+}
+
+// redeem validates the ?token= share link and writes the error response
+// when it is not usable
+func redeem(w http.ResponseWriter, r *http.Request, db store.Store, secret string) *documents.Document {
+	_, doc, err := documents.RedeemShare(r.Context(), db, secret, r.URL.Query().Get("token"))
+	switch err {
+	case nil:
+		return doc
+	case documents.ErrInvalidShare:
+		apierror.NotFound(w, r, "Invalid share link")
+	case documents.ErrShareUnavailable:
+		apierror.Respond(w, r, http.StatusGone, apierror.CodeGone, "Share link expired or revoked")
+	default:
+		apierror.Internal(w, r, "Server error", err)
+	}
+	return nil
+}
+
+// SharedSearchHandler runs a PKB search limited to the document behind a
+// share link (?token=&q=). Each call counts as one use of the link.
+func SharedSearchHandler(db store.Store, secret string, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+		query := r.URL.Query().Get("q")
+		if query == "" {
+			apierror.BadRequest(w, r, "Query required")
+			return
+		}
+
+		doc := redeem(w, r, db, secret)
+		if doc == nil {
+			return
+		}
+
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		results, err := searchPKB(ctx, db, cfg, Scope{DocumentID: doc.ID.Hex()}, query)
+		if err != nil {
+			apierror.Internal(w, r, "Search failed", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SearchResponse{
+			Results:     results,
+			TimeTakenMs: time.Since(start).Milliseconds(),
+		})
+	}
+}
+
+// SharedChunksHandler returns the metadata and text chunks of the document
+// behind a share link (?token=). Each call counts as one use of the link.
+func SharedChunksHandler(db store.Store, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+
+		doc := redeem(w, r, db, secret)
+		if doc == nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		stored, err := db.ChunksByDocument(ctx, doc.ID)
+		if err != nil {
+			apierror.Internal(w, r, "Failed to load document", err)
+			return
+		}
+		chunks := make([]SharedChunk, 0, len(stored))
+		for _, c := range stored {
+			chunks = append(chunks, SharedChunk{ChunkIndex: c.ChunkIndex, Content: c.Content})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SharedDocumentResponse{Document: *doc, Chunks: chunks})
+	}
+}
@@ -3,98 +3,225 @@ package search
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
-	"os"
-	"strings"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"nexus-gateway/apierror"
+	"nexus-gateway/audit"
+	"nexus-gateway/documents"
+	"nexus-gateway/logging"
+	"nexus-gateway/metrics"
+	"nexus-gateway/store"
+	"nexus-gateway/tracing"
+	"nexus-gateway/workspace"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // Helper to get UserID (in a real app, this might be cached or in JWT)
-func GetUserID(client *mongo.Client, username string) (string, error) {
-	collection := client.Database("nexus_search").Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func GetUserID(ctx context.Context, users store.UserStore, username string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var result struct {
-		ID interface{} `bson:"_id"`
-	}
-	// Depending on how _id is stored (ObjectId or string). Mgo driver handles it.
-	err := collection.FindOne(ctx, bson.M{"username": username}).Decode(&result)
+	user, err := users.UserByName(ctx, username)
 	if err != nil {
 		return "", err
 	}
+	return user.ID.Hex(), nil
+}
+
+// UploadResponse is the worker's /process result plus the ID of the new
+// document
+type UploadResponse struct {
+	Status     string `json:"status"`
+	Chunks     int    `json:"chunks"`
+	Size       int64  `json:"size"`
+	DocumentID string `json:"document_id"`
+}
+
+// Upload is a file to add to a user's knowledge base
+type Upload struct {
+	UserID   string
+	Filename string
+	File     io.Reader
+	// WorkspaceID optionally stores the document in a workspace the user
+	// can write to
+	WorkspaceID string
+}
+
+// Process sends an upload to the worker and records the new document.
+// Failures meant for the client are *apierror.Error; who is recorded in
+// the audit log.
+func Process(ctx context.Context, db store.Store, cfg Config, u Upload, who audit.Event) (*UploadResponse, error) {
+	record := func(outcome string, details map[string]interface{}) {
+		e := who
+		e.Action, e.Target, e.Outcome, e.Details = "document.upload", u.Filename, outcome, details
+		audit.Record(ctx, db, e)
+	}
+
+	var workspaceOID *primitive.ObjectID
+	if u.WorkspaceID != "" {
+		role, err := workspace.MemberRole(ctx, db, u.WorkspaceID, u.UserID)
+		if err == workspace.ErrNotMember {
+			return nil, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Workspace not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !workspace.CanWrite(role) {
+			record(audit.OutcomeDenied, map[string]interface{}{"workspace_id": u.WorkspaceID})
+			return nil, apierror.New(http.StatusForbidden, apierror.CodeForbidden, "Forbidden")
+		}
+		oid, _ := primitive.ObjectIDFromHex(u.WorkspaceID)
+		workspaceOID = &oid
+	}
+	documentID := primitive.NewObjectID()
+
+	// Prepare Request to Python Worker
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	// Add File
+	part, err := writer.CreateFormFile("file", u.Filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, u.File); err != nil {
+		return nil, err
+	}
+
+	// Add User ID and document fields
+	writer.WriteField("user_id", u.UserID)
+	writer.WriteField("document_id", documentID.Hex())
+	if workspaceOID != nil {
+		writer.WriteField("workspace_id", workspaceOID.Hex())
+	}
+
+	writer.Close()
+
+	// Send to Worker
+	workerURL := cfg.WorkerURL + "/process"
+	req, err := http.NewRequestWithContext(ctx, "POST", workerURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	logging.Propagate(req)
+
+	clientHTTP := &http.Client{Timeout: 120 * time.Second} // Increased to 120s for local embedding latency
+	workerStart := time.Now()
+	spanCtx, span := tracing.StartClient(ctx, "worker.process")
+	req = req.WithContext(spanCtx)
+	tracing.Inject(req)
+	resp, err := clientHTTP.Do(req)
+	workerErr := err
+	if err == nil && resp.StatusCode != http.StatusOK {
+		workerErr = errors.New(resp.Status)
+	}
+	tracing.End(span, workerErr)
+	metrics.ObserveWorker("process", workerStart, workerErr)
+	if err != nil {
+		record(audit.OutcomeFailure, map[string]interface{}{"error": err.Error()})
+		slog.ErrorContext(ctx, "Worker request failed", "error", err)
+		return nil, apierror.New(http.StatusBadGateway, apierror.CodeUpstream, "Document processing is unavailable, try again later")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		record(audit.OutcomeFailure, map[string]interface{}{"worker_status": resp.StatusCode})
+		return nil, workerError(ctx, resp)
+	}
 
-	// Convert ObjectID to hex string if needed, or just string
-	if oid, ok := result.ID.(interface{ Hex() string }); ok {
-		return oid.Hex(), nil
+	var result UploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, apierror.New(http.StatusBadGateway, apierror.CodeUpstream, "Document processing failed")
 	}
-	return result.ID.(string), nil
+
+	// Record document metadata
+	userOID, _ := primitive.ObjectIDFromHex(u.UserID)
+	doc := documents.Document{
+		ID:          documentID,
+		UserID:      userOID,
+		WorkspaceID: workspaceOID,
+		Filename:    u.Filename,
+		SizeBytes:   result.Size,
+		Chunks:      result.Chunks,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := documents.Insert(ctx, db, doc); err != nil {
+		slog.ErrorContext(ctx, "failed to record document", "document_id", documentID.Hex(), "error", err)
+	}
+
+	record(audit.OutcomeSuccess, map[string]interface{}{
+		"document_id":  documentID.Hex(),
+		"workspace_id": workspaceOID,
+		"size_bytes":   doc.SizeBytes,
+	})
+
+	metrics.UploadBytes.Add(float64(doc.SizeBytes))
+
+	result.DocumentID = documentID.Hex()
+	return &result, nil
 }
 
-func UploadProxyHandler(client *mongo.Client) http.HandlerFunc {
+// UploadProxyHandler takes a multipart upload with the file and an
+// optional workspace field, see Process
+func UploadProxyHandler(db store.Store, cfg Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// 1. Get User ID
 		username := r.Context().Value("user").(string)
-		userID, err := GetUserID(client, username)
+		userID, err := GetUserID(r.Context(), db, username)
 		if err != nil {
-			http.Error(w, "User not found", http.StatusUnauthorized)
+			apierror.Unauthorized(w, r, "User not found")
 			return
 		}
 
-		// 2. Read File from Request
 		file, header, err := r.FormFile("file")
 		if err != nil {
-			http.Error(w, "Invalid file", http.StatusBadRequest)
+			apierror.BadRequest(w, r, "Invalid file")
 			return
 		}
 		defer file.Close()
 
-		// 3. Prepare Request to Python Worker
-		body := &bytes.Buffer{}
-		writer := multipart.NewWriter(body)
-
-		// Add File
-		part, err := writer.CreateFormFile("file", header.Filename)
-		if err != nil {
-			http.Error(w, "Processing error", http.StatusInternalServerError)
+		// Optional target workspace (form field or query parameter)
+		result, err := Process(r.Context(), db, cfg, Upload{
+			UserID:      userID,
+			Filename:    header.Filename,
+			File:        file,
+			WorkspaceID: r.FormValue("workspace"),
+		}, audit.Requester(r))
+		if e, ok := err.(*apierror.Error); ok {
+			apierror.Write(w, r, e)
 			return
 		}
-		io.Copy(part, file)
-
-		// Add User ID field
-		writer.WriteField("user_id", userID)
-
-		writer.Close()
-
-		// 4. Send to Worker
-		baseWorkerURL := strings.TrimRight(os.Getenv("WORKER_URL"), "/")
-		if baseWorkerURL == "" {
-			baseWorkerURL = "http://127.0.0.1:5000"
-		}
-		workerURL := baseWorkerURL + "/process"
-		req, err := http.NewRequest("POST", workerURL, body)
 		if err != nil {
-			http.Error(w, "Worker unreachable: "+err.Error(), http.StatusBadGateway)
+			apierror.Internal(w, r, "Processing error", err)
 			return
 		}
-		req.Header.Set("Content-Type", writer.FormDataContentType())
 
-		clientHTTP := &http.Client{Timeout: 120 * time.Second} // Increased to 120s for local embedding latency
-		resp, err := clientHTTP.Do(req)
-		if err != nil {
-			// This is the error the user saw. Now we include the actual error message.
-			http.Error(w, "Worker failed: "+err.Error(), http.StatusBadGateway)
-			return
-		}
-		defer resp.Body.Close()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// workerError turns a failed /process response into an error for the
+// client. The worker's own message can contain internals, so it is only
+// logged.
+func workerError(ctx context.Context, resp *http.Response) *apierror.Error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	slog.WarnContext(ctx, "Worker rejected upload", "status", resp.StatusCode, "body", string(body))
 
-		// 5. Return Worker Response
-		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		// The worker refuses uploads that would go over the quota
+		return apierror.New(http.StatusForbidden, apierror.CodeQuotaExceeded, "Storage quota exceeded")
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "The file could not be processed")
+	default:
+		return apierror.New(http.StatusBadGateway, apierror.CodeUpstream, "Document processing failed")
 	}
 }
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"nexus-gateway/account"
+	"nexus-gateway/admin"
+	"nexus-gateway/audit"
+	"nexus-gateway/auth"
+	"nexus-gateway/documents"
+	"nexus-gateway/health"
+	"nexus-gateway/logging"
+	"nexus-gateway/openapi"
+	"nexus-gateway/search"
+	"nexus-gateway/workspace"
+)
+
+// newSpec returns the API description without operations; New adds them
+// from operations as it registers the routes
+func newSpec() *openapi.Document {
+	spec := openapi.New(openapi.Info{
+		Title:   "Nexus Search API",
+		Version: "1.0.0",
+		Description: "Hybrid web and personal knowledge base search. The unversioned /api paths " +
+			"are deprecated aliases of /api/v1 and are not described here. Errors use the " +
+			"Envelope schema; clients should branch on error.code.",
+	})
+	spec.SetAuth(map[string]openapi.SecurityScheme{
+		"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "The token returned by login"},
+		"cookieAuth": {Type: "apiKey", In: "cookie", Name: auth.TokenCookie, Description: "Set by login. Writes also need the " + auth.CSRFHeader + " header."},
+	})
+	return spec
+}
+
+// auditFilter are the query parameters of the audit log endpoints
+var auditFilter = []openapi.Param{
+	{Name: "actor"},
+	{Name: "action"},
+	{Name: "target"},
+	{Name: "outcome"},
+	{Name: "ip"},
+	{Name: "since", Schema: openapi.DateTime()},
+	{Name: "until", Schema: openapi.DateTime()},
+	{Name: "limit", Schema: openapi.Integer().Min(0)},
+}
+
+// operations documents every route, keyed by its ServeMux pattern. New
+// fails if a route is missing here or an entry has no route.
+func operations() map[string]openapi.Op {
+	return map[string]openapi.Op{
+		"POST /api/v1/register": {
+			ID: "register", Tag: "auth", Summary: "Create an account", Public: true,
+			Body: auth.Credentials{}, Status: 201,
+		},
+		"POST /api/v1/login": {
+			ID: "login", Tag: "auth", Summary: "Start a session", Public: true,
+			Description: "Returns the session token and also sets it as an HttpOnly cookie.",
+			Body:        auth.Credentials{}, Response: auth.LoginResponse{},
+		},
+
+		"GET /api/v1/user": {
+			ID: "getProfile", Tag: "account", Summary: "The caller's profile and storage use",
+			Response: auth.User{},
+		},
+		"DELETE /api/v1/user": {
+			ID: "deleteAccount", Tag: "account", Summary: "Schedule the caller's account for deletion",
+			Description: "Logging in before scheduled_for cancels the deletion.",
+			Status:      202, Response: account.DeletionResponse{},
+		},
+		"GET /api/v1/user/export": {
+			ID: "exportAccount", Tag: "account", Summary: "Download all of the caller's data as a zip file",
+			Response: openapi.Binary(), ResponseType: "application/zip",
+		},
+
+		"GET /api/v1/search": {
+			ID: "search", Tag: "search", Summary: "Search the web and the personal knowledge base",
+			Params: []openapi.Param{
+				{Name: "q", Required: true, Description: "The query"},
+				{Name: "web", Schema: openapi.Boolean(), Description: "Include Google results (SerpApi)"},
+				{Name: "wiki", Schema: openapi.Boolean(), Description: "Include Wikipedia"},
+				{Name: "ddg", Schema: openapi.Boolean(), Description: "Include DuckDuckGo"},
+				{Name: "pkb", Schema: openapi.Boolean(), Description: "Include uploaded documents"},
+				{Name: "workspace", Schema: openapi.ObjectID(), Description: "Search only this workspace's documents"},
+			},
+			Response: search.SearchResponse{},
+		},
+		"POST /api/v1/upload": {
+			ID: "upload", Tag: "documents", Summary: "Upload a document into the knowledge base",
+			Form: openapi.Object(map[string]*openapi.Schema{
+				"file":      openapi.Binary(),
+				"workspace": openapi.ObjectID(),
+			}, "file"),
+			Response: search.UploadResponse{},
+		},
+
+		"GET /api/v1/workspaces": {
+			ID: "listWorkspaces", Tag: "workspaces", Summary: "The workspaces the caller belongs to",
+			Response: []workspace.Workspace{},
+		},
+		"POST /api/v1/workspaces": {
+			ID: "createWorkspace", Tag: "workspaces", Summary: "Create a workspace owned by the caller",
+			Body: workspace.CreateRequest{}, Status: 201, Response: workspace.Workspace{},
+		},
+		"GET /api/v1/workspaces/{workspace}/members": {
+			ID: "listMembers", Tag: "workspaces", Summary: "List a workspace's members",
+			Params:   []openapi.Param{{Name: "workspace", Schema: openapi.ObjectID()}},
+			Response: []workspace.Member{},
+		},
+		"POST /api/v1/workspaces/{workspace}/members": {
+			ID: "setMember", Tag: "workspaces", Summary: "Add a member or change their role (owners only)",
+			Params: []openapi.Param{{Name: "workspace", Schema: openapi.ObjectID()}},
+			Body:   workspace.MemberRequest{}, Response: workspace.Member{},
+		},
+		"DELETE /api/v1/workspaces/{workspace}/members/{username}": {
+			ID: "removeMember", Tag: "workspaces", Summary: "Remove a member (owners, or members removing themselves)",
+			Params: []openapi.Param{{Name: "workspace", Schema: openapi.ObjectID()}},
+			Status: 204,
+		},
+
+		"GET /api/v1/documents": {
+			ID: "listDocuments", Tag: "documents", Summary: "The caller's documents with their active share links",
+			Response: []documents.Listing{},
+		},
+		"DELETE /api/v1/documents/{document}": {
+			ID: "deleteDocument", Tag: "documents", Summary: "Delete one of the caller's documents and free its storage",
+			Params: []openapi.Param{{Name: "document", Schema: openapi.ObjectID()}},
+			Status: 204,
+		},
+		"POST /api/v1/documents/{document}/shares": {
+			ID: "createShare", Tag: "documents", Summary: "Create a share link for a document",
+			Params: []openapi.Param{{Name: "document", Schema: openapi.ObjectID()}},
+			Body:   documents.ShareRequest{}, BodyOptional: true,
+			Status: 201, Response: documents.ShareResponse{},
+		},
+		"DELETE /api/v1/shares/{id}": {
+			ID: "revokeShare", Tag: "documents", Summary: "Revoke a share link",
+			Params: []openapi.Param{{Name: "id", Schema: openapi.ObjectID()}},
+			Status: 204,
+		},
+		"GET /api/v1/shared/search": {
+			ID: "sharedSearch", Tag: "documents", Summary: "Search the document behind a share link",
+			Params: []openapi.Param{
+				{Name: "token", Required: true, Description: "The share link token"},
+				{Name: "q", Required: true, Description: "The query"},
+			},
+			Response: search.SearchResponse{},
+		},
+		"GET /api/v1/shared/chunks": {
+			ID: "sharedChunks", Tag: "documents", Summary: "Read the document behind a share link",
+			Params:   []openapi.Param{{Name: "token", Required: true, Description: "The share link token"}},
+			Response: search.SharedDocumentResponse{},
+		},
+
+		"GET /api/v1/admin/users": {
+			ID: "listUsers", Tag: "admin", Summary: "List users",
+			Params: []openapi.Param{
+				{Name: "limit", Schema: openapi.Integer().Min(1).Max(500)},
+				{Name: "skip", Schema: openapi.Integer().Min(0)},
+			},
+			Response: []auth.User{},
+		},
+		"GET /api/v1/admin/usage": {
+			ID: "usage", Tag: "admin", Summary: "Storage and document counts per user",
+			Params:   []openapi.Param{{Name: "username", Description: "Only report this user"}},
+			Response: []admin.UserUsage{},
+		},
+		"POST /api/v1/admin/users/{username}/quota": {
+			ID: "setQuota", Tag: "admin", Summary: "Change a user's quota and/or plan",
+			Body: admin.QuotaRequest{}, Response: auth.User{},
+		},
+		"POST /api/v1/admin/users/{username}/role": {
+			ID: "setRole", Tag: "admin", Summary: "Change a user's role and end their sessions",
+			Body: admin.RoleRequest{}, Response: auth.User{},
+		},
+		"POST /api/v1/admin/users/{username}/disable": {
+			ID: "disableUser", Tag: "admin", Summary: "Disable an account and end its sessions",
+			Response: auth.User{},
+		},
+		"POST /api/v1/admin/users/{username}/enable": {
+			ID: "enableUser", Tag: "admin", Summary: "Re-enable a disabled account",
+			Response: auth.User{},
+		},
+		"POST /api/v1/admin/users/{username}/logout": {
+			ID: "logoutUser", Tag: "admin", Summary: "End all of a user's sessions",
+			Response: auth.User{},
+		},
+		"GET /api/v1/admin/audit": {
+			ID: "queryAudit", Tag: "admin", Summary: "Search the audit log, newest first",
+			Params: auditFilter, Response: []audit.Event{},
+		},
+		"GET /api/v1/admin/audit/export": {
+			ID: "exportAudit", Tag: "admin", Summary: "Export the audit log as JSON Lines, oldest first",
+			Params: auditFilter, Response: openapi.String(), ResponseType: "application/x-ndjson",
+		},
+		"GET /api/v1/admin/loglevel": {
+			ID: "getLogLevel", Tag: "admin", Summary: "The current log level",
+			Response: logging.LevelSetting{},
+		},
+		"PUT /api/v1/admin/loglevel": {
+			ID: "setLogLevel", Tag: "admin", Summary: "Change the log level until restart",
+			Body: logging.LevelSetting{}, Response: logging.LevelSetting{},
+		},
+
+		"GET /api/v1/status": {
+			ID: "status", Tag: "status", Summary: "State and latency of every dependency",
+			Description: "Error details are only shown to admins.",
+			Response:    health.StatusResponse{},
+		},
+		"GET /api/v1/openapi.json": {
+			ID: "openapi", Tag: "status", Summary: "This document", Public: true,
+			Response: &openapi.Schema{Type: "object"},
+		},
+		"GET /metrics": {
+			ID: "metrics", Tag: "status", Summary: "Prometheus metrics", Public: true,
+			Description: "Needs Authorization: Bearer <METRICS_TOKEN> when a metrics token is configured.",
+			Response:    openapi.String(), ResponseType: "text/plain",
+		},
+		"GET /healthz": {
+			ID: "liveness", Tag: "status", Summary: "Whether the process is up", Public: true,
+			Response: health.ReadinessResponse{},
+		},
+		"GET /readyz": {
+			ID: "readiness", Tag: "status", Summary: "Whether every dependency is reachable", Public: true,
+			Response: health.ReadinessResponse{}, Also: map[int]interface{}{503: health.ReadinessResponse{}},
+		},
+	}
+}
@@ -0,0 +1,299 @@
+// Package server builds the gateway's HTTP handler chain
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"nexus-gateway/account"
+	"nexus-gateway/admin"
+	"nexus-gateway/apierror"
+	"nexus-gateway/audit"
+	"nexus-gateway/auth"
+	"nexus-gateway/clientip"
+	"nexus-gateway/config"
+	"nexus-gateway/documents"
+	"nexus-gateway/health"
+	"nexus-gateway/logging"
+	"nexus-gateway/metrics"
+	"nexus-gateway/middleware"
+	"nexus-gateway/search"
+	"nexus-gateway/store"
+	"nexus-gateway/workspace"
+)
+
+// APIPrefix is where the current version of the API is served
+const APIPrefix = "/api/v1"
+
+// Options is what the handler chain is built from. main wires it to MongoDB
+// and the real worker; the end-to-end tests use memstore and fakes.
+type Options struct {
+	Config  *config.Config
+	Store   store.Store
+	Limiter middleware.LimiterStore
+	// Checks back /readyz and /api/v1/status
+	Checks []health.Check
+	// RunJob starts a background job that runs until shutdown
+	RunJob func(job func(ctx context.Context))
+}
+
+// SearchConfig is the part of cfg that searches and uploads need
+func SearchConfig(cfg *config.Config) search.Config {
+	return search.Config{
+		WorkerURL:     cfg.WorkerURL,
+		SerpAPIKey:    cfg.SerpAPIKey,
+		SerpAPIURL:    cfg.Providers.SerpAPIURL,
+		DuckDuckGoURL: cfg.Providers.DuckDuckGoURL,
+		WikipediaURL:  cfg.Providers.WikipediaURL,
+	}
+}
+
+// RateLimiter builds the limiter for cfg's budgets on store. The REST and
+// gRPC APIs share their counters by using the same store.
+func RateLimiter(cfg *config.Config, store middleware.LimiterStore) *middleware.RateLimiter {
+	budgets := make(map[string]middleware.Budget)
+	for class, b := range cfg.RateLimit.Budgets {
+		budgets[class] = middleware.Budget{Rate: b.Rate, Burst: b.Burst}
+	}
+	return middleware.NewRateLimiter(cfg.JWTSecret, store, budgets, cfg.RateLimit.Plans)
+}
+
+// New builds every route with its rate limits and auth, wrapped in the
+// global middleware
+func New(o Options) (http.Handler, error) {
+	cfg, db := o.Config, o.Store
+	cookies := auth.NewCookieOptions(cfg.Cookies.SameSite, cfg.Cookies.Secure)
+
+	searchCfg := SearchConfig(cfg)
+
+	// Search Handler
+	searchHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("q")
+		if query == "" {
+			apierror.BadRequest(w, r, "Query required")
+			return
+		}
+
+		// Toggles
+		web := r.URL.Query().Get("web") == "true"
+		wiki := r.URL.Query().Get("wiki") == "true"
+		ddg := r.URL.Query().Get("ddg") == "true"
+		pkb := r.URL.Query().Get("pkb") == "true"
+
+		// Auth puts the username in the context; the PKB search filters on
+		// the user's ID, which UserScope looks up
+		username, _ := r.Context().Value("user").(string)
+
+		start := time.Now()
+
+		// Resolve ID and the PKB scope
+		scope, userID, err := search.UserScope(r.Context(), db, username, r.URL.Query().Get("workspace"), pkb)
+		if err != nil {
+			apierror.NotFound(w, r, "Workspace not found")
+			return
+		}
+
+		slog.InfoContext(r.Context(), "Search",
+			"query", logging.Redact(query),
+			"web", web, "wiki", wiki, "ddg", ddg, "pkb", pkb,
+			"user_id", logging.Redact(userID),
+			"workspaces", len(scope.WorkspaceIDs))
+
+		results, err := search.Orchestrator(r.Context(), db, searchCfg, scope, query, web, wiki, ddg, pkb)
+		if err != nil {
+			apierror.Internal(w, r, "Search failed", err)
+			return
+		}
+
+		resp := search.SearchResponse{
+			Results:     results,
+			TimeTakenMs: time.Since(start).Milliseconds(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	jwtSecret := cfg.JWTSecret
+
+	limiter := RateLimiter(cfg, o.Limiter)
+	limit := func(h http.Handler, class middleware.Classifier) http.Handler {
+		return limiter.Limit(h, class)
+	}
+	defaultClass := middleware.Class(middleware.ClassDefault)
+
+	finalMux := http.NewServeMux()
+	// handle registers h under a method pattern and records request metrics
+	// and a trace span under its path
+	handle := func(pattern string, h http.Handler) {
+		_, route, _ := strings.Cut(pattern, " ")
+		finalMux.Handle(pattern, middleware.Metrics(middleware.Trace(h, route), route))
+	}
+
+	// The OpenAPI spec is built from operations as routes are registered.
+	// document moves a route's entry into the spec; anything left over on
+	// either side means the two have drifted.
+	spec := newSpec()
+	ops := operations()
+	var undocumented []string
+	document := func(pattern string) bool {
+		op, ok := ops[pattern]
+		if !ok {
+			undocumented = append(undocumented, pattern)
+			return false
+		}
+		delete(ops, pattern)
+		method, path, _ := strings.Cut(pattern, " ")
+		spec.Add(method, path, op)
+		return true
+	}
+
+	// A guard wraps a handler in its rate limit and authentication
+	type guard = func(http.Handler) http.Handler
+	public := func(h http.Handler) http.Handler { return h }
+
+	// route serves h at method /api/v1+path behind guard, validating
+	// requests against the spec inside the guard so that callers are
+	// authenticated and charged before their request is looked at. legacy
+	// is the unversioned path the endpoint used to have, which keeps working
+	// unvalidated but is marked deprecated. Requests with other methods get
+	// a 405 from the mux.
+	route := func(method, path, legacy string, guard guard, h http.Handler) {
+		v1 := h
+		if document(method + " " + APIPrefix + path) {
+			v1 = spec.Validate(method, APIPrefix+path, h)
+		}
+		handle(method+" "+APIPrefix+path, guard(v1))
+		if legacy != "" {
+			successor := ""
+			if !strings.Contains(path, "{") {
+				successor = APIPrefix + path
+			}
+			handle(method+" "+legacy, middleware.Deprecated(guard(h), successor))
+		}
+	}
+
+	limitedAs := func(class middleware.Classifier) guard {
+		return func(h http.Handler) http.Handler { return limit(h, class) }
+	}
+	loginLimited := limitedAs(middleware.Class(middleware.ClassLogin))
+	route("POST", "/login", "/api/login", loginLimited, auth.LoginHandler(db, jwtSecret, cookies))
+	route("POST", "/register", "/api/register", loginLimited, auth.RegisterHandler(db, cfg.AdminUsers))
+
+	authOpts := []middleware.AuthOption{
+		middleware.WithSessionCheck(auth.ValidateSession(db)),
+		middleware.WithFailureHook(func(r *http.Request, username, reason string) {
+			audit.RecordRequest(r, db, audit.Event{
+				Actor:   username,
+				Action:  "auth.token",
+				Target:  r.URL.Path,
+				Outcome: audit.OutcomeDenied,
+				Details: map[string]interface{}{"reason": reason},
+			})
+		}),
+	}
+	// Cookie-authenticated writes additionally need a valid CSRF token
+	protectedAs := func(class middleware.Classifier) guard {
+		return func(h http.Handler) http.Handler {
+			return limit(middleware.CSRF(middleware.Auth(h, jwtSecret, authOpts...), jwtSecret), class)
+		}
+	}
+	protected := protectedAs(defaultClass)
+	adminOnly := func(h http.Handler) http.Handler {
+		return protected(middleware.RequireRole(h, auth.RoleAdmin))
+	}
+
+	// User Profile and account lifecycle
+	route("GET", "/user", "/api/user", protected, auth.GetProfileHandler(db))
+	route("DELETE", "/user", "/api/user", protected, account.DeleteHandler(db, cfg.AccountDeletionGrace, cookies))
+	route("GET", "/user/export", "/api/user/export", protected, account.ExportHandler(db))
+
+	// Remove accounts whose deletion grace period has passed
+	o.RunJob(func(ctx context.Context) { account.RunPurgeJob(ctx, db, time.Hour) })
+
+	// Search and Upload are protected
+	route("GET", "/search", "/api/search", protectedAs(middleware.SearchClass), searchHandler)
+
+	// Upload with content-length check
+	upload := middleware.StorageCheck(search.UploadProxyHandler(db, searchCfg))
+	route("POST", "/upload", "/api/upload", protectedAs(middleware.Class(middleware.ClassUpload)), upload)
+
+	// Shared workspaces. The old member routes took the workspace and
+	// username from the query string or body.
+	workspaces := workspace.Handler(db)
+	route("GET", "/workspaces", "/api/workspaces", protected, workspaces)
+	route("POST", "/workspaces", "/api/workspaces", protected, workspaces)
+	members := workspace.MembersHandler(db)
+	route("GET", "/workspaces/{workspace}/members", "/api/workspaces/members", protected, members)
+	route("POST", "/workspaces/{workspace}/members", "/api/workspaces/members", protected, members)
+	route("DELETE", "/workspaces/{workspace}/members/{username}", "/api/workspaces/members", protected, members)
+
+	// Documents and share links
+	route("GET", "/documents", "/api/documents", protected, documents.ListHandler(db))
+	route("DELETE", "/documents/{document}", "", protected, documents.DeleteHandler(db))
+	shares := documents.SharesHandler(db, jwtSecret)
+	route("POST", "/documents/{document}/shares", "/api/documents/shares", protected, shares)
+	route("DELETE", "/shares/{id}", "/api/documents/shares", protected, shares)
+	route("GET", "/shared/search", "/api/shared/search", protectedAs(middleware.Class(middleware.ClassSearch)), search.SharedSearchHandler(db, jwtSecret, searchCfg))
+	route("GET", "/shared/chunks", "/api/shared/chunks", protected, search.SharedChunksHandler(db, jwtSecret))
+
+	// Admin API
+	route("GET", "/admin/users", "/api/admin/users", adminOnly, admin.ListUsersHandler(db))
+	route("GET", "/admin/usage", "/api/admin/usage", adminOnly, admin.UsageHandler(db))
+	route("POST", "/admin/users/{username}/quota", "/api/admin/users/quota", adminOnly, admin.UpdateQuotaHandler(db, limiter.KnownPlan))
+	route("POST", "/admin/users/{username}/role", "/api/admin/users/role", adminOnly, admin.UpdateRoleHandler(db))
+	route("POST", "/admin/users/{username}/disable", "", adminOnly, admin.DisableHandler(db, true))
+	route("POST", "/admin/users/{username}/enable", "", adminOnly, admin.DisableHandler(db, false))
+	handle("POST /api/admin/users/disable", middleware.Deprecated(adminOnly(admin.LegacyDisableHandler(db)), ""))
+	route("POST", "/admin/users/{username}/logout", "/api/admin/users/logout", adminOnly, admin.ForceLogoutHandler(db))
+	route("GET", "/admin/audit", "/api/admin/audit", adminOnly, audit.QueryHandler(db))
+	route("GET", "/admin/audit/export", "/api/admin/audit/export", adminOnly, audit.ExportHandler(db))
+	logLevel := logging.LevelHandler()
+	route("GET", "/admin/loglevel", "/api/admin/loglevel", adminOnly, logLevel)
+	route("PUT", "/admin/loglevel", "/api/admin/loglevel", adminOnly, logLevel)
+	handle("POST /api/admin/loglevel", middleware.Deprecated(adminOnly(logLevel), APIPrefix+"/admin/loglevel"))
+
+	route("GET", "/status", "/api/status", protected, health.StatusHandler(o.Checks, func(r *http.Request) bool {
+		claims, ok := r.Context().Value("claims").(*auth.Claims)
+		return ok && claims.Role == auth.RoleAdmin
+	}))
+
+	// Prometheus scrape endpoint, optionally behind a bearer token
+	document("GET /metrics")
+	finalMux.Handle("GET /metrics", metrics.Handler(cfg.MetricsToken))
+
+	// The spec itself, also at the unversioned path tools look for
+	route("GET", "/openapi.json", "", public, spec.Handler())
+	handle("GET /api/openapi.json", spec.Handler())
+
+	// Only believe the forwarding header from our own proxies (e.g. Render)
+	ipResolver, err := clientip.NewResolver(cfg.TrustedProxies, cfg.TrustedProxyHeader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+
+	// Global Middleware (client IP, request ID, Logging, CORS); rate limits are applied per route
+	apiHandler := middleware.RealIP(middleware.RequestID(middleware.Logging(middleware.CORS(apierror.Mux(finalMux), cfg.AllowedOrigins))), ipResolver)
+
+	// Probes for load balancers and uptime monitors skip the middleware so
+	// they don't flood the logs
+	globalHandler := http.NewServeMux()
+	document("GET /healthz")
+	globalHandler.Handle("GET /healthz", health.LivenessHandler())
+	document("GET /readyz")
+	globalHandler.Handle("GET /readyz", health.ReadinessHandler(o.Checks))
+	globalHandler.Handle("/", apiHandler)
+
+	if len(undocumented) > 0 || len(ops) > 0 {
+		return nil, fmt.Errorf("OpenAPI spec is out of date: undocumented routes %v, documented routes that don't exist %v", undocumented, slices.Sorted(maps.Keys(ops)))
+	}
+
+	return globalHandler, nil
+}
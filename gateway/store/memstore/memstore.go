@@ -0,0 +1,577 @@
+// Package memstore implements the gateway repositories in memory, so that
+// the gateway can run in tests without MongoDB. Vector search is a brute
+// force cosine similarity over every chunk in scope.
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nexus-gateway/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Store struct {
+	mu         sync.Mutex
+	users      []*store.User
+	documents  []store.Document
+	chunks     []store.Chunk
+	shares     []*store.Share
+	workspaces []store.Workspace
+	members    []*store.Member
+	events     []store.Event
+}
+
+var _ store.Store = (*Store)(nil)
+
+func New() *Store {
+	return &Store{}
+}
+
+// Users
+
+func (s *Store) user(username string) *store.User {
+	for _, u := range s.users {
+		if u.Username == username {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *Store) CreateUser(ctx context.Context, u *store.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.user(u.Username) != nil {
+		return store.ErrDuplicate
+	}
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	stored := *u
+	s.users = append(s.users, &stored)
+	return nil
+}
+
+func (s *Store) UserByName(ctx context.Context, username string) (*store.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.user(username)
+	if u == nil {
+		return nil, store.ErrNotFound
+	}
+	found := *u
+	return &found, nil
+}
+
+func (s *Store) ListUsers(ctx context.Context, skip, limit int64) ([]store.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]store.User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return page(list, skip, limit), nil
+}
+
+func page[T any](list []T, skip, limit int64) []T {
+	if skip >= int64(len(list)) {
+		return list[:0]
+	}
+	list = list[skip:]
+	if limit > 0 && limit < int64(len(list)) {
+		list = list[:limit]
+	}
+	return list
+}
+
+func (s *Store) UpdateUser(ctx context.Context, username string, update store.UserUpdate) (*store.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.user(username)
+	if u == nil {
+		return nil, store.ErrNotFound
+	}
+	if update.Role != nil {
+		u.Role = *update.Role
+	}
+	if update.Plan != nil {
+		u.Plan = *update.Plan
+	}
+	if update.QuotaBytes != nil {
+		u.QuotaBytes = *update.QuotaBytes
+	}
+	if update.Disabled != nil {
+		u.Disabled = *update.Disabled
+	}
+	if update.TotalStorageBytes != nil {
+		u.TotalStorageBytes = *update.TotalStorageBytes
+	}
+	u.TotalStorageBytes = max(u.TotalStorageBytes+update.AddStorageBytes, 0)
+	if update.Password != nil {
+		u.Password = *update.Password
+	}
+	if update.DeletionScheduledFor != nil {
+		t := *update.DeletionScheduledFor
+		u.DeletionScheduledFor = &t
+	}
+	if update.CancelDeletion {
+		u.DeletionScheduledFor = nil
+	}
+	if update.EndSessions {
+		u.SessionVersion++
+	}
+	updated := *u
+	return &updated, nil
+}
+
+func (s *Store) UsersDueForDeletion(ctx context.Context, t time.Time) ([]store.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []store.User
+	for _, u := range s.users {
+		if u.DeletionScheduledFor != nil && !u.DeletionScheduledFor.After(t) {
+			list = append(list, *u)
+		}
+	}
+	return list, nil
+}
+
+func (s *Store) ClaimForDeletion(ctx context.Context, id primitive.ObjectID, t time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.ID == id && u.DeletionScheduledFor != nil && !u.DeletionScheduledFor.After(t) {
+			u.Disabled = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users = slices.DeleteFunc(s.users, func(u *store.User) bool { return u.ID == id })
+	return nil
+}
+
+// Documents
+
+func (s *Store) InsertDocument(ctx context.Context, doc store.Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.documents {
+		if d.ID == doc.ID {
+			return store.ErrDuplicate
+		}
+	}
+	s.documents = append(s.documents, doc)
+	return nil
+}
+
+func (s *Store) Document(ctx context.Context, id primitive.ObjectID) (*store.Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.documents {
+		if d.ID == id {
+			return &d, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) DocumentsByUser(ctx context.Context, userID primitive.ObjectID) ([]store.Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var docs []store.Document
+	for _, d := range s.documents {
+		if d.UserID == userID {
+			docs = append(docs, d)
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].CreatedAt.After(docs[j].CreatedAt) })
+	return docs, nil
+}
+
+func (s *Store) DeleteDocument(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := len(s.documents)
+	s.documents = slices.DeleteFunc(s.documents, func(d store.Document) bool { return d.ID == id })
+	if len(s.documents) == before {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) DeleteDocumentsByUser(ctx context.Context, userID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.documents = slices.DeleteFunc(s.documents, func(d store.Document) bool { return d.UserID == userID })
+	return nil
+}
+
+// Chunks
+
+func (s *Store) InsertChunks(ctx context.Context, chunks []store.Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chunks = append(s.chunks, chunks...)
+	return nil
+}
+
+// inScope mirrors the $vectorSearch filter built by mongostore
+func inScope(c store.Chunk, q store.ChunkQuery) bool {
+	if q.DocumentID != nil {
+		return c.DocumentID != nil && *c.DocumentID == *q.DocumentID
+	}
+	if q.UserID != nil && c.UserID == *q.UserID {
+		return true
+	}
+	return c.WorkspaceID != nil && slices.Contains(q.WorkspaceIDs, *c.WorkspaceID)
+}
+
+func (s *Store) SearchChunks(ctx context.Context, q store.ChunkQuery) ([]store.ScoredChunk, error) {
+	if q.DocumentID == nil && q.UserID == nil && len(q.WorkspaceIDs) == 0 {
+		return nil, fmt.Errorf("empty search scope")
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 5
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var hits []store.ScoredChunk
+	for _, c := range s.chunks {
+		if !inScope(c, q) || len(c.Embedding) != len(q.Vector) {
+			continue
+		}
+		hit := store.ScoredChunk{Chunk: c, Score: similarity(c.Embedding, q.Vector)}
+		hit.Embedding = nil
+		hits = append(hits, hit)
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// similarity is the cosine similarity scaled to 0..1, like Atlas'
+// vectorSearchScore for cosine indexes
+func similarity(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return (1 + dot/math.Sqrt(na*nb)) / 2
+}
+
+// chunksWhere returns copies of the matching chunks without embeddings
+func (s *Store) chunksWhere(match func(store.Chunk) bool) []store.Chunk {
+	chunks := []store.Chunk{}
+	for _, c := range s.chunks {
+		if match(c) {
+			c.Embedding = nil
+			chunks = append(chunks, c)
+		}
+	}
+	return chunks
+}
+
+func (s *Store) ChunksByDocument(ctx context.Context, documentID primitive.ObjectID) ([]store.Chunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chunks := s.chunksWhere(func(c store.Chunk) bool { return c.DocumentID != nil && *c.DocumentID == documentID })
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].ChunkIndex < chunks[j].ChunkIndex })
+	return chunks, nil
+}
+
+func (s *Store) ChunksByUser(ctx context.Context, userID primitive.ObjectID) ([]store.Chunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chunks := s.chunksWhere(func(c store.Chunk) bool { return c.UserID == userID })
+	sort.SliceStable(chunks, func(i, j int) bool {
+		if c := strings.Compare(chunks[i].Filename, chunks[j].Filename); c != 0 {
+			return c < 0
+		}
+		return chunks[i].ChunkIndex < chunks[j].ChunkIndex
+	})
+	return chunks, nil
+}
+
+func (s *Store) ChunkStats(ctx context.Context, userIDs []primitive.ObjectID) (map[primitive.ObjectID]store.ChunkStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[primitive.ObjectID]store.ChunkStats)
+	docs := make(map[primitive.ObjectID]map[primitive.ObjectID]bool)
+	for _, c := range s.chunks {
+		if !slices.Contains(userIDs, c.UserID) {
+			continue
+		}
+		st := stats[c.UserID]
+		st.Chunks++
+		if docs[c.UserID] == nil {
+			docs[c.UserID] = make(map[primitive.ObjectID]bool)
+		}
+		if c.DocumentID != nil && !docs[c.UserID][*c.DocumentID] {
+			docs[c.UserID][*c.DocumentID] = true
+			st.Documents++
+		}
+		stats[c.UserID] = st
+	}
+	return stats, nil
+}
+
+func (s *Store) DeleteChunksByDocument(ctx context.Context, documentID primitive.ObjectID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := len(s.chunks)
+	s.chunks = slices.DeleteFunc(s.chunks, func(c store.Chunk) bool { return c.DocumentID != nil && *c.DocumentID == documentID })
+	return int64(before - len(s.chunks)), nil
+}
+
+func (s *Store) DeleteChunksByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := len(s.chunks)
+	s.chunks = slices.DeleteFunc(s.chunks, func(c store.Chunk) bool { return c.UserID == userID })
+	return int64(before - len(s.chunks)), nil
+}
+
+// Shares
+
+func (s *Store) InsertShare(ctx context.Context, share store.Share) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shares = append(s.shares, &share)
+	return nil
+}
+
+func (s *Store) ActiveShares(ctx context.Context, ownerID primitive.ObjectID) ([]store.Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []store.Share
+	for _, sh := range s.shares {
+		if sh.OwnerID == ownerID && sh.RevokedAt == nil {
+			list = append(list, *sh)
+		}
+	}
+	return list, nil
+}
+
+func (s *Store) RevokeShare(ctx context.Context, id, ownerID primitive.ObjectID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sh := range s.shares {
+		if sh.ID == id && sh.OwnerID == ownerID && sh.RevokedAt == nil {
+			sh.RevokedAt = &at
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (s *Store) UseShare(ctx context.Context, id primitive.ObjectID, now time.Time) (*store.Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sh := range s.shares {
+		if sh.ID == id && sh.Usable(now) {
+			sh.Uses++
+			used := *sh
+			return &used, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) DeleteSharesByOwner(ctx context.Context, ownerID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shares = slices.DeleteFunc(s.shares, func(sh *store.Share) bool { return sh.OwnerID == ownerID })
+	return nil
+}
+
+// Workspaces
+
+func (s *Store) CreateWorkspace(ctx context.Context, ws store.Workspace, owner store.Member) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ws.Role = ""
+	s.workspaces = append(s.workspaces, ws)
+	s.members = append(s.members, &owner)
+	return nil
+}
+
+func (s *Store) Workspaces(ctx context.Context, ids []primitive.ObjectID) ([]store.Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []store.Workspace{}
+	for _, ws := range s.workspaces {
+		if slices.Contains(ids, ws.ID) {
+			list = append(list, ws)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (s *Store) Membership(ctx context.Context, workspaceID, userID primitive.ObjectID) (*store.Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.members {
+		if m.WorkspaceID == workspaceID && m.UserID == userID {
+			found := *m
+			return &found, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) MembershipsByUser(ctx context.Context, userID primitive.ObjectID) ([]store.Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []store.Member
+	for _, m := range s.members {
+		if m.UserID == userID {
+			list = append(list, *m)
+		}
+	}
+	return list, nil
+}
+
+func (s *Store) Members(ctx context.Context, workspaceID primitive.ObjectID) ([]store.Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []store.Member{}
+	for _, m := range s.members {
+		if m.WorkspaceID == workspaceID {
+			list = append(list, *m)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list, nil
+}
+
+func (s *Store) SetMember(ctx context.Context, m store.Member) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.members {
+		if existing.WorkspaceID == m.WorkspaceID && existing.UserID == m.UserID {
+			existing.Role = m.Role
+			existing.Username = m.Username
+			return nil
+		}
+	}
+	s.members = append(s.members, &m)
+	return nil
+}
+
+func (s *Store) RemoveMember(ctx context.Context, workspaceID primitive.ObjectID, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, m := range s.members {
+		if m.WorkspaceID == workspaceID && m.Username == username {
+			s.members = slices.Delete(s.members, i, i+1)
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (s *Store) RemoveMemberships(ctx context.Context, userID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.members = slices.DeleteFunc(s.members, func(m *store.Member) bool { return m.UserID == userID })
+	return nil
+}
+
+// Audit log
+
+func (s *Store) AppendEvent(ctx context.Context, e store.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.ID.IsZero() {
+		e.ID = primitive.NewObjectID()
+	}
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *Store) EachEvent(ctx context.Context, f store.EventFilter, fn func(store.Event) error) error {
+	// Collect under the lock but call fn without it, so fn may use the store
+	s.mu.Lock()
+	var matches []store.Event
+	for _, e := range s.events {
+		if f.Matches(e) {
+			matches = append(matches, e)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if f.OldestFirst {
+			return matches[i].Time.Before(matches[j].Time)
+		}
+		return matches[i].Time.After(matches[j].Time)
+	})
+	matches = page(matches, 0, f.Limit)
+
+	for _, e := range matches {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package memstore
+
+import (
+	"context"
+	"testing"
+
+	"nexus-gateway/store"
+	"nexus-gateway/store/storetest"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, New())
+}
+
+func TestSearchChunksScope(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	ws, doc := primitive.NewObjectID(), primitive.NewObjectID()
+
+	s.InsertChunks(ctx, []store.Chunk{
+		{UserID: alice, Filename: "alice.txt", Content: "close", Embedding: []float32{1, 0}},
+		{UserID: alice, Filename: "alice.txt", ChunkIndex: 1, Content: "far", Embedding: []float32{0, 1}},
+		{UserID: bob, DocumentID: &doc, Filename: "bob.txt", Content: "private", Embedding: []float32{1, 0}},
+		{UserID: bob, WorkspaceID: &ws, Filename: "team.txt", Content: "shared", Embedding: []float32{1, 0.1}},
+	})
+
+	hits, err := s.SearchChunks(ctx, store.ChunkQuery{Vector: []float32{1, 0}, UserID: &alice, WorkspaceIDs: []primitive.ObjectID{ws}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, h := range hits {
+		got = append(got, h.Content)
+		if h.Embedding != nil {
+			t.Error("search hit includes its embedding")
+		}
+	}
+	if len(got) != 3 || got[0] != "close" || got[1] != "shared" || got[2] != "far" {
+		t.Errorf("hits = %q, want close, shared, far", got)
+	}
+
+	hits, err = s.SearchChunks(ctx, store.ChunkQuery{Vector: []float32{1, 0}, DocumentID: &doc, UserID: &alice})
+	if err != nil || len(hits) != 1 || hits[0].Content != "private" {
+		t.Errorf("document-scoped search = %v, %v", hits, err)
+	}
+
+	if _, err := s.SearchChunks(ctx, store.ChunkQuery{Vector: []float32{1, 0}}); err == nil {
+		t.Error("search without a scope succeeded")
+	}
+}
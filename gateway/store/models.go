@@ -0,0 +1,205 @@
+package store
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Roles a user can hold. Users created before roles existed have an empty
+// role and are treated as RoleUser.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+const (
+	PlanFree = "free"
+
+	// DefaultQuotaBytes is the storage quota applied when a user has none set
+	DefaultQuotaBytes int64 = 50 * 1024 * 1024
+)
+
+type User struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Username          string             `bson:"username" json:"username"`
+	Password          string             `bson:"password,omitempty" json:"password,omitempty"`
+	TotalStorageBytes int64              `bson:"total_storage_bytes" json:"total_storage_bytes"`
+	Role              string             `bson:"role,omitempty" json:"role"`
+	Plan              string             `bson:"plan,omitempty" json:"plan"`
+	QuotaBytes        int64              `bson:"quota_bytes,omitempty" json:"quota_bytes"`
+	Disabled          bool               `bson:"disabled,omitempty" json:"disabled"`
+	// SessionVersion is embedded in every issued JWT. Bumping it invalidates
+	// all outstanding tokens for the user (force logout).
+	SessionVersion int `bson:"session_version,omitempty" json:"-"`
+	// DeletionScheduledFor is set when the user asked for their account to be
+	// deleted. Logging in before that time cancels the deletion.
+	DeletionScheduledFor *time.Time `bson:"deletion_scheduled_for,omitempty" json:"deletion_scheduled_for,omitempty"`
+}
+
+// Normalize fills in defaults for users stored before roles and plans existed
+func (u *User) Normalize() {
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+	if u.Plan == "" {
+		u.Plan = PlanFree
+	}
+	if u.QuotaBytes == 0 {
+		u.QuotaBytes = DefaultQuotaBytes
+	}
+}
+
+// UserUpdate changes some fields of a user. Nil fields are left alone.
+type UserUpdate struct {
+	Role              *string
+	Plan              *string
+	QuotaBytes        *int64
+	Disabled          *bool
+	TotalStorageBytes *int64
+	// AddStorageBytes adds to TotalStorageBytes in place, so it doesn't race
+	// with the worker's own increments. The total stops at 0.
+	AddStorageBytes int64
+	Password        *string
+	// DeletionScheduledFor schedules the account for deletion;
+	// CancelDeletion clears a scheduled deletion
+	DeletionScheduledFor *time.Time
+	CancelDeletion       bool
+	// EndSessions bumps SessionVersion, invalidating every issued token
+	EndSessions bool
+}
+
+// Document is the metadata record of an uploaded file. Its chunks live in
+// the "docs" collection and carry the same document_id. A document belongs
+// to a workspace when WorkspaceID is set, otherwise to the uploading user.
+type Document struct {
+	ID          primitive.ObjectID  `bson:"_id" json:"id"`
+	UserID      primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	WorkspaceID *primitive.ObjectID `bson:"workspace_id,omitempty" json:"workspace_id,omitempty"`
+	Filename    string              `bson:"filename" json:"filename"`
+	SizeBytes   int64               `bson:"size_bytes" json:"size_bytes"`
+	Chunks      int                 `bson:"chunks" json:"chunks"`
+	CreatedAt   time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// Chunk is one searchable piece of an uploaded file, written by the Python
+// worker. Chunks uploaded before documents had IDs have no DocumentID.
+type Chunk struct {
+	UserID      primitive.ObjectID  `bson:"user_id" json:"-"`
+	DocumentID  *primitive.ObjectID `bson:"document_id,omitempty" json:"-"`
+	WorkspaceID *primitive.ObjectID `bson:"workspace_id,omitempty" json:"-"`
+	Filename    string              `bson:"filename" json:"filename"`
+	ChunkIndex  int                 `bson:"chunk_index" json:"chunk_index"`
+	Content     string              `bson:"content" json:"content"`
+	Embedding   []float32           `bson:"embedding,omitempty" json:"-"`
+	// SizeBytes is the size of the whole file, repeated on every chunk
+	SizeBytes int64 `bson:"size_bytes" json:"-"`
+	// CreatedAt is whatever the worker stored, which is not always a date
+	CreatedAt interface{} `bson:"created_at,omitempty" json:"-"`
+}
+
+// ChunkQuery is a vector search over the chunks matching any of UserID
+// and WorkspaceIDs, or only those of DocumentID when it is set
+type ChunkQuery struct {
+	Vector       []float32
+	UserID       *primitive.ObjectID
+	WorkspaceIDs []primitive.ObjectID
+	DocumentID   *primitive.ObjectID
+	Limit        int
+}
+
+// ScoredChunk is a vector search hit
+type ScoredChunk struct {
+	Chunk
+	Score float64
+}
+
+// ChunkStats summarises what a user stores
+type ChunkStats struct {
+	Chunks int64
+	// Documents counts distinct document IDs; chunks uploaded before
+	// documents had IDs are left out
+	Documents int64
+}
+
+// Share grants read-only access to a single document to whoever holds the
+// signed token. MaxUses of 0 means unlimited.
+type Share struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	DocumentID primitive.ObjectID `bson:"document_id" json:"document_id"`
+	OwnerID    primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt  *time.Time         `bson:"expires_at" json:"expires_at,omitempty"`
+	MaxUses    int                `bson:"max_uses" json:"max_uses"`
+	Uses       int                `bson:"uses" json:"uses"`
+	RevokedAt  *time.Time         `bson:"revoked_at" json:"revoked_at,omitempty"`
+}
+
+// Usable reports whether the share can still be redeemed at now
+func (s *Share) Usable(now time.Time) bool {
+	if s.RevokedAt != nil {
+		return false
+	}
+	if s.ExpiresAt != nil && !s.ExpiresAt.After(now) {
+		return false
+	}
+	return s.MaxUses == 0 || s.Uses < s.MaxUses
+}
+
+// Workspace is a shared knowledge base. Documents uploaded into it can be
+// searched by every member.
+type Workspace struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	OwnerID   primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	// Role is the caller's role, filled in when listing
+	Role string `bson:"-" json:"role,omitempty"`
+}
+
+type Member struct {
+	WorkspaceID primitive.ObjectID `bson:"workspace_id" json:"workspace_id"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Username    string             `bson:"username" json:"username"`
+	Role        string             `bson:"role" json:"role"`
+	AddedAt     time.Time          `bson:"added_at" json:"added_at"`
+}
+
+// Event is a single entry in the audit log. The log is append-only: the
+// gateway never updates or deletes events.
+type Event struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Time      time.Time              `bson:"time" json:"time"`
+	Actor     string                 `bson:"actor" json:"actor"`
+	Action    string                 `bson:"action" json:"action"`
+	Target    string                 `bson:"target,omitempty" json:"target,omitempty"`
+	IP        string                 `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent string                 `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	Outcome   string                 `bson:"outcome" json:"outcome"`
+	Details   map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+}
+
+// EventFilter selects audit events. Zero fields match everything.
+type EventFilter struct {
+	Actor   string
+	Action  string
+	Target  string
+	Outcome string
+	IP      string
+	Since   time.Time
+	Until   time.Time
+	Limit   int64
+	// OldestFirst reverses the default newest-first order
+	OldestFirst bool
+}
+
+// Matches reports whether e passes the filter (ignoring Limit)
+func (f EventFilter) Matches(e Event) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		(f.Outcome == "" || e.Outcome == f.Outcome) &&
+		(f.IP == "" || e.IP == f.IP) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
@@ -0,0 +1,220 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EmbeddingDimensions is the size of the worker's all-MiniLM-L6-v2 vectors
+const EmbeddingDimensions = 384
+
+// Migration is one versioned change to the database. Up must be safe to
+// run again: replicas starting together may race to apply the same one.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+}
+
+// MigrationStatus is a migration and when it was applied, if it was
+type MigrationStatus struct {
+	Version   int        `bson:"_id" json:"version"`
+	Name      string     `bson:"name" json:"name"`
+	AppliedAt *time.Time `bson:"applied_at" json:"applied_at,omitempty"`
+}
+
+// errUnsupported is returned by a migration that this deployment cannot run,
+// e.g. Atlas Search indexes on a plain mongod. It is not recorded, so it is
+// tried again next time.
+var errUnsupported = errors.New("not supported by this deployment")
+
+// Migrations in the order they are applied. Never renumber or edit one that
+// has shipped; add a new one instead.
+var Migrations = []Migration{
+	{1, "unique usernames", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetName("username_unique").SetUnique(true),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("users contains duplicate usernames, merge or rename them first: %w", err)
+		}
+		return err
+	}},
+	{2, "lookup indexes", func(ctx context.Context, db *mongo.Database) error {
+		indexes := map[string][]mongo.IndexModel{
+			"docs": {
+				{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "filename", Value: 1}, {Key: "chunk_index", Value: 1}}},
+				{Keys: bson.D{{Key: "document_id", Value: 1}, {Key: "chunk_index", Value: 1}}},
+				{Keys: bson.D{{Key: "workspace_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+			},
+			"documents": {
+				{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			},
+			"shares": {
+				{Keys: bson.D{{Key: "owner_id", Value: 1}}},
+			},
+			"workspace_members": {
+				{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+				{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			},
+			"users": {
+				{Keys: bson.D{{Key: "deletion_scheduled_for", Value: 1}}, Options: options.Index().SetSparse(true)},
+			},
+			"audit_log": {
+				{Keys: bson.D{{Key: "time", Value: -1}}},
+				{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "time", Value: -1}}},
+			},
+		}
+		for name, models := range indexes {
+			if _, err := db.Collection(name).Indexes().CreateMany(ctx, models); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return nil
+	}},
+	{3, "ttl indexes", func(ctx context.Context, db *mongo.Database) error {
+		// Rate-limit windows go as soon as they end; expired share links are
+		// kept for a month so owners can still see them
+		_, err := db.Collection("ratelimits").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			return fmt.Errorf("ratelimits: %w", err)
+		}
+		_, err = db.Collection("shares").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32((30 * 24 * time.Hour).Seconds())),
+		})
+		if err != nil {
+			return fmt.Errorf("shares: %w", err)
+		}
+		return nil
+	}},
+	{4, "vector index", func(ctx context.Context, db *mongo.Database) error {
+		return createVectorIndex(ctx, db.Collection("docs"), false)
+	}},
+}
+
+// vectorIndexDefinition indexes the worker's embeddings along with the
+// fields SearchChunks pre-filters on
+var vectorIndexDefinition = bson.M{"fields": bson.A{
+	bson.M{"type": "vector", "path": "embedding", "numDimensions": EmbeddingDimensions, "similarity": "cosine"},
+	bson.M{"type": "filter", "path": "user_id"},
+	bson.M{"type": "filter", "path": "document_id"},
+	bson.M{"type": "filter", "path": "workspace_id"},
+}}
+
+// createVectorIndex defines VectorIndexName on docs, or with rebuild replaces
+// the definition of an existing one, which makes Atlas rebuild it. Atlas
+// builds in the background; /readyz reports when the index is queryable.
+func createVectorIndex(ctx context.Context, docs *mongo.Collection, rebuild bool) error {
+	view := docs.SearchIndexes()
+	cursor, err := view.List(ctx, options.SearchIndexes().SetName(VectorIndexName))
+	if err != nil {
+		return searchIndexError(err)
+	}
+	exists := cursor.Next(ctx)
+	cursor.Close(ctx)
+
+	switch {
+	case exists && rebuild:
+		err = view.UpdateOne(ctx, VectorIndexName, vectorIndexDefinition)
+	case !exists:
+		_, err = view.CreateOne(ctx, mongo.SearchIndexModel{
+			Definition: vectorIndexDefinition,
+			Options:    options.SearchIndexes().SetName(VectorIndexName).SetType("vectorSearch"),
+		})
+	}
+	return searchIndexError(err)
+}
+
+// RebuildVectorIndex redefines the vector index so Atlas reindexes every
+// chunk, creating the index if it is missing
+func (s *Store) RebuildVectorIndex(ctx context.Context) error {
+	err := createVectorIndex(ctx, s.chunks(), true)
+	if errors.Is(err, errUnsupported) {
+		return fmt.Errorf("vector index: %w", err)
+	}
+	return err
+}
+
+// searchIndexError turns the errors a deployment without Atlas Search gives
+// into errUnsupported
+func searchIndexError(err error) error {
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		switch ce.Code {
+		case 59, 40324, 31082, 6047401: // CommandNotFound, unknown stage, SearchNotEnabled, Atlas only
+			return errUnsupported
+		}
+	}
+	return err
+}
+
+func (s *Store) migrations() *mongo.Collection { return s.db.Collection("migrations") }
+
+// MigrationStatus lists every known migration and when it was applied
+func (s *Store) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	cursor, err := s.migrations().Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var applied []MigrationStatus
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, err
+	}
+	at := make(map[int]*time.Time, len(applied))
+	for _, m := range applied {
+		at[m.Version] = m.AppliedAt
+	}
+
+	list := make([]MigrationStatus, 0, len(Migrations))
+	for _, m := range Migrations {
+		list = append(list, MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: at[m.Version]})
+	}
+	return list, nil
+}
+
+// Migrate applies the migrations that have not been applied yet, in order,
+// and returns the versions it applied. It stops at the first failure.
+func (s *Store) Migrate(ctx context.Context) ([]int, error) {
+	status, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []int
+	for i, m := range Migrations {
+		if status[i].AppliedAt != nil {
+			continue
+		}
+		err := m.Up(ctx, s.db)
+		if errors.Is(err, errUnsupported) {
+			slog.WarnContext(ctx, "Skipping migration", "version", m.Version, "name", m.Name, "reason", err)
+			continue
+		}
+		if err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+
+		_, err = s.migrations().UpdateOne(ctx,
+			bson.M{"_id": m.Version},
+			bson.M{"$setOnInsert": bson.M{"name": m.Name, "applied_at": time.Now().UTC()}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return applied, fmt.Errorf("recording migration %d: %w", m.Version, err)
+		}
+		slog.InfoContext(ctx, "Applied migration", "version", m.Version, "name", m.Name)
+		applied = append(applied, m.Version)
+	}
+	return applied, nil
+}
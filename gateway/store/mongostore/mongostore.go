@@ -0,0 +1,590 @@
+// Package mongostore implements the gateway repositories on MongoDB
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"nexus-gateway/store"
+	"nexus-gateway/tracing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// VectorIndexName is the Atlas Search index on docs used for vector search
+const VectorIndexName = "vector_index"
+
+// Store keeps everything in one database, normally "nexus_search"
+type Store struct {
+	db *mongo.Database
+}
+
+var _ store.Store = (*Store)(nil)
+
+func New(db *mongo.Database) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) users() *mongo.Collection      { return s.db.Collection("users") }
+func (s *Store) documents() *mongo.Collection  { return s.db.Collection("documents") }
+func (s *Store) chunks() *mongo.Collection     { return s.db.Collection("docs") }
+func (s *Store) shares() *mongo.Collection     { return s.db.Collection("shares") }
+func (s *Store) workspaces() *mongo.Collection { return s.db.Collection("workspaces") }
+func (s *Store) members() *mongo.Collection    { return s.db.Collection("workspace_members") }
+func (s *Store) auditLog() *mongo.Collection   { return s.db.Collection("audit_log") }
+
+// notFound maps the driver's no-documents error to store.ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// Users
+
+func (s *Store) CreateUser(ctx context.Context, u *store.User) error {
+	// The unique index from migration 1 rejects duplicates, even when two
+	// registrations race
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	_, err := s.users().InsertOne(ctx, u)
+	if mongo.IsDuplicateKeyError(err) {
+		return store.ErrDuplicate
+	}
+	return err
+}
+
+func (s *Store) UserByName(ctx context.Context, username string) (_ *store.User, err error) {
+	ctx, span := tracing.StartClient(ctx, "mongo.users.lookup", attribute.String("db.system", "mongodb"))
+	defer func() { tracing.End(span, err) }()
+
+	var u store.User
+	if err := s.users().FindOne(ctx, bson.M{"username": username}).Decode(&u); err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (s *Store) ListUsers(ctx context.Context, skip, limit int64) ([]store.User, error) {
+	opts := options.Find().SetSort(bson.M{"username": 1}).SetSkip(skip)
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := s.users().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	list := []store.User{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *Store) UpdateUser(ctx context.Context, username string, update store.UserUpdate) (*store.User, error) {
+	set := bson.M{}
+	if update.Role != nil {
+		set["role"] = *update.Role
+	}
+	if update.Plan != nil {
+		set["plan"] = *update.Plan
+	}
+	if update.QuotaBytes != nil {
+		set["quota_bytes"] = *update.QuotaBytes
+	}
+	if update.Disabled != nil {
+		set["disabled"] = *update.Disabled
+	}
+	if update.TotalStorageBytes != nil {
+		set["total_storage_bytes"] = *update.TotalStorageBytes
+	}
+	if update.Password != nil {
+		set["password"] = *update.Password
+	}
+	if update.DeletionScheduledFor != nil {
+		set["deletion_scheduled_for"] = *update.DeletionScheduledFor
+	}
+
+	inc := bson.M{}
+	if update.AddStorageBytes != 0 {
+		inc["total_storage_bytes"] = update.AddStorageBytes
+	}
+	if update.EndSessions {
+		inc["session_version"] = 1
+	}
+
+	doc := bson.M{}
+	if len(set) > 0 {
+		doc["$set"] = set
+	}
+	if update.CancelDeletion {
+		doc["$unset"] = bson.M{"deletion_scheduled_for": ""}
+	}
+	if len(inc) > 0 {
+		doc["$inc"] = inc
+	}
+	if len(doc) == 0 {
+		return s.UserByName(ctx, username)
+	}
+
+	var u store.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := s.users().FindOneAndUpdate(ctx, bson.M{"username": username}, doc, opts).Decode(&u); err != nil {
+		return nil, notFound(err)
+	}
+	// $inc can't stop at 0, so a total that went below it is reset. Only
+	// totals that were already undercounted get there.
+	if u.TotalStorageBytes < 0 {
+		filter := bson.M{"_id": u.ID, "total_storage_bytes": bson.M{"$lt": 0}}
+		if _, err := s.users().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"total_storage_bytes": 0}}); err != nil {
+			return nil, err
+		}
+		u.TotalStorageBytes = 0
+	}
+	return &u, nil
+}
+
+func (s *Store) UsersDueForDeletion(ctx context.Context, t time.Time) ([]store.User, error) {
+	cursor, err := s.users().Find(ctx, bson.M{"deletion_scheduled_for": bson.M{"$lte": t}})
+	if err != nil {
+		return nil, err
+	}
+	var list []store.User
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *Store) ClaimForDeletion(ctx context.Context, id primitive.ObjectID, t time.Time) (bool, error) {
+	claim := bson.M{"_id": id, "deletion_scheduled_for": bson.M{"$lte": t}}
+	res, err := s.users().UpdateOne(ctx, claim, bson.M{"$set": bson.M{"disabled": true}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.users().DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// Documents
+
+func (s *Store) InsertDocument(ctx context.Context, doc store.Document) error {
+	_, err := s.documents().InsertOne(ctx, doc)
+	return err
+}
+
+func (s *Store) Document(ctx context.Context, id primitive.ObjectID) (*store.Document, error) {
+	var doc store.Document
+	if err := s.documents().FindOne(ctx, bson.M{"_id": id}).Decode(&doc); err != nil {
+		return nil, notFound(err)
+	}
+	return &doc, nil
+}
+
+func (s *Store) DocumentsByUser(ctx context.Context, userID primitive.ObjectID) ([]store.Document, error) {
+	cursor, err := s.documents().Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []store.Document
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func (s *Store) DeleteDocument(ctx context.Context, id primitive.ObjectID) error {
+	res, err := s.documents().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) DeleteDocumentsByUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.documents().DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// Chunks
+
+func (s *Store) InsertChunks(ctx context.Context, chunks []store.Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(chunks))
+	for i, c := range chunks {
+		docs[i] = c
+	}
+	_, err := s.chunks().InsertMany(ctx, docs)
+	return err
+}
+
+// chunkFilter builds the $vectorSearch pre-filter matching the union of the
+// collections in scope
+func chunkFilter(q store.ChunkQuery) (bson.M, error) {
+	if q.DocumentID != nil {
+		return bson.M{"document_id": *q.DocumentID}, nil
+	}
+
+	var clauses []bson.M
+	if q.UserID != nil {
+		clauses = append(clauses, bson.M{"user_id": *q.UserID})
+	}
+	if len(q.WorkspaceIDs) > 0 {
+		clauses = append(clauses, bson.M{"workspace_id": bson.M{"$in": q.WorkspaceIDs}})
+	}
+
+	switch len(clauses) {
+	case 0:
+		return nil, fmt.Errorf("empty search scope")
+	case 1:
+		return clauses[0], nil
+	default:
+		return bson.M{"$or": clauses}, nil
+	}
+}
+
+func (s *Store) SearchChunks(ctx context.Context, q store.ChunkQuery) (_ []store.ScoredChunk, err error) {
+	ctx, span := tracing.StartClient(ctx, "mongo.vectorSearch",
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.collection.name", s.chunks().Name()))
+	defer func() { tracing.End(span, err) }()
+
+	filter, err := chunkFilter(q)
+	if err != nil {
+		return nil, err
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 5
+	}
+
+	pipeline := []bson.M{
+		{
+			"$vectorSearch": bson.M{
+				"index":         VectorIndexName,
+				"path":          "embedding",
+				"queryVector":   q.Vector,
+				"numCandidates": 20 * limit,
+				"limit":         limit,
+				"filter":        filter,
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":          0,
+				"user_id":      1,
+				"document_id":  1,
+				"workspace_id": 1,
+				"filename":     1,
+				"chunk_index":  1,
+				"content":      1,
+				"score":        bson.M{"$meta": "vectorSearchScore"},
+			},
+		},
+	}
+
+	cursor, err := s.chunks().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []store.ScoredChunk
+	for cursor.Next(ctx) {
+		var hit struct {
+			store.Chunk `bson:",inline"`
+			Score       float64 `bson:"score"`
+		}
+		if err := cursor.Decode(&hit); err != nil {
+			return nil, err
+		}
+		results = append(results, store.ScoredChunk{Chunk: hit.Chunk, Score: hit.Score})
+	}
+	return results, cursor.Err()
+}
+
+func (s *Store) ChunksByDocument(ctx context.Context, documentID primitive.ObjectID) ([]store.Chunk, error) {
+	return s.findChunks(ctx, bson.M{"document_id": documentID}, bson.D{{Key: "chunk_index", Value: 1}})
+}
+
+func (s *Store) ChunksByUser(ctx context.Context, userID primitive.ObjectID) ([]store.Chunk, error) {
+	return s.findChunks(ctx, bson.M{"user_id": userID}, bson.D{{Key: "filename", Value: 1}, {Key: "chunk_index", Value: 1}})
+}
+
+// findChunks loads matching chunks without their embeddings, which callers
+// outside of search have no use for
+func (s *Store) findChunks(ctx context.Context, filter bson.M, sort bson.D) ([]store.Chunk, error) {
+	opts := options.Find().SetSort(sort).SetProjection(bson.M{"embedding": 0})
+	cursor, err := s.chunks().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	chunks := []store.Chunk{}
+	if err := cursor.All(ctx, &chunks); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+func (s *Store) ChunkStats(ctx context.Context, userIDs []primitive.ObjectID) (map[primitive.ObjectID]store.ChunkStats, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"user_id": bson.M{"$in": userIDs}}},
+		{"$group": bson.M{
+			"_id":    "$user_id",
+			"chunks": bson.M{"$sum": 1},
+			"docs":   bson.M{"$addToSet": "$document_id"},
+		}},
+		{"$project": bson.M{"chunks": 1, "documents": bson.M{"$size": "$docs"}}},
+	}
+	cursor, err := s.chunks().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		UserID    primitive.ObjectID `bson:"_id"`
+		Chunks    int64              `bson:"chunks"`
+		Documents int64              `bson:"documents"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[primitive.ObjectID]store.ChunkStats, len(rows))
+	for _, r := range rows {
+		stats[r.UserID] = store.ChunkStats{Chunks: r.Chunks, Documents: r.Documents}
+	}
+	return stats, nil
+}
+
+func (s *Store) DeleteChunksByDocument(ctx context.Context, documentID primitive.ObjectID) (int64, error) {
+	res, err := s.chunks().DeleteMany(ctx, bson.M{"document_id": documentID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (s *Store) DeleteChunksByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	res, err := s.chunks().DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// Shares
+
+func (s *Store) InsertShare(ctx context.Context, share store.Share) error {
+	_, err := s.shares().InsertOne(ctx, share)
+	return err
+}
+
+func (s *Store) ActiveShares(ctx context.Context, ownerID primitive.ObjectID) ([]store.Share, error) {
+	cursor, err := s.shares().Find(ctx, bson.M{"owner_id": ownerID, "revoked_at": nil})
+	if err != nil {
+		return nil, err
+	}
+	var list []store.Share
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *Store) RevokeShare(ctx context.Context, id, ownerID primitive.ObjectID, at time.Time) error {
+	res, err := s.shares().UpdateOne(ctx,
+		bson.M{"_id": id, "owner_id": ownerID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) UseShare(ctx context.Context, id primitive.ObjectID, now time.Time) (*store.Share, error) {
+	filter := bson.M{
+		"_id":        id,
+		"revoked_at": nil,
+		"$and": []bson.M{
+			{"$or": []bson.M{{"expires_at": nil}, {"expires_at": bson.M{"$gt": now}}}},
+			{"$or": []bson.M{{"max_uses": 0}, {"$expr": bson.M{"$lt": []string{"$uses", "$max_uses"}}}}},
+		},
+	}
+	var share store.Share
+	err := s.shares().FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&share)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &share, nil
+}
+
+func (s *Store) DeleteSharesByOwner(ctx context.Context, ownerID primitive.ObjectID) error {
+	_, err := s.shares().DeleteMany(ctx, bson.M{"owner_id": ownerID})
+	return err
+}
+
+// Workspaces
+
+func (s *Store) CreateWorkspace(ctx context.Context, ws store.Workspace, owner store.Member) error {
+	if _, err := s.workspaces().InsertOne(ctx, ws); err != nil {
+		return err
+	}
+	_, err := s.members().InsertOne(ctx, owner)
+	return err
+}
+
+func (s *Store) Workspaces(ctx context.Context, ids []primitive.ObjectID) ([]store.Workspace, error) {
+	cursor, err := s.workspaces().Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	list := []store.Workspace{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *Store) Membership(ctx context.Context, workspaceID, userID primitive.ObjectID) (*store.Member, error) {
+	var m store.Member
+	err := s.members().FindOne(ctx, bson.M{"workspace_id": workspaceID, "user_id": userID}).Decode(&m)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &m, nil
+}
+
+func (s *Store) MembershipsByUser(ctx context.Context, userID primitive.ObjectID) ([]store.Member, error) {
+	cursor, err := s.members().Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	var list []store.Member
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *Store) Members(ctx context.Context, workspaceID primitive.ObjectID) ([]store.Member, error) {
+	cursor, err := s.members().Find(ctx, bson.M{"workspace_id": workspaceID}, options.Find().SetSort(bson.M{"username": 1}))
+	if err != nil {
+		return nil, err
+	}
+	list := []store.Member{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *Store) SetMember(ctx context.Context, m store.Member) error {
+	filter := bson.M{"workspace_id": m.WorkspaceID, "user_id": m.UserID}
+	update := bson.M{
+		"$set":         bson.M{"role": m.Role, "username": m.Username},
+		"$setOnInsert": bson.M{"added_at": m.AddedAt},
+	}
+	_, err := s.members().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (s *Store) RemoveMember(ctx context.Context, workspaceID primitive.ObjectID, username string) error {
+	res, err := s.members().DeleteOne(ctx, bson.M{"workspace_id": workspaceID, "username": username})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) RemoveMemberships(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.members().DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// Audit log
+
+func (s *Store) AppendEvent(ctx context.Context, e store.Event) error {
+	_, err := s.auditLog().InsertOne(ctx, e)
+	return err
+}
+
+func (s *Store) EachEvent(ctx context.Context, f store.EventFilter, fn func(store.Event) error) error {
+	order := -1
+	if f.OldestFirst {
+		order = 1
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: order}})
+	if f.Limit > 0 {
+		opts.SetLimit(f.Limit)
+	}
+	cursor, err := s.auditLog().Find(ctx, eventFilter(f), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var e store.Event
+		if err := cursor.Decode(&e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func eventFilter(f store.EventFilter) bson.M {
+	filter := bson.M{}
+	if f.Actor != "" {
+		filter["actor"] = f.Actor
+	}
+	if f.Action != "" {
+		filter["action"] = f.Action
+	}
+	if f.Target != "" {
+		filter["target"] = f.Target
+	}
+	if f.Outcome != "" {
+		filter["outcome"] = f.Outcome
+	}
+	if f.IP != "" {
+		filter["ip"] = f.IP
+	}
+	timeRange := bson.M{}
+	if !f.Since.IsZero() {
+		timeRange["$gte"] = f.Since
+	}
+	if !f.Until.IsZero() {
+		timeRange["$lt"] = f.Until
+	}
+	if len(timeRange) > 0 {
+		filter["time"] = timeRange
+	}
+	return filter
+}
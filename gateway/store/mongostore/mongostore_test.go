@@ -0,0 +1,104 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"nexus-gateway/store"
+	"nexus-gateway/store/storetest"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDB connects to MONGODB_TEST_URI, e.g.
+// MONGODB_TEST_URI=mongodb://localhost:27017 go test ./store/mongostore
+// and drops the database when the test ends
+func testDB(t *testing.T) *mongo.Database {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	db := client.Database("nexus_store_test")
+	t.Cleanup(func() { db.Drop(context.Background()) })
+	return db
+}
+
+func TestStore(t *testing.T) {
+	s := New(testDB(t))
+	if _, err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	storetest.Run(t, s)
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	s := New(testDB(t))
+
+	if _, err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := s.Migrate(ctx)
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("second run applied %v, %v; want nothing", applied, err)
+	}
+	status, err := s.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range status {
+		// The vector index needs Atlas
+		if m.AppliedAt == nil && m.Name != "vector index" {
+			t.Errorf("migration %d (%s) not applied", m.Version, m.Name)
+		}
+	}
+
+	// Only the unique index stops concurrent registrations
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.CreateUser(ctx, &store.User{Username: "racer"})
+		}(i)
+	}
+	wg.Wait()
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, store.ErrDuplicate):
+			t.Errorf("CreateUser: %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d users created, want 1", created)
+	}
+}
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i, m := range Migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %q has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Up == nil {
+			t.Errorf("migration %d has no Up", m.Version)
+		}
+	}
+}
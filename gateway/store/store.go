@@ -0,0 +1,103 @@
+// Package store defines the repositories the gateway keeps its data in.
+// Handlers depend only on these interfaces; mongostore implements them on
+// MongoDB and memstore in memory for tests.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrDuplicate = errors.New("already exists")
+)
+
+type UserStore interface {
+	// CreateUser stores u and sets its ID. It returns ErrDuplicate if the
+	// username is taken.
+	CreateUser(ctx context.Context, u *User) error
+	UserByName(ctx context.Context, username string) (*User, error)
+	// ListUsers returns users sorted by username. A limit of 0 means all.
+	ListUsers(ctx context.Context, skip, limit int64) ([]User, error)
+	// UpdateUser applies update and returns the updated user
+	UpdateUser(ctx context.Context, username string, update UserUpdate) (*User, error)
+	// UsersDueForDeletion returns the users whose deletion date is not after t
+	UsersDueForDeletion(ctx context.Context, t time.Time) ([]User, error)
+	// ClaimForDeletion disables the user if it is still due for deletion at
+	// t, and reports whether it was
+	ClaimForDeletion(ctx context.Context, id primitive.ObjectID, t time.Time) (bool, error)
+	DeleteUser(ctx context.Context, id primitive.ObjectID) error
+}
+
+type DocumentStore interface {
+	InsertDocument(ctx context.Context, doc Document) error
+	Document(ctx context.Context, id primitive.ObjectID) (*Document, error)
+	// DocumentsByUser returns the documents uploaded by userID, newest first
+	DocumentsByUser(ctx context.Context, userID primitive.ObjectID) ([]Document, error)
+	// DeleteDocument returns ErrNotFound if there is no such document
+	DeleteDocument(ctx context.Context, id primitive.ObjectID) error
+	DeleteDocumentsByUser(ctx context.Context, userID primitive.ObjectID) error
+}
+
+type ChunkStore interface {
+	InsertChunks(ctx context.Context, chunks []Chunk) error
+	// SearchChunks returns the chunks nearest to q.Vector, best first
+	SearchChunks(ctx context.Context, q ChunkQuery) ([]ScoredChunk, error)
+	// ChunksByDocument returns the chunks of a document in order
+	ChunksByDocument(ctx context.Context, documentID primitive.ObjectID) ([]Chunk, error)
+	// ChunksByUser returns everything userID uploaded, by filename and index
+	ChunksByUser(ctx context.Context, userID primitive.ObjectID) ([]Chunk, error)
+	ChunkStats(ctx context.Context, userIDs []primitive.ObjectID) (map[primitive.ObjectID]ChunkStats, error)
+	// DeleteChunksByDocument and DeleteChunksByUser return the number of
+	// chunks deleted
+	DeleteChunksByDocument(ctx context.Context, documentID primitive.ObjectID) (int64, error)
+	DeleteChunksByUser(ctx context.Context, userID primitive.ObjectID) (int64, error)
+}
+
+type ShareStore interface {
+	InsertShare(ctx context.Context, s Share) error
+	// ActiveShares returns the shares of ownerID that have not been revoked
+	ActiveShares(ctx context.Context, ownerID primitive.ObjectID) ([]Share, error)
+	// RevokeShare returns ErrNotFound unless ownerID has such an active share
+	RevokeShare(ctx context.Context, id, ownerID primitive.ObjectID, at time.Time) error
+	// UseShare atomically counts one use of a share that is usable at now.
+	// It returns ErrNotFound for missing, revoked, expired and used-up shares.
+	UseShare(ctx context.Context, id primitive.ObjectID, now time.Time) (*Share, error)
+	DeleteSharesByOwner(ctx context.Context, ownerID primitive.ObjectID) error
+}
+
+type WorkspaceStore interface {
+	// CreateWorkspace stores ws together with its first member
+	CreateWorkspace(ctx context.Context, ws Workspace, owner Member) error
+	// Workspaces returns the given workspaces sorted by name
+	Workspaces(ctx context.Context, ids []primitive.ObjectID) ([]Workspace, error)
+	Membership(ctx context.Context, workspaceID, userID primitive.ObjectID) (*Member, error)
+	MembershipsByUser(ctx context.Context, userID primitive.ObjectID) ([]Member, error)
+	// Members returns the members of a workspace sorted by username
+	Members(ctx context.Context, workspaceID primitive.ObjectID) ([]Member, error)
+	// SetMember adds m or changes its role, keeping the original AddedAt
+	SetMember(ctx context.Context, m Member) error
+	RemoveMember(ctx context.Context, workspaceID primitive.ObjectID, username string) error
+	RemoveMemberships(ctx context.Context, userID primitive.ObjectID) error
+}
+
+type AuditStore interface {
+	AppendEvent(ctx context.Context, e Event) error
+	// EachEvent calls fn for every event matching f, in the order f asks
+	// for, stopping at the first error
+	EachEvent(ctx context.Context, f EventFilter, fn func(Event) error) error
+}
+
+// Store is every repository the gateway uses
+type Store interface {
+	UserStore
+	DocumentStore
+	ChunkStore
+	ShareStore
+	WorkspaceStore
+	AuditStore
+}
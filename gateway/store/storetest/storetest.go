@@ -0,0 +1,241 @@
+// Package storetest checks the behaviour every store.Store implementation
+// must share. Vector search is left out as it needs Atlas.
+package storetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"nexus-gateway/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func Run(t *testing.T, s store.Store) {
+	t.Run("Users", func(t *testing.T) { testUsers(t, s) })
+	t.Run("Documents", func(t *testing.T) { testDocuments(t, s) })
+	t.Run("Shares", func(t *testing.T) { testShares(t, s) })
+	t.Run("Workspaces", func(t *testing.T) { testWorkspaces(t, s) })
+	t.Run("Audit", func(t *testing.T) { testAudit(t, s) })
+}
+
+func testUsers(t *testing.T, s store.Store) {
+	ctx := context.Background()
+
+	alice := &store.User{Username: "alice", Password: "hash"}
+	if err := s.CreateUser(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	if alice.ID.IsZero() {
+		t.Fatal("CreateUser did not set the ID")
+	}
+	if err := s.CreateUser(ctx, &store.User{Username: "alice"}); err != store.ErrDuplicate {
+		t.Fatalf("duplicate CreateUser = %v, want ErrDuplicate", err)
+	}
+	if _, err := s.UserByName(ctx, "nobody"); err != store.ErrNotFound {
+		t.Fatalf("UserByName(nobody) = %v, want ErrNotFound", err)
+	}
+
+	due := time.Now().UTC().Add(-time.Minute).Truncate(time.Millisecond)
+	role := store.RoleAdmin
+	u, err := s.UpdateUser(ctx, "alice", store.UserUpdate{Role: &role, DeletionScheduledFor: &due, EndSessions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Role != store.RoleAdmin || u.SessionVersion != 1 || u.DeletionScheduledFor == nil {
+		t.Errorf("UpdateUser returned %+v", u)
+	}
+
+	u, err = s.UpdateUser(ctx, "alice", store.UserUpdate{AddStorageBytes: 100})
+	if err != nil || u.TotalStorageBytes != 100 {
+		t.Fatalf("AddStorageBytes(100) left %+v, %v", u, err)
+	}
+	u, err = s.UpdateUser(ctx, "alice", store.UserUpdate{AddStorageBytes: -250})
+	if err != nil || u.TotalStorageBytes != 0 {
+		t.Fatalf("AddStorageBytes(-250) left %+v, %v, want 0 bytes", u, err)
+	}
+
+	list, err := s.UsersDueForDeletion(ctx, time.Now().UTC())
+	if err != nil || len(list) != 1 || list[0].ID != alice.ID {
+		t.Fatalf("UsersDueForDeletion = %v, %v", list, err)
+	}
+
+	u, err = s.UpdateUser(ctx, "alice", store.UserUpdate{CancelDeletion: true})
+	if err != nil || u.DeletionScheduledFor != nil {
+		t.Fatalf("CancelDeletion left %+v, %v", u, err)
+	}
+	if claimed, err := s.ClaimForDeletion(ctx, alice.ID, time.Now().UTC()); err != nil || claimed {
+		t.Fatalf("ClaimForDeletion after cancelling = %v, %v", claimed, err)
+	}
+
+	if err := s.DeleteUser(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateUser(ctx, "alice", store.UserUpdate{EndSessions: true}); err != store.ErrNotFound {
+		t.Fatalf("UpdateUser of deleted user = %v, want ErrNotFound", err)
+	}
+}
+
+func testDocuments(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	owner := primitive.NewObjectID()
+
+	var docs []store.Document
+	for i := 0; i < 2; i++ {
+		doc := store.Document{ID: primitive.NewObjectID(), UserID: owner, Filename: "notes.txt", Chunks: 2, CreatedAt: time.Now().UTC()}
+		if err := s.InsertDocument(ctx, doc); err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+		chunks := []store.Chunk{
+			{UserID: owner, DocumentID: &doc.ID, Filename: doc.Filename, ChunkIndex: 0},
+			{UserID: owner, DocumentID: &doc.ID, Filename: doc.Filename, ChunkIndex: 1},
+		}
+		if err := s.InsertChunks(ctx, chunks); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := s.ChunkStats(ctx, []primitive.ObjectID{owner})
+	if err != nil || stats[owner] != (store.ChunkStats{Chunks: 4, Documents: 2}) {
+		t.Errorf("ChunkStats = %v, %v, want 4 chunks in 2 documents", stats, err)
+	}
+
+	if n, err := s.DeleteChunksByDocument(ctx, docs[0].ID); err != nil || n != 2 {
+		t.Errorf("DeleteChunksByDocument = %d, %v, want 2", n, err)
+	}
+	if err := s.DeleteDocument(ctx, docs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteDocument(ctx, docs[0].ID); err != store.ErrNotFound {
+		t.Errorf("deleting twice = %v, want ErrNotFound", err)
+	}
+
+	left, err := s.DocumentsByUser(ctx, owner)
+	if err != nil || len(left) != 1 || left[0].ID != docs[1].ID {
+		t.Errorf("DocumentsByUser = %v, %v", left, err)
+	}
+	chunks, err := s.ChunksByDocument(ctx, docs[1].ID)
+	if err != nil || len(chunks) != 2 {
+		t.Errorf("the other document's chunks = %v, %v", chunks, err)
+	}
+}
+
+func testShares(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	owner := primitive.NewObjectID()
+
+	limited := store.Share{ID: primitive.NewObjectID(), OwnerID: owner, CreatedAt: now, MaxUses: 2}
+	expired := now.Add(-time.Hour)
+	old := store.Share{ID: primitive.NewObjectID(), OwnerID: owner, CreatedAt: now, ExpiresAt: &expired}
+	for _, sh := range []store.Share{limited, old} {
+		if err := s.InsertShare(ctx, sh); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 1; i <= 2; i++ {
+		sh, err := s.UseShare(ctx, limited.ID, now)
+		if err != nil {
+			t.Fatalf("use %d: %v", i, err)
+		}
+		if sh.Uses != i {
+			t.Errorf("use %d: Uses = %d", i, sh.Uses)
+		}
+	}
+	if _, err := s.UseShare(ctx, limited.ID, now); err != store.ErrNotFound {
+		t.Errorf("use beyond MaxUses = %v, want ErrNotFound", err)
+	}
+	if _, err := s.UseShare(ctx, old.ID, now); err != store.ErrNotFound {
+		t.Errorf("use of expired share = %v, want ErrNotFound", err)
+	}
+
+	if err := s.RevokeShare(ctx, old.ID, primitive.NewObjectID(), now); err != store.ErrNotFound {
+		t.Errorf("revoke by another owner = %v, want ErrNotFound", err)
+	}
+	if err := s.RevokeShare(ctx, old.ID, owner, now); err != nil {
+		t.Fatal(err)
+	}
+	active, err := s.ActiveShares(ctx, owner)
+	if err != nil || len(active) != 1 || active[0].ID != limited.ID {
+		t.Errorf("ActiveShares = %v, %v", active, err)
+	}
+}
+
+func testWorkspaces(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	ownerID, memberID := primitive.NewObjectID(), primitive.NewObjectID()
+
+	ws := store.Workspace{ID: primitive.NewObjectID(), Name: "research", OwnerID: ownerID, CreatedAt: now}
+	owner := store.Member{WorkspaceID: ws.ID, UserID: ownerID, Username: "owner", Role: "owner", AddedAt: now}
+	if err := s.CreateWorkspace(ctx, ws, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	member := store.Member{WorkspaceID: ws.ID, UserID: memberID, Username: "bob", Role: "viewer", AddedAt: now}
+	if err := s.SetMember(ctx, member); err != nil {
+		t.Fatal(err)
+	}
+	// Changing the role keeps the original AddedAt
+	member.Role, member.AddedAt = "editor", now.Add(time.Hour)
+	if err := s.SetMember(ctx, member); err != nil {
+		t.Fatal(err)
+	}
+	m, err := s.Membership(ctx, ws.ID, memberID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Role != "editor" || !m.AddedAt.Equal(now) {
+		t.Errorf("Membership = %+v", m)
+	}
+
+	members, err := s.Members(ctx, ws.ID)
+	if err != nil || len(members) != 2 || members[0].Username != "bob" {
+		t.Errorf("Members = %v, %v", members, err)
+	}
+
+	if err := s.RemoveMember(ctx, ws.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveMember(ctx, ws.ID, "bob"); err != store.ErrNotFound {
+		t.Errorf("second RemoveMember = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Membership(ctx, ws.ID, memberID); err != store.ErrNotFound {
+		t.Errorf("Membership after removal = %v, want ErrNotFound", err)
+	}
+}
+
+func testAudit(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	for i, action := range []string{"auth.login", "auth.login", "workspace.create"} {
+		e := store.Event{Time: start.Add(time.Duration(i) * time.Second), Actor: "carol", Action: action, Outcome: "success"}
+		if err := s.AppendEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var times []time.Time
+	err := s.EachEvent(ctx, store.EventFilter{Actor: "carol", Action: "auth.login"}, func(e store.Event) error {
+		times = append(times, e.Time)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 2 || !times[0].After(times[1]) {
+		t.Errorf("events not newest first: %v", times)
+	}
+
+	var first []store.Event
+	err = s.EachEvent(ctx, store.EventFilter{Actor: "carol", OldestFirst: true, Limit: 1}, func(e store.Event) error {
+		first = append(first, e)
+		return nil
+	})
+	if err != nil || len(first) != 1 || !first[0].Time.Equal(start) {
+		t.Errorf("oldest event = %v, %v", first, err)
+	}
+}
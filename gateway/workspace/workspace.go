@@ -0,0 +1,364 @@
+package workspace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"nexus-gateway/apierror"
+	"nexus-gateway/audit"
+	"nexus-gateway/auth"
+	"nexus-gateway/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Member roles, from most to least privileged
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var ErrNotMember = errors.New("not a member of this workspace")
+
+// Workspace and Member are defined in the store package
+type (
+	Workspace = store.Workspace
+	Member    = store.Member
+)
+
+type CreateRequest struct {
+	Name string `json:"name"`
+}
+
+// MemberRequest adds a member or changes their role. Workspace is only read
+// on the deprecated route; /api/v1 takes it from the path.
+type MemberRequest struct {
+	Workspace string `json:"workspace,omitempty"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+}
+
+func validRole(role string) bool {
+	return role == RoleOwner || role == RoleEditor || role == RoleViewer
+}
+
+// CanWrite reports whether role may upload documents and manage content
+func CanWrite(role string) bool {
+	return role == RoleOwner || role == RoleEditor
+}
+
+// MemberRole returns the role userID holds in workspaceID, or ErrNotMember
+func MemberRole(ctx context.Context, members store.WorkspaceStore, workspaceID, userID string) (string, error) {
+	wsOID, err := primitive.ObjectIDFromHex(workspaceID)
+	if err != nil {
+		return "", ErrNotMember
+	}
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return "", ErrNotMember
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	m, err := members.Membership(ctx, wsOID, userOID)
+	if err == store.ErrNotFound {
+		return "", ErrNotMember
+	}
+	if err != nil {
+		return "", err
+	}
+	return m.Role, nil
+}
+
+// ReadableIDs returns the hex IDs of every workspace userID is a member of
+func ReadableIDs(ctx context.Context, members store.WorkspaceStore, userID string) ([]string, error) {
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	list, err := members.MembershipsByUser(ctx, userOID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(list))
+	for _, m := range list {
+		ids = append(ids, m.WorkspaceID.Hex())
+	}
+	return ids, nil
+}
+
+// lookupUser resolves a username to its user record
+func lookupUser(ctx context.Context, users store.UserStore, username string) (*auth.User, error) {
+	return users.UserByName(ctx, username)
+}
+
+// creator returns the ID of the user who created workspaceID
+func creator(ctx context.Context, workspaces store.WorkspaceStore, workspaceID primitive.ObjectID) (primitive.ObjectID, error) {
+	list, err := workspaces.Workspaces(ctx, []primitive.ObjectID{workspaceID})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if len(list) == 0 {
+		return primitive.NilObjectID, store.ErrNotFound
+	}
+	return list[0].OwnerID, nil
+}
+
+// Handler serves /api/v1/workspaces: GET lists the caller's workspaces, POST
+// creates one with the caller as owner.
+func Handler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("user").(string)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		user, err := lookupUser(ctx, db, username)
+		if err != nil {
+			apierror.Unauthorized(w, r, "User not found")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			memberships, err := db.MembershipsByUser(ctx, user.ID)
+			if err != nil {
+				apierror.Internal(w, r, "Failed to list workspaces", err)
+				return
+			}
+
+			roles := make(map[primitive.ObjectID]string, len(memberships))
+			ids := make([]primitive.ObjectID, 0, len(memberships))
+			for _, m := range memberships {
+				roles[m.WorkspaceID] = m.Role
+				ids = append(ids, m.WorkspaceID)
+			}
+
+			result, err := db.Workspaces(ctx, ids)
+			if err != nil {
+				apierror.Internal(w, r, "Failed to list workspaces", err)
+				return
+			}
+			for i := range result {
+				result[i].Role = roles[result[i].ID]
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+
+		case http.MethodPost:
+			var req CreateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+				apierror.BadRequest(w, r, "Invalid request")
+				return
+			}
+
+			ws := Workspace{
+				ID:        primitive.NewObjectID(),
+				Name:      strings.TrimSpace(req.Name),
+				OwnerID:   user.ID,
+				CreatedAt: time.Now().UTC(),
+			}
+			owner := Member{
+				WorkspaceID: ws.ID,
+				UserID:      user.ID,
+				Username:    user.Username,
+				Role:        RoleOwner,
+				AddedAt:     ws.CreatedAt,
+			}
+			if err := db.CreateWorkspace(ctx, ws, owner); err != nil {
+				apierror.Internal(w, r, "Failed to create workspace", err)
+				return
+			}
+
+			audit.RecordRequest(r, db, audit.Event{
+				Actor:  username,
+				Action: "workspace.create",
+				Target: ws.ID.Hex(),
+			})
+
+			ws.Role = RoleOwner
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(ws)
+
+		default:
+			apierror.MethodNotAllowed(w, r)
+		}
+	}
+}
+
+// MembersHandler serves /api/v1/workspaces/{workspace}/members. Any member
+// can list members (GET); only owners can add or change members (POST) and
+// remove them (DELETE .../members/{username}). Members may remove
+// themselves. The workspace's creator stays an owner: nobody can demote or
+// remove them. The deprecated /api/workspaces/members takes the workspace
+// and username from the query string or the POST body instead.
+func MembersHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("user").(string)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		caller, err := lookupUser(ctx, db, username)
+		if err != nil {
+			apierror.Unauthorized(w, r, "User not found")
+			return
+		}
+
+		var req MemberRequest
+		switch r.Method {
+		case http.MethodGet, http.MethodDelete:
+			req.Workspace = r.URL.Query().Get("workspace")
+			req.Username = r.URL.Query().Get("username")
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				apierror.BadRequest(w, r, "Invalid request")
+				return
+			}
+		default:
+			apierror.MethodNotAllowed(w, r)
+			return
+		}
+		if id := r.PathValue("workspace"); id != "" {
+			req.Workspace = id
+		}
+		if name := r.PathValue("username"); name != "" {
+			req.Username = name
+		}
+
+		wsOID, err := primitive.ObjectIDFromHex(req.Workspace)
+		if err != nil {
+			apierror.BadRequest(w, r, "Invalid workspace")
+			return
+		}
+		callerRole, err := MemberRole(ctx, db, req.Workspace, caller.ID.Hex())
+		if err == ErrNotMember {
+			apierror.NotFound(w, r, "Workspace not found")
+			return
+		}
+		if err != nil {
+			apierror.Internal(w, r, "Server error", err)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			result, err := db.Members(ctx, wsOID)
+			if err != nil {
+				apierror.Internal(w, r, "Failed to list members", err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+
+		case http.MethodPost:
+			if callerRole != RoleOwner {
+				apierror.Forbidden(w, r, "Forbidden")
+				return
+			}
+			if !validRole(req.Role) || req.Username == "" {
+				apierror.BadRequest(w, r, "Invalid request")
+				return
+			}
+			if req.Username == username && req.Role != RoleOwner {
+				apierror.BadRequest(w, r, "Owners cannot demote themselves")
+				return
+			}
+			target, err := lookupUser(ctx, db, req.Username)
+			if err != nil {
+				apierror.NotFound(w, r, "User not found")
+				return
+			}
+			if req.Role != RoleOwner {
+				ownerID, err := creator(ctx, db, wsOID)
+				if err != nil {
+					apierror.Internal(w, r, "Server error", err)
+					return
+				}
+				if target.ID == ownerID {
+					apierror.Forbidden(w, r, "The workspace creator cannot be demoted")
+					return
+				}
+			}
+
+			member := Member{
+				WorkspaceID: wsOID,
+				UserID:      target.ID,
+				Username:    target.Username,
+				Role:        req.Role,
+				AddedAt:     time.Now().UTC(),
+			}
+			if err := db.SetMember(ctx, member); err != nil {
+				apierror.Internal(w, r, "Failed to add member", err)
+				return
+			}
+
+			audit.RecordRequest(r, db, audit.Event{
+				Actor:   username,
+				Action:  "workspace.member.set",
+				Target:  req.Workspace,
+				Details: map[string]interface{}{"username": target.Username, "role": req.Role},
+			})
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(member)
+
+		case http.MethodDelete:
+			if req.Username == "" {
+				apierror.BadRequest(w, r, "Invalid request")
+				return
+			}
+			if callerRole != RoleOwner && req.Username != username {
+				apierror.Forbidden(w, r, "Forbidden")
+				return
+			}
+			if callerRole == RoleOwner && req.Username == username {
+				apierror.BadRequest(w, r, "Owners cannot leave their workspace")
+				return
+			}
+			if callerRole == RoleOwner {
+				ownerID, err := creator(ctx, db, wsOID)
+				if err != nil {
+					apierror.Internal(w, r, "Server error", err)
+					return
+				}
+				if target, err := lookupUser(ctx, db, req.Username); err == nil && target.ID == ownerID {
+					apierror.Forbidden(w, r, "The workspace creator cannot be removed")
+					return
+				}
+			}
+
+			err := db.RemoveMember(ctx, wsOID, req.Username)
+			if err == store.ErrNotFound {
+				apierror.NotFound(w, r, "Member not found")
+				return
+			}
+			if err != nil {
+				apierror.Internal(w, r, "Failed to remove member", err)
+				return
+			}
+
+			audit.RecordRequest(r, db, audit.Event{
+				Actor:   username,
+				Action:  "workspace.member.remove",
+				Target:  req.Workspace,
+				Details: map[string]interface{}{"username": req.Username},
+			})
+
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -39,6 +39,8 @@ def process_file():
     
     file = request.files['file']
     user_id = request.form.get('user_id')
+    document_id = request.form.get('document_id')
+    workspace_id = request.form.get('workspace_id')
     
     if not user_id:
         return jsonify({'error': 'User ID required'}), 400
@@ -80,7 +82,8 @@ def process_file():
         print("Embeddings generated.")
         
         # 5. Save
-        save_document(user_id, file.filename, chunks, embeddings, file_size)
+        save_document(user_id, file.filename, chunks, embeddings, file_size,
+                      document_id=document_id, workspace_id=workspace_id)
         print("Document saved to MongoDB.")
         
         return jsonify({'status': 'success', 'chunks': len(chunks), 'size': file_size})
@@ -24,13 +24,22 @@ def update_user_storage(user_id, delta_bytes):
         {"$inc": {"total_storage_bytes": delta_bytes}}
     )
 
+DEFAULT_QUOTA_BYTES = 50 * 1024 * 1024 # 50MB
+
+def get_user_quota(user_id):
+    # Admins can raise or lower a user's quota via the gateway admin API
+    user = users_col.find_one({"_id": ObjectId(user_id)}, {"quota_bytes": 1})
+    if user and user.get("quota_bytes"):
+        return user["quota_bytes"]
+    return DEFAULT_QUOTA_BYTES
+
 def check_quota(user_id, new_file_size):
     current = get_user_storage(user_id)
-    if current + new_file_size > 50 * 1024 * 1024: # 50MB
+    if current + new_file_size > get_user_quota(user_id):
         return False
     return True
 
-def save_document(user_id, filename, chunks, embeddings, file_size):
+def save_document(user_id, filename, chunks, embeddings, file_size, document_id=None, workspace_id=None):
     # Transactional logic ideally, but simple here
     
     # Update quota first? Or after? 
@@ -63,6 +72,12 @@ def save_document(user_id, filename, chunks, embeddings, file_size):
             # This 'doc' is just a search unit.
             "created_at": "now" # TODO: timestamp
         }
+        # Set by the gateway so chunks can be traced back to their document
+        # and, for shared knowledge bases, to the owning workspace
+        if document_id:
+            doc["document_id"] = ObjectId(document_id)
+        if workspace_id:
+            doc["workspace_id"] = ObjectId(workspace_id)
         docs.append(doc)
         
     docs_col.insert_many(docs)